@@ -0,0 +1,187 @@
+package contextwindow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Attachment is a binary part of a prompt: an image or a document. The
+// SQLite store keeps attachment data in a content-addressed blobs table, so
+// attaching the same file twice stores it once.
+//
+// The adapters send images as image blocks (Claude), image parts (OpenAI
+// chat) or input_image items (Responses), PDFs as document blocks, file
+// parts or input_file items, and text documents as documents (Claude) or
+// text (OpenAI).
+type Attachment struct {
+	MediaType string `json:"media_type"`
+	Name      string `json:"name,omitempty"`
+	Data      []byte `json:"data"`
+	// Hash is the hex SHA-256 of Data, set when the attachment is stored.
+	Hash string `json:"hash,omitempty"`
+}
+
+// NewAttachment returns an attachment of data. If mediaType is empty, it's
+// sniffed from the data.
+func NewAttachment(name, mediaType string, data []byte) Attachment {
+	if mediaType == "" {
+		mediaType = http.DetectContentType(data)
+	}
+	return Attachment{MediaType: normalizeMediaType(mediaType), Name: name, Data: data}
+}
+
+// AttachmentFromFile reads a file into an attachment named after it, with a
+// media type from its extension, or sniffed from its contents.
+func AttachmentFromFile(path string) (Attachment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("read attachment: %w", err)
+	}
+	return NewAttachment(filepath.Base(path), mime.TypeByExtension(filepath.Ext(path)), data), nil
+}
+
+// normalizeMediaType strips parameters such as charset.
+func normalizeMediaType(mediaType string) string {
+	if mt, _, err := mime.ParseMediaType(mediaType); err == nil {
+		return mt
+	}
+	return mediaType
+}
+
+// IsImage reports whether the attachment is an image models can see.
+func (a Attachment) IsImage() bool {
+	switch a.MediaType {
+	case "image/png", "image/jpeg", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// IsPDF reports whether the attachment is a PDF document.
+func (a Attachment) IsPDF() bool {
+	return a.MediaType == "application/pdf"
+}
+
+// IsText reports whether the attachment is a text document.
+func (a Attachment) IsText() bool {
+	return strings.HasPrefix(a.MediaType, "text/")
+}
+
+// validate checks that the adapters know how to send the attachment.
+func (a Attachment) validate() error {
+	if len(a.Data) == 0 {
+		return fmt.Errorf("attachment %q is empty", a.Name)
+	}
+	if !a.IsImage() && !a.IsPDF() && !a.IsText() {
+		return fmt.Errorf("attachment %q: unsupported media type %q", a.Name, a.MediaType)
+	}
+	return nil
+}
+
+// base64Data returns the attachment's data, base64-encoded.
+func (a Attachment) base64Data() string {
+	return base64.StdEncoding.EncodeToString(a.Data)
+}
+
+// dataURL returns the attachment as a data: URL.
+func (a Attachment) dataURL() string {
+	return "data:" + a.MediaType + ";base64," + a.base64Data()
+}
+
+// filename returns the attachment's name, or a generic one.
+func (a Attachment) filename() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	if exts, _ := mime.ExtensionsByType(a.MediaType); len(exts) > 0 {
+		return "attachment" + exts[0]
+	}
+	return "attachment"
+}
+
+// blobHash returns the content address of data.
+func blobHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+const (
+	// maxImageTokens is about what a model sees of the largest image it
+	// accepts before downscaling it (around 1.15 megapixels).
+	maxImageTokens = 1600
+	// pdfPageTokens is a rough cost of one PDF page: its extracted text
+	// plus its rendering as an image.
+	pdfPageTokens = 2000
+)
+
+var pdfPage = regexp.MustCompile(`/Type\s*/Page[^s]`)
+
+// attachmentTokens estimates the tokens a model spends on an attachment.
+// Images cost about one token per 750 pixels, as both Anthropic and OpenAI
+// document; PDFs are costed per page; text is tokenized.
+func attachmentTokens(tok Tokenizer, a Attachment) int {
+	switch {
+	case a.IsImage():
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(a.Data))
+		if err != nil {
+			return maxImageTokens
+		}
+		n := int(math.Ceil(float64(cfg.Width*cfg.Height) / 750))
+		return min(n, maxImageTokens)
+	case a.IsPDF():
+		return max(len(pdfPage.FindAllIndex(a.Data, -1)), 1) * pdfPageTokens
+	default:
+		return tok.CountTokens(string(a.Data))
+	}
+}
+
+// recordTokens estimates the tokens in a record, attachments included.
+func recordTokens(tok Tokenizer, r Record) int {
+	n := tok.CountTokens(r.Content)
+	for _, a := range r.Attachments {
+		n += attachmentTokens(tok, a)
+	}
+	return n
+}
+
+// AddPromptWithAttachments logs a user prompt with images or documents
+// attached to the current context.
+func (cw *ContextWindow) AddPromptWithAttachments(text string, attachments ...Attachment) error {
+	for _, a := range attachments {
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("add prompt: %w", err)
+		}
+	}
+
+	contextID, err := cw.contextIDByName(cw.currentContext)
+	if err != nil {
+		return fmt.Errorf("add prompt: %w", err)
+	}
+	rec := Record{
+		ContextID:   contextID,
+		Source:      Prompt,
+		Content:     text,
+		Live:        true,
+		Attachments: attachments,
+	}
+	rec.EstTokens = recordTokens(cw.tokenizer, rec)
+	_, err = cw.store.InsertRecord(rec)
+	if err != nil {
+		return fmt.Errorf("add prompt: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package contextwindow
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))))
+	return buf.Bytes()
+}
+
+const testPDF = "%PDF-1.4\n1 0 obj << /Type /Pages /Kids [2 0 R 3 0 R] >>\n" +
+	"2 0 obj << /Type /Page >>\n3 0 obj << /Type/Page >>\n%%EOF"
+
+func TestAttachmentTokens(t *testing.T) {
+	img := NewAttachment("shot.png", "", testPNG(t, 150, 100))
+	assert.Equal(t, "image/png", img.MediaType)
+	assert.Equal(t, 20, attachmentTokens(CL100KTokenizer(), img))
+
+	big := NewAttachment("big.png", "", testPNG(t, 2000, 2000))
+	assert.Equal(t, maxImageTokens, attachmentTokens(CL100KTokenizer(), big))
+
+	pdf := NewAttachment("doc.pdf", "application/pdf", []byte(testPDF))
+	assert.Equal(t, 2*pdfPageTokens, attachmentTokens(CL100KTokenizer(), pdf))
+
+	txt := NewAttachment("notes.txt", "text/plain; charset=utf-8", []byte("hello world"))
+	assert.Equal(t, "text/plain", txt.MediaType)
+	assert.Equal(t, tokenCount("hello world"), attachmentTokens(CL100KTokenizer(), txt))
+}
+
+func TestAttachmentsPersisted(t *testing.T) {
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err := NewContextWindow(db, &mockModel{}, "multimodal")
+	assert.NoError(t, err)
+
+	img := NewAttachment("shot.png", "", testPNG(t, 150, 100))
+	pdf := NewAttachment("doc.pdf", "application/pdf", []byte(testPDF))
+	assert.NoError(t, cw.AddPromptWithAttachments("what's this?", img, pdf))
+	assert.NoError(t, cw.AddPromptWithAttachments("and again", img))
+
+	err = cw.AddPromptWithAttachments("nope", NewAttachment("a.zip", "application/zip", []byte("PK")))
+	assert.Error(t, err)
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 2)
+	assert.Len(t, recs[0].Attachments, 2)
+	assert.Equal(t, img.Data, recs[0].Attachments[0].Data)
+	assert.Equal(t, "doc.pdf", recs[0].Attachments[1].Name)
+	assert.Equal(t, blobHash(img.Data), recs[1].Attachments[0].Hash)
+	assert.Equal(t, tokenCount("what's this?")+20+2*pdfPageTokens, recs[0].EstTokens)
+
+	var blobs int
+	assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM blobs`).Scan(&blobs))
+	assert.Equal(t, 2, blobs)
+
+	assert.NoError(t, cw.Clone("copy"))
+	assert.NoError(t, cw.SwitchContext("copy"))
+	recs, err = cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Len(t, recs[0].Attachments, 2)
+	assert.Len(t, recs[1].Attachments, 1)
+
+	assert.NoError(t, cw.DeleteContext("multimodal"))
+	assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM blobs`).Scan(&blobs))
+	assert.Equal(t, 2, blobs)
+	assert.NoError(t, cw.DeleteContext("copy"))
+	assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM blobs`).Scan(&blobs))
+	assert.Zero(t, blobs)
+}
+
+func TestAttachmentEncoding(t *testing.T) {
+	rec := Record{
+		Source:  Prompt,
+		Content: "describe these",
+		Attachments: []Attachment{
+			NewAttachment("shot.png", "", testPNG(t, 10, 10)),
+			NewAttachment("doc.pdf", "application/pdf", []byte(testPDF)),
+			NewAttachment("notes.txt", "text/plain", []byte("some notes")),
+		},
+	}
+
+	_, claude := claudeMessages([]Record{rec})
+	body, err := json.Marshal(claude)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `"type":"image"`)
+	assert.Contains(t, string(body), `"media_type":"application/pdf"`)
+	assert.Contains(t, string(body), `"title":"notes.txt"`)
+	assert.Contains(t, string(body), `"text":"describe these"`)
+
+	body, err = json.Marshal(openAIMessages([]Record{rec}))
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `"url":"data:image/png;base64,`)
+	assert.Contains(t, string(body), `"file_data":"data:application/pdf;base64,`)
+	assert.Contains(t, string(body), `"filename":"doc.pdf"`)
+	assert.Contains(t, string(body), `notes.txt:\nsome notes`)
+
+	body, err = json.Marshal(responsesUserMessage(rec))
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `"type":"input_image"`)
+	assert.Contains(t, string(body), `"image_url":"data:image/png;base64,`)
+	assert.Contains(t, string(body), `"type":"input_file"`)
+
+	body, err = json.Marshal(responsesUserMessage(Record{Source: Prompt, Content: "hi"}))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"content":"hi","role":"user"}`, string(body))
+}
@@ -2,8 +2,8 @@ package contextwindow
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"os"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
@@ -21,14 +21,17 @@ type ClaudeModel struct {
 	model        string
 	middleware   []Middleware
 	toolExecutor ToolExecutor
+	retry        *RetryPolicy
 }
 
-func NewClaudeModel(model string) (*ClaudeModel, error) {
-	apiKey := os.Getenv("ANTHROPIC_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("ANTHROPIC_API_KEY not set")
+// NewClaudeModel returns a Claude model. The API key comes from
+// ANTHROPIC_API_KEY unless set with [WithAPIKey].
+func NewClaudeModel(model string, opts ...ClientOption) (*ClaudeModel, error) {
+	cfg, err := newClientConfig("ANTHROPIC_API_KEY", opts)
+	if err != nil {
+		return nil, err
 	}
-	client := anthropic.NewClient(option.WithAPIKey(apiKey))
+	client := anthropic.NewClient(cfg.anthropicOptions()...)
 	return &ClaudeModel{
 		client: &client,
 		model:  model,
@@ -39,6 +42,17 @@ func (c *ClaudeModel) MaxTokens() int {
 	return 200_000
 }
 
+// Tokenizer approximates Claude's tokenizer; see [ClaudeTokenizer].
+func (c *ClaudeModel) Tokenizer() Tokenizer {
+	return ClaudeTokenizer()
+}
+
+// SupportsResponseSchema reports that Claude answers a response schema by
+// calling a tool shaped like it.
+func (c *ClaudeModel) SupportsResponseSchema() bool {
+	return true
+}
+
 // SetMiddleware sets the middleware for the Claude model
 func (c *ClaudeModel) SetMiddleware(middleware []Middleware) {
 	c.middleware = middleware
@@ -49,6 +63,19 @@ func (c *ClaudeModel) SetToolExecutor(executor ToolExecutor) {
 	c.toolExecutor = executor
 }
 
+// SetRetryPolicy implements RetryCapable interface
+func (c *ClaudeModel) SetRetryPolicy(policy *RetryPolicy) {
+	c.retry = policy
+}
+
+// requestOptions turns off SDK retries when we do our own.
+func (c *ClaudeModel) requestOptions() []option.RequestOption {
+	if c.retry == nil {
+		return nil
+	}
+	return []option.RequestOption{option.WithMaxRetries(0)}
+}
+
 func (c *ClaudeModel) Call(
 	ctx context.Context,
 	inputs []Record,
@@ -60,42 +87,40 @@ func (c *ClaudeModel) CallWithOpts(
 	ctx context.Context,
 	inputs []Record,
 	opts CallModelOpts,
+) ([]Record, int, error) {
+	return c.call(ctx, inputs, opts, nil)
+}
+
+// CallStream implements StreamingCapable interface
+func (c *ClaudeModel) CallStream(
+	ctx context.Context,
+	useServerSideThreading bool,
+	lastResponseID *string,
+	inputs []Record,
+	opts CallModelOpts,
+	stream chan<- StreamEvent,
+) ([]Record, *string, int, error) {
+	// Claude doesn't support server-side threading, so we always use client-side
+	events, tokensUsed, err := c.call(ctx, inputs, opts, stream)
+	return events, nil, tokensUsed, err
+}
+
+func (c *ClaudeModel) call(
+	ctx context.Context,
+	inputs []Record,
+	opts CallModelOpts,
+	stream chan<- StreamEvent,
 ) ([]Record, int, error) {
 	var availableTools []ToolDefinition
 	if c.toolExecutor != nil && !opts.DisableTools {
 		availableTools = c.toolExecutor.GetRegisteredTools()
 	}
 
-	var systemBlocks []anthropic.TextBlockParam
-	var messages []anthropic.MessageParam
-
-	for _, rec := range inputs {
-		switch rec.Source {
-		case SystemPrompt:
-			systemBlocks = append(systemBlocks, anthropic.TextBlockParam{
-				Text: rec.Content,
-			})
-		case Prompt:
-			messages = append(messages, anthropic.NewUserMessage(
-				anthropic.NewTextBlock(rec.Content),
-			))
-		case ModelResp:
-			messages = append(messages, anthropic.NewAssistantMessage(
-				anthropic.NewTextBlock(rec.Content),
-			))
-		case ToolCall, ToolOutput:
-			// For now, we'll just put the raw content in a message.
-			// This will need to be revisited.
-			messages = append(messages, anthropic.NewUserMessage(
-				anthropic.NewTextBlock(rec.Content),
-			))
-		}
-	}
+	systemBlocks, messages := claudeMessages(inputs)
 
 	params := anthropic.MessageNewParams{
-		Model:     anthropic.Model(c.model),
-		MaxTokens: 4096,
-		Messages:  messages,
+		Model:    anthropic.Model(c.model),
+		Messages: messages,
 	}
 
 	if len(systemBlocks) > 0 {
@@ -106,25 +131,73 @@ func (c *ClaudeModel) CallWithOpts(
 		tools := getClaudeToolParams(availableTools)
 		params.Tools = tools
 	}
+	claudeGenerationParams(&params, opts.GenerationParams)
+	if rs := opts.ResponseSchema; rs != nil {
+		params.Tools = append(params.Tools, claudeResponseTool(rs))
+		if len(availableTools) > 0 {
+			params.ToolChoice = anthropic.ToolChoiceUnionParam{OfAny: &anthropic.ToolChoiceAnyParam{}}
+		} else {
+			params.ToolChoice = anthropic.ToolChoiceParamOfTool(rs.Name)
+		}
+	}
+	if err := checkClaudeThinking(&params); err != nil {
+		return nil, 0, fmt.Errorf("Claude API: %w", err)
+	}
 
-	resp, err := c.client.Messages.New(ctx, params)
+	req := ModelRequest{
+		Provider: "anthropic",
+		Model:    c.model,
+		Inputs:   inputs,
+		Opts:     opts,
+	}
+	resp, err := c.send(ctx, req, params, stream)
 	if err != nil {
 		return nil, 0, fmt.Errorf("Claude API: %w", err)
 	}
 
 	var events []Record
-	totalTokens := int(resp.Usage.InputTokens + resp.Usage.OutputTokens)
+	usage := claudeUsage(resp.Usage)
+
+	loop := newToolLoop(opts)
+	for hasToolUse(resp.Content) && claudeResponseInput(resp.Content, opts.ResponseSchema) == nil {
+		if err := loop.next(events, usage.Total()); err != nil {
+			return nil, 0, err
+		}
+
+		var calls []pendingToolCall
+		for _, block := range resp.Content {
+			if block.Type == "tool_use" {
+				calls = append(calls, pendingToolCall{
+					ID:   block.ID,
+					Name: block.Name,
+					Args: string(block.Input),
+				})
+			}
+		}
+
+		results := runToolCalls(ctx, c.toolExecutor, c.middleware, stream, calls, opts.ToolTimeout)
+
+		// The tool_use blocks are replayed with the arguments the tools ran
+		// with, which a ToolApprover may have rewritten
+		ranArgs := make(map[string]string, len(calls))
+		for i, call := range calls {
+			ranArgs[call.ID] = results[i].Args
+		}
 
-	for hasToolUse(resp.Content) {
 		var assistantContent []anthropic.ContentBlockParamUnion
 
 		for _, block := range resp.Content {
-			if block.Type == "text" && block.Text != "" {
+			if block.Type == "thinking" {
+				// Extended thinking must be replayed with the tool use
+				assistantContent = append(assistantContent, anthropic.NewThinkingBlock(block.Signature, block.Thinking))
+			} else if block.Type == "redacted_thinking" {
+				assistantContent = append(assistantContent, anthropic.NewRedactedThinkingBlock(block.Data))
+			} else if block.Type == "text" && block.Text != "" {
 				assistantContent = append(assistantContent, anthropic.NewTextBlock(block.Text))
 			} else if block.Type == "tool_use" {
 				assistantContent = append(assistantContent, anthropic.NewToolUseBlock(
 					block.ID,
-					block.Input,
+					json.RawMessage(ranArgs[block.ID]),
 					block.Name,
 				))
 			}
@@ -136,54 +209,31 @@ func (c *ClaudeModel) CallWithOpts(
 		})
 
 		var toolResults []anthropic.ContentBlockParamUnion
-
-		for _, block := range resp.Content {
-			if block.Type == "tool_use" {
-				inputStr := string(block.Input)
-				for _, m := range c.middleware {
-					m.OnToolCall(ctx, block.Name, inputStr)
-				}
-
-				out, err := c.toolExecutor.ExecuteTool(ctx, block.Name, block.Input)
-				if err != nil {
-					out = fmt.Sprintf("error: %s", err)
-				}
-
-				for _, m := range c.middleware {
-					m.OnToolResult(ctx, block.Name, out, err)
-				}
-
-				call := fmt.Sprintf("%s(%s)", block.Name, inputStr)
-				events = append(events, Record{
-					Source:    ToolCall,
-					Content:   call,
-					Live:      true,
-					EstTokens: tokenCount(call),
-				})
-				events = append(events, Record{
-					Source:    ToolOutput,
-					Content:   out,
-					Live:      true,
-					EstTokens: tokenCount(out),
-				})
-
-				toolResults = append(toolResults, anthropic.NewToolResultBlock(
-					block.ID,
-					out,
-					err != nil, // isError
-				))
-			}
+		for i, call := range calls {
+			out := results[i].Output
+			events = append(events, toolCallRecords(c.Tokenizer(), call.ID, call.Name, results[i].Args, out, results[i].Err != nil)...)
+
+			toolResults = append(toolResults, anthropic.NewToolResultBlock(
+				call.ID,
+				out,
+				results[i].Err != nil, // isError
+			))
 		}
 
 		messages = append(messages, anthropic.NewUserMessage(toolResults...))
 
 		params.Messages = messages
-		resp, err = c.client.Messages.New(ctx, params)
+		if opts.ResponseSchema == nil && opts.ToolChoice.forced() {
+			params.ToolChoice = anthropic.ToolChoiceUnionParam{}
+		}
+		req.Inputs = requestInputs(inputs, events)
+		req.Round++
+		resp, err = c.send(ctx, req, params, stream)
 		if err != nil {
 			return nil, 0, fmt.Errorf("Claude API (tool continuation): %w", err)
 		}
 
-		totalTokens += int(resp.Usage.InputTokens + resp.Usage.OutputTokens)
+		usage = usage.Add(claudeUsage(resp.Usage))
 	}
 
 	var responseText string
@@ -192,15 +242,20 @@ func (c *ClaudeModel) CallWithOpts(
 			responseText += block.Text
 		}
 	}
+	if input := claudeResponseInput(resp.Content, opts.ResponseSchema); input != nil {
+		responseText = string(input)
+	}
 
 	events = append(events, Record{
 		Source:    ModelResp,
 		Content:   responseText,
 		Live:      true,
-		EstTokens: tokenCount(responseText),
+		EstTokens: c.Tokenizer().CountTokens(responseText),
+		Usage:     &usage,
+		Model:     c.model,
 	})
 
-	return events, totalTokens, nil
+	return events, usage.Total(), nil
 }
 
 // CallWithThreading implements ServerSideThreadingCapable interface
@@ -226,6 +281,144 @@ func (c *ClaudeModel) CallWithThreadingAndOpts(
 	return events, nil, tokensUsed, err
 }
 
+// send makes one Messages request on behalf of req, under ModelMiddleware.
+func (c *ClaudeModel) send(
+	ctx context.Context,
+	req ModelRequest,
+	params anthropic.MessageNewParams,
+	stream chan<- StreamEvent,
+) (*anthropic.Message, error) {
+	return sendModelRequest(ctx, c.retry, c.middleware, req, func(ctx context.Context) (*anthropic.Message, ModelResponse, error) {
+		resp, err := c.newMessage(ctx, params, stream)
+		if err != nil {
+			return nil, ModelResponse{}, err
+		}
+		return resp, ModelResponse{
+			Usage:      claudeUsage(resp.Usage),
+			StopReason: string(resp.StopReason),
+			ResponseID: resp.ID,
+		}, nil
+	})
+}
+
+// newMessage sends one Messages request, streaming text deltas to stream
+// if it isn't nil.
+func (c *ClaudeModel) newMessage(
+	ctx context.Context,
+	params anthropic.MessageNewParams,
+	stream chan<- StreamEvent,
+) (*anthropic.Message, error) {
+	if stream == nil {
+		return c.client.Messages.New(ctx, params, c.requestOptions()...)
+	}
+
+	s := c.client.Messages.NewStreaming(ctx, params, c.requestOptions()...)
+	defer s.Close()
+
+	var msg anthropic.Message
+	streamed := false
+	for s.Next() {
+		event := s.Current()
+		if err := msg.Accumulate(event); err != nil {
+			return nil, fmt.Errorf("accumulate stream: %w", err)
+		}
+		if event.Type == "content_block_delta" && event.Delta.Type == "text_delta" {
+			streamed = true
+			sendStreamEvent(ctx, stream, StreamEvent{
+				Type: StreamTextDelta,
+				Text: event.Delta.Text,
+			})
+		}
+	}
+	if err := s.Err(); err != nil {
+		if streamed {
+			return nil, &streamInterruptedError{err}
+		}
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// claudeMessages converts records to Claude system blocks and messages.
+func claudeMessages(inputs []Record) ([]anthropic.TextBlockParam, []anthropic.MessageParam) {
+	var systemBlocks []anthropic.TextBlockParam
+	var messages []anthropic.MessageParam
+
+	for _, rec := range inputs {
+		switch rec.Source {
+		case SystemPrompt:
+			systemBlocks = append(systemBlocks, anthropic.TextBlockParam{
+				Text: rec.Content,
+			})
+		case Prompt:
+			messages = append(messages, anthropic.NewUserMessage(claudePromptBlocks(rec)...))
+		case ModelResp:
+			messages = append(messages, anthropic.NewAssistantMessage(
+				anthropic.NewTextBlock(rec.Content),
+			))
+		case ToolCall, ToolOutput:
+			messages = appendClaudeToolRecord(messages, rec)
+		}
+	}
+
+	return systemBlocks, messages
+}
+
+// claudePromptBlocks converts a prompt to content blocks: its attachments,
+// as image and document blocks, followed by its text.
+func claudePromptBlocks(rec Record) []anthropic.ContentBlockParamUnion {
+	var blocks []anthropic.ContentBlockParamUnion
+	for _, a := range rec.Attachments {
+		switch {
+		case a.IsImage():
+			blocks = append(blocks, anthropic.NewImageBlockBase64(a.MediaType, a.base64Data()))
+		case a.IsPDF():
+			block := anthropic.NewDocumentBlock(anthropic.Base64PDFSourceParam{Data: a.base64Data()})
+			block.OfDocument.Title = anthropic.String(a.filename())
+			blocks = append(blocks, block)
+		default:
+			block := anthropic.NewDocumentBlock(anthropic.PlainTextSourceParam{Data: string(a.Data)})
+			block.OfDocument.Title = anthropic.String(a.filename())
+			blocks = append(blocks, block)
+		}
+	}
+	if rec.Content != "" || len(blocks) == 0 {
+		blocks = append(blocks, anthropic.NewTextBlock(rec.Content))
+	}
+	return blocks
+}
+
+// appendClaudeToolRecord replays a ToolCall or ToolOutput record as a
+// tool_use or tool_result block, merging it into the previous message when
+// that message has the same role. Records without a tool call ID (from
+// before we stored them) are sent as plain user text.
+func appendClaudeToolRecord(
+	messages []anthropic.MessageParam,
+	rec Record,
+) []anthropic.MessageParam {
+	if rec.ToolCallID == "" {
+		return append(messages, anthropic.NewUserMessage(
+			anthropic.NewTextBlock(rec.Content),
+		))
+	}
+
+	role := anthropic.MessageParamRoleUser
+	block := anthropic.NewToolResultBlock(rec.ToolCallID, rec.Content, rec.ToolError)
+	if rec.Source == ToolCall {
+		role = anthropic.MessageParamRoleAssistant
+		block = anthropic.NewToolUseBlock(rec.ToolCallID, toolArgsOrEmpty(rec), rec.ToolName)
+	}
+
+	if n := len(messages); n > 0 && messages[n-1].Role == role {
+		messages[n-1].Content = append(messages[n-1].Content, block)
+		return messages
+	}
+	return append(messages, anthropic.MessageParam{
+		Role:    role,
+		Content: []anthropic.ContentBlockParamUnion{block},
+	})
+}
+
 // hasToolUse checks if the response content contains any tool_use blocks
 func hasToolUse(content []anthropic.ContentBlockUnion) bool {
 	for _, block := range content {
@@ -236,6 +429,111 @@ func hasToolUse(content []anthropic.ContentBlockUnion) bool {
 	return false
 }
 
+// claudeDefaultMaxTokens is the output limit when none is set; Claude
+// requires one.
+const claudeDefaultMaxTokens = 4096
+
+// claudeGenerationParams maps generation settings to Messages API params.
+// Tool choice is only sent with tools.
+func claudeGenerationParams(params *anthropic.MessageNewParams, g GenerationParams) {
+	budget := g.thinkingBudget()
+	params.MaxTokens = int64(g.MaxTokens)
+	if params.MaxTokens == 0 {
+		// The budget counts against max_tokens, so leave room for the answer
+		params.MaxTokens = int64(budget + claudeDefaultMaxTokens)
+	}
+	if budget > 0 {
+		params.Thinking = anthropic.ThinkingConfigParamOfEnabled(int64(budget))
+	}
+	if g.Temperature != nil {
+		params.Temperature = anthropic.Float(*g.Temperature)
+	}
+	if g.TopP != nil {
+		params.TopP = anthropic.Float(*g.TopP)
+	}
+	if len(g.Stop) > 0 {
+		params.StopSequences = g.Stop
+	}
+
+	if len(params.Tools) == 0 {
+		return
+	}
+	switch g.ToolChoice {
+	case "":
+	case ToolChoiceAuto:
+		params.ToolChoice = anthropic.ToolChoiceUnionParam{OfAuto: &anthropic.ToolChoiceAutoParam{}}
+	case ToolChoiceNone:
+		params.ToolChoice = anthropic.ToolChoiceUnionParam{OfNone: &anthropic.ToolChoiceNoneParam{}}
+	case ToolChoiceRequired:
+		params.ToolChoice = anthropic.ToolChoiceUnionParam{OfAny: &anthropic.ToolChoiceAnyParam{}}
+	default:
+		params.ToolChoice = anthropic.ToolChoiceParamOfTool(string(g.ToolChoice))
+	}
+}
+
+// claudeMinThinkingBudget is the smallest thinking budget Claude accepts.
+const claudeMinThinkingBudget = 1024
+
+// checkClaudeThinking rejects settings the Messages API doesn't allow with
+// extended thinking, rather than making a request that's sure to fail.
+func checkClaudeThinking(params *anthropic.MessageNewParams) error {
+	thinking := params.Thinking.OfEnabled
+	if thinking == nil {
+		return nil
+	}
+	switch {
+	case thinking.BudgetTokens < claudeMinThinkingBudget:
+		return fmt.Errorf("thinking budget %d is below the minimum of %d", thinking.BudgetTokens, claudeMinThinkingBudget)
+	case params.MaxTokens <= thinking.BudgetTokens:
+		return fmt.Errorf("max tokens %d must be more than the thinking budget %d", params.MaxTokens, thinking.BudgetTokens)
+	case params.Temperature.Valid() || params.TopP.Valid():
+		return fmt.Errorf("temperature and top p can't be set with extended thinking")
+	case params.ToolChoice.OfAny != nil || params.ToolChoice.OfTool != nil:
+		return fmt.Errorf("extended thinking can't be used with a forced tool choice or a response schema")
+	}
+	return nil
+}
+
+// claudeResponseTool describes a response schema as a tool. Claude has no
+// response format parameter; the model answers by calling this tool, and the
+// tool's input is the answer.
+func claudeResponseTool(rs *ResponseSchema) anthropic.ToolUnionParam {
+	schema := anthropic.ToolInputSchemaParam{ExtraFields: map[string]any{}}
+	for k, v := range rs.Schema {
+		switch k {
+		case "type":
+		case "properties":
+			schema.Properties = v
+		case "required":
+			schema.Required = schemaStrings(v)
+		default:
+			schema.ExtraFields[k] = v
+		}
+	}
+	tool := anthropic.ToolParam{
+		Name:        rs.Name,
+		InputSchema: schema,
+	}
+	if rs.Description != "" {
+		tool.Description = anthropic.String(rs.Description)
+	}
+	return anthropic.ToolUnionParam{OfTool: &tool}
+}
+
+// claudeResponseInput returns the input of the response tool call, if the
+// model made one.
+func claudeResponseInput(content []anthropic.ContentBlockUnion, rs *ResponseSchema) json.RawMessage {
+	if rs == nil {
+		return nil
+	}
+	for _, block := range content {
+		if block.Type == "tool_use" && block.Name == rs.Name {
+			return block.Input
+		}
+	}
+	return nil
+}
+
 // getClaudeToolParams converts ToolDefinitions to Claude tool union parameters
 func getClaudeToolParams(availableTools []ToolDefinition) []anthropic.ToolUnionParam {
 	var toolParams []anthropic.ToolUnionParam
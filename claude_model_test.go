@@ -0,0 +1,59 @@
+package contextwindow
+
+import (
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaudeMessagesToolReplay(t *testing.T) {
+	inputs := []Record{
+		{Source: SystemPrompt, Content: "be terse"},
+		{Source: Prompt, Content: "list files"},
+	}
+	inputs = append(inputs, toolCallRecords(CL100KTokenizer(), "toolu_1", "ls", `{"dir":"."}`, "go.mod", false)...)
+	inputs = append(inputs, toolCallRecords(CL100KTokenizer(), "toolu_2", "cat", `{}`, "no such file", true)...)
+	inputs = append(inputs, Record{Source: ModelResp, Content: "done"})
+	inputs = append(inputs, Record{Source: ToolOutput, Content: "legacy output"})
+
+	system, messages := claudeMessages(inputs)
+	assert.Len(t, system, 1)
+	assert.Len(t, messages, 7)
+
+	assert.Equal(t, anthropic.MessageParamRoleUser, messages[0].Role)
+
+	use := messages[1]
+	assert.Equal(t, anthropic.MessageParamRoleAssistant, use.Role)
+	assert.Len(t, use.Content, 1)
+	assert.Equal(t, "toolu_1", use.Content[0].OfToolUse.ID)
+	assert.Equal(t, "ls", use.Content[0].OfToolUse.Name)
+
+	result := messages[2]
+	assert.Equal(t, anthropic.MessageParamRoleUser, result.Role)
+	assert.Equal(t, "toolu_1", result.Content[0].OfToolResult.ToolUseID)
+	assert.False(t, result.Content[0].OfToolResult.IsError.Value)
+
+	assert.Equal(t, "toolu_2", messages[3].Content[0].OfToolUse.ID)
+	assert.Equal(t, "toolu_2", messages[4].Content[0].OfToolResult.ToolUseID)
+	assert.True(t, messages[4].Content[0].OfToolResult.IsError.Value)
+
+	assert.Equal(t, anthropic.MessageParamRoleAssistant, messages[5].Role)
+	assert.NotNil(t, messages[6].Content[0].OfText)
+}
+
+func TestClaudeUsageIncludesCache(t *testing.T) {
+	u := claudeUsage(anthropic.Usage{
+		InputTokens:              10,
+		OutputTokens:             5,
+		CacheReadInputTokens:     100,
+		CacheCreationInputTokens: 20,
+	})
+	assert.Equal(t, ModelUsage{
+		InputTokens:      130,
+		OutputTokens:     5,
+		CacheReadTokens:  100,
+		CacheWriteTokens: 20,
+	}, u)
+	assert.Equal(t, 135, u.Total())
+}
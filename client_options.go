@@ -0,0 +1,124 @@
+package contextwindow
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	anthropicoption "github.com/anthropics/anthropic-sdk-go/option"
+	openaioption "github.com/openai/openai-go/v2/option"
+)
+
+// ClientOption configures the provider client of a model adapter, for
+// pointing it at a gateway, a proxy or a test server:
+//
+//	model, err := contextwindow.NewOpenAIModel(shared.ChatModelGPT4o,
+//	    contextwindow.WithBaseURL("https://gateway.internal/openai/v1"),
+//	    contextwindow.WithHeader("X-Team", "agents"),
+//	    contextwindow.WithTimeout(30*time.Second),
+//	)
+type ClientOption func(*clientConfig)
+
+type clientConfig struct {
+	apiKey     string
+	baseURL    string
+	headers    [][2]string
+	timeout    time.Duration
+	httpClient *http.Client
+}
+
+// WithAPIKey sets the API key, instead of reading it from ANTHROPIC_API_KEY
+// or OPENAI_API_KEY.
+func WithAPIKey(key string) ClientOption {
+	return func(c *clientConfig) {
+		c.apiKey = key
+	}
+}
+
+// WithBaseURL sends requests to an API-compatible server other than the
+// provider's. Gateways often authenticate with their own headers, so an API
+// key isn't required with a base URL.
+func WithBaseURL(url string) ClientOption {
+	return func(c *clientConfig) {
+		c.baseURL = url
+	}
+}
+
+// WithHeader adds a header to every request.
+func WithHeader(key, value string) ClientOption {
+	return func(c *clientConfig) {
+		c.headers = append(c.headers, [2]string{key, value})
+	}
+}
+
+// WithTimeout bounds each HTTP request, retries included separately.
+func WithTimeout(d time.Duration) ClientOption {
+	return func(c *clientConfig) {
+		c.timeout = d
+	}
+}
+
+// WithHTTPClient makes requests with a custom HTTP client, for proxies,
+// custom TLS or transport-level instrumentation.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *clientConfig) {
+		c.httpClient = client
+	}
+}
+
+// newClientConfig applies opts, taking the API key from envKey if it isn't
+// set. It's an error to have neither an API key nor a base URL.
+func newClientConfig(envKey string, opts []ClientOption) (*clientConfig, error) {
+	c := &clientConfig{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.apiKey == "" {
+		c.apiKey = os.Getenv(envKey)
+	}
+	if c.apiKey == "" && c.baseURL == "" {
+		return nil, fmt.Errorf("%s not set", envKey)
+	}
+	return c, nil
+}
+
+func (c *clientConfig) anthropicOptions() []anthropicoption.RequestOption {
+	var opts []anthropicoption.RequestOption
+	if c.apiKey != "" {
+		opts = append(opts, anthropicoption.WithAPIKey(c.apiKey))
+	}
+	if c.baseURL != "" {
+		opts = append(opts, anthropicoption.WithBaseURL(c.baseURL))
+	}
+	for _, h := range c.headers {
+		opts = append(opts, anthropicoption.WithHeader(h[0], h[1]))
+	}
+	if c.timeout > 0 {
+		opts = append(opts, anthropicoption.WithRequestTimeout(c.timeout))
+	}
+	if c.httpClient != nil {
+		opts = append(opts, anthropicoption.WithHTTPClient(c.httpClient))
+	}
+	return opts
+}
+
+func (c *clientConfig) openAIOptions() []openaioption.RequestOption {
+	var opts []openaioption.RequestOption
+	if c.apiKey != "" {
+		opts = append(opts, openaioption.WithAPIKey(c.apiKey))
+	}
+	if c.baseURL != "" {
+		opts = append(opts, openaioption.WithBaseURL(c.baseURL))
+	}
+	for _, h := range c.headers {
+		opts = append(opts, openaioption.WithHeader(h[0], h[1]))
+	}
+	if c.timeout > 0 {
+		opts = append(opts, openaioption.WithRequestTimeout(c.timeout))
+	}
+	if c.httpClient != nil {
+		opts = append(opts, openaioption.WithHTTPClient(c.httpClient))
+	}
+	return opts
+}
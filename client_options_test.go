@@ -0,0 +1,97 @@
+package contextwindow
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v2/shared"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingTransport counts the requests made through it.
+type countingTransport struct {
+	n int
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.n++
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestClientOptions(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	t.Setenv("OPENAI_API_KEY", "")
+
+	var got []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/messages":
+			io.WriteString(w, `{"id": "msg_1", "type": "message", "role": "assistant", "model": "claude-sonnet-4-5",
+				"content": [{"type": "text", "text": "hi"}], "stop_reason": "end_turn",
+				"usage": {"input_tokens": 1, "output_tokens": 1}}`)
+		case "/chat/completions":
+			io.WriteString(w, `{"id": "chatcmpl-1", "object": "chat.completion", "created": 1, "model": "gpt-4o",
+				"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "hi"}}],
+				"usage": {"prompt_tokens": 1, "completion_tokens": 1, "total_tokens": 2}}`)
+		case "/responses":
+			io.WriteString(w, `{"id": "resp_1", "object": "response", "created_at": 1, "status": "completed",
+				"model": "gpt-4o", "output": [{"type": "message", "id": "msg_1", "role": "assistant",
+				"status": "completed", "content": [{"type": "output_text", "text": "hi", "annotations": []}]}],
+				"usage": {"input_tokens": 1, "output_tokens": 1, "total_tokens": 2}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	transport := &countingTransport{}
+	opts := []ClientOption{
+		WithBaseURL(srv.URL),
+		WithAPIKey("sk-test"),
+		WithHeader("X-Team", "agents"),
+		WithTimeout(5 * time.Second),
+		WithHTTPClient(&http.Client{Transport: transport}),
+	}
+
+	claude, err := NewClaudeModel(ModelClaudeSonnet45, opts...)
+	assert.NoError(t, err)
+	chat, err := NewOpenAIModel(shared.ChatModelGPT4o, opts...)
+	assert.NoError(t, err)
+	resp, err := NewOpenAIResponsesModel(ResponsesModel4o, opts...)
+	assert.NoError(t, err)
+
+	for _, m := range []Model{claude, chat, resp} {
+		events, _, err := m.Call(context.Background(), []Record{{Source: Prompt, Content: "hello"}})
+		assert.NoError(t, err)
+		assert.Equal(t, "hi", events[len(events)-1].Content)
+	}
+
+	assert.Len(t, got, 3)
+	assert.Equal(t, 3, transport.n)
+	assert.Equal(t, "sk-test", got[0].Header.Get("X-Api-Key"))
+	assert.Equal(t, "Bearer sk-test", got[1].Header.Get("Authorization"))
+	assert.Equal(t, "Bearer sk-test", got[2].Header.Get("Authorization"))
+	for _, r := range got {
+		assert.Equal(t, "agents", r.Header.Get("X-Team"))
+	}
+}
+
+func TestClientOptionsAPIKey(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+
+	_, err := NewOpenAIModel(shared.ChatModelGPT4o)
+	assert.ErrorContains(t, err, "OPENAI_API_KEY not set")
+
+	// A gateway may authenticate some other way
+	_, err = NewOpenAIModel(shared.ChatModelGPT4o, WithBaseURL("http://gateway.invalid/v1"))
+	assert.NoError(t, err)
+
+	_, err = NewOpenAIModel(shared.ChatModelGPT4o, WithAPIKey("sk-test"))
+	assert.NoError(t, err)
+}
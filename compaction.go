@@ -0,0 +1,163 @@
+package contextwindow
+
+import (
+	"context"
+	"fmt"
+)
+
+// CompactionPolicy configures automatic compaction of the live context.
+// When set with [ContextWindow.SetCompactionPolicy], every model call first
+// checks live tokens against MaxTokens and, past TriggerRatio, summarizes
+// the oldest unprotected records with the configured [Summarizer].
+type CompactionPolicy struct {
+	// TriggerRatio is the fraction of MaxTokens at which compaction runs
+	// (e.g. 0.8).
+	TriggerRatio float64
+
+	// TargetRatio is the fraction of MaxTokens we try to get down to. The
+	// oldest unprotected records are summarized until the rest fit under
+	// it. Zero summarizes every unprotected record.
+	TargetRatio float64
+
+	// Protect reports whether a record must stay verbatim. System prompts
+	// and the pending turn (the last prompt and anything after it) are
+	// always protected.
+	Protect func(Record) bool
+
+	// OnCompact, if set, is called after each automatic compaction.
+	OnCompact func(CompactionReport)
+}
+
+// CompactionReport describes one automatic compaction.
+type CompactionReport struct {
+	Context      string
+	BeforeTokens int
+	AfterTokens  int
+	Summary      *SummaryResult
+}
+
+// SetCompactionPolicy enables automatic compaction before model calls; pass
+// nil to disable it. Compaction requires a summarizer (see SetSummarizer).
+func (cw *ContextWindow) SetCompactionPolicy(policy *CompactionPolicy) {
+	cw.compaction = policy
+}
+
+// maybeCompact runs automatic compaction on a context if there's a policy
+// and the context is over its trigger threshold.
+func (cw *ContextWindow) maybeCompact(ctx context.Context, contextID string) error {
+	policy := cw.compaction
+	if policy == nil || cw.maxTokens <= 0 {
+		return nil
+	}
+
+	recs, err := cw.store.ListLiveRecords(contextID)
+	if err != nil {
+		return fmt.Errorf("list live records: %w", err)
+	}
+
+	before := 0
+	for _, r := range recs {
+		before += r.EstTokens
+	}
+	if float64(before) < policy.TriggerRatio*float64(cw.maxTokens) {
+		return nil
+	}
+	if cw.summarizer == nil {
+		return fmt.Errorf("no summarizer configured")
+	}
+
+	replace := policy.compactable(recs, before, cw.maxTokens)
+	if len(replace) == 0 {
+		return nil
+	}
+
+	result, err := cw.summarizeRecords(ctx, contextID, replace)
+	if err != nil {
+		return err
+	}
+
+	err = cw.acceptSummaryAt(result, contextID, replace[0].Timestamp)
+	if err != nil {
+		return fmt.Errorf("accept summary: %w", err)
+	}
+
+	if policy.OnCompact != nil {
+		policy.OnCompact(CompactionReport{
+			Context:      cw.currentContext,
+			BeforeTokens: before,
+			AfterTokens:  before - result.OrigCount + result.SummaryCount,
+			Summary:      result,
+		})
+	}
+	return nil
+}
+
+// compactable picks the oldest unprotected live records whose removal brings
+// the context down to the target size. A tool round is compacted whole or
+// not at all (see recordUnits).
+func (p *CompactionPolicy) compactable(recs []Record, liveTokens, maxTokens int) []Record {
+	pending := len(recs)
+	for i := len(recs) - 1; i >= 0; i-- {
+		if recs[i].Source == Prompt {
+			pending = i
+			break
+		}
+	}
+
+	target := int(p.TargetRatio * float64(maxTokens))
+	remaining := liveTokens
+
+	var replace []Record
+	for _, unit := range recordUnits(recs[:pending]) {
+		if p.TargetRatio > 0 && remaining <= target {
+			break
+		}
+		if p.protects(unit) {
+			continue
+		}
+		replace = append(replace, unit...)
+		remaining -= unitTokens(unit)
+	}
+	return replace
+}
+
+// protects reports whether any record of a unit must stay verbatim.
+func (p *CompactionPolicy) protects(unit []Record) bool {
+	for _, r := range unit {
+		if r.Source == SystemPrompt || (p.Protect != nil && p.Protect(r)) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordUnits splits records into the units that compaction and
+// summarization keep or drop together: each run of ToolCall and ToolOutput
+// records is one unit, since providers reject a tool call replayed without
+// its output (or the reverse), and every other record is a unit of its own.
+func recordUnits(recs []Record) [][]Record {
+	isTool := func(r Record) bool {
+		return r.Source == ToolCall || r.Source == ToolOutput
+	}
+
+	var units [][]Record
+	for i := 0; i < len(recs); {
+		j := i + 1
+		if isTool(recs[i]) {
+			for j < len(recs) && isTool(recs[j]) {
+				j++
+			}
+		}
+		units = append(units, recs[i:j])
+		i = j
+	}
+	return units
+}
+
+func unitTokens(unit []Record) int {
+	n := 0
+	for _, r := range unit {
+		n += r.EstTokens
+	}
+	return n
+}
@@ -0,0 +1,171 @@
+package contextwindow
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupCompactionTest(t *testing.T) *ContextWindow {
+	t.Helper()
+	cw := setupTestDB(t)
+	cw.model = &MockModel{events: []Record{
+		{Source: ModelResp, Content: "reply", Live: true},
+	}}
+	cw.SetMaxTokens(100)
+	cw.SetSummarizer(&mockSummarizer{summaryText: "summary"})
+
+	assert.NoError(t, cw.SetSystemPrompt("system"))
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, cw.AddPrompt(strings.Repeat("word ", 10)))
+	}
+	return cw
+}
+
+func TestAutoCompaction(t *testing.T) {
+	cw := setupCompactionTest(t)
+	defer cw.Close()
+
+	var reports []CompactionReport
+	cw.SetCompactionPolicy(&CompactionPolicy{
+		TriggerRatio: 0.3,
+		TargetRatio:  0.25,
+		OnCompact: func(r CompactionReport) {
+			reports = append(reports, r)
+		},
+	})
+
+	before, err := cw.LiveTokens()
+	assert.NoError(t, err)
+
+	_, err = cw.CallModel(context.Background())
+	assert.NoError(t, err)
+
+	assert.Len(t, reports, 1)
+	assert.Equal(t, before, reports[0].BeforeTokens)
+	assert.Len(t, reports[0].Summary.Replaced, 2)
+	assert.Less(t, reports[0].AfterTokens, reports[0].BeforeTokens)
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 5)
+	assert.Equal(t, SystemPrompt, recs[0].Source)
+	assert.Equal(t, "summary", recs[1].Content)
+	assert.Equal(t, Prompt, recs[2].Source)
+	assert.Equal(t, Prompt, recs[3].Source)
+	assert.Equal(t, "reply", recs[4].Content)
+}
+
+func TestAutoCompactionProtect(t *testing.T) {
+	cw := setupCompactionTest(t)
+	defer cw.Close()
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	keep := recs[1].ID
+
+	var reports []CompactionReport
+	cw.SetCompactionPolicy(&CompactionPolicy{
+		TriggerRatio: 0.3,
+		Protect: func(r Record) bool {
+			return r.ID == keep
+		},
+		OnCompact: func(r CompactionReport) {
+			reports = append(reports, r)
+		},
+	})
+
+	_, err = cw.CallModel(context.Background())
+	assert.NoError(t, err)
+
+	assert.Len(t, reports, 1)
+	assert.Len(t, reports[0].Summary.Replaced, 2)
+	for _, r := range reports[0].Summary.Replaced {
+		assert.NotEqual(t, keep, r.ID)
+		assert.NotEqual(t, SystemPrompt, r.Source)
+	}
+
+	recs, err = cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Equal(t, keep, recs[1].ID)
+}
+
+func TestAutoCompactionBelowThreshold(t *testing.T) {
+	cw := setupCompactionTest(t)
+	defer cw.Close()
+
+	called := false
+	cw.SetCompactionPolicy(&CompactionPolicy{
+		TriggerRatio: 0.9,
+		OnCompact: func(CompactionReport) {
+			called = true
+		},
+	})
+
+	_, err := cw.CallModel(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, called)
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 6)
+}
+
+func TestAutoCompactionNoSummarizer(t *testing.T) {
+	cw := setupCompactionTest(t)
+	defer cw.Close()
+
+	cw.SetSummarizer(nil)
+	cw.SetCompactionPolicy(&CompactionPolicy{TriggerRatio: 0.3})
+
+	_, err := cw.CallModel(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no summarizer configured")
+}
+
+func TestAutoCompactionKeepsToolRoundsWhole(t *testing.T) {
+	cw := setupTestDB(t)
+	defer cw.Close()
+	cw.model = &MockModel{events: []Record{
+		{Source: ModelResp, Content: "reply", Live: true},
+	}}
+	cw.SetMaxTokens(100)
+	cw.SetSummarizer(&mockSummarizer{summaryText: "summary"})
+
+	assert.NoError(t, cw.AddPrompt(strings.Repeat("word ", 10)))
+	assert.NoError(t, cw.AddToolCallWithID("call_1", "lookup", "{}"))
+	assert.NoError(t, cw.AddToolOutputWithID("call_1", "lookup", strings.Repeat("found ", 10)))
+	assert.NoError(t, cw.AddPrompt(strings.Repeat("word ", 10)))
+	assert.NoError(t, cw.AddPrompt(strings.Repeat("word ", 10)))
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	live := 0
+	for _, r := range recs {
+		live += r.EstTokens
+	}
+
+	// The target is reached once the tool call is gone, before its output
+	target := live - recs[0].EstTokens - recs[1].EstTokens
+	var reports []CompactionReport
+	cw.SetCompactionPolicy(&CompactionPolicy{
+		TriggerRatio: 0.3,
+		TargetRatio:  (float64(target) + 0.5) / 100,
+		OnCompact: func(r CompactionReport) {
+			reports = append(reports, r)
+		},
+	})
+
+	_, err = cw.CallModel(context.Background())
+	assert.NoError(t, err)
+
+	if assert.Len(t, reports, 1) {
+		replaced := reports[0].Summary.Replaced
+		if assert.Len(t, replaced, 3) {
+			assert.Equal(t, ToolCall, replaced[1].Source)
+			assert.Equal(t, ToolOutput, replaced[2].Source)
+		}
+	}
+}
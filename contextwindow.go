@@ -31,6 +31,14 @@
 // Use [ContextWindow.SetSystemPrompt] to provide a system prompt for each
 // conversation.
 //
+// # Images and documents
+//
+// Attach screenshots, PDFs and text files to a prompt with
+// [ContextWindow.AddPromptWithAttachments]:
+//
+//	    shot, err := contextwindow.AttachmentFromFile("screenshot.png")
+//	    err = cw.AddPromptWithAttachments("What's wrong with this page?", shot)
+//
 // # Tool calling
 //
 // Instruct LLMs to call tools locally with [ContextWindow.AddTool] (and [NewTool]).
@@ -49,16 +57,91 @@
 //	      return "here\nare\nsome\nfiles.exe\n", nil
 //	    })
 //
-// You can selectively enable and disable tools with [ContextWindow.CallModelWithOpts].
+// You can selectively enable and disable tools with [ContextWindow.CallModelWithOpts],
+// which also bounds tool loops (MaxToolRounds, ToolTimeout, TokenBudget and
+// TimeBudget in [CallModelOpts]); a call that hits a limit returns a
+// [ToolLoopError].
+//
+// Middleware only observes tool calls. To gate them (say, for a shell tool),
+// install a [ToolApprover] with [ContextWindow.SetToolApprover]; it can allow a
+// call, deny it with a reason the model sees as the tool's output, or rewrite
+// its arguments.
+//
+// Middleware that also implements [ModelMiddleware] sees every request the
+// adapters make (inputs, usage, latency, stop reason, errors), which is the
+// place to hang logging and tracing.
+//
+// Set a [RetryPolicy] with [ContextWindow.SetRetryPolicy] to retry rate
+// limits and overloads with backoff on every request, including those in the
+// middle of a tool loop.
+//
+// Registered tools are enabled only in the context that was current when they
+// were added. Use [ContextWindow.EnableTool] and [ContextWindow.DisableTool] to
+// give each context (say, a planner and an executor) its own tool set.
 //
 // Tool calls are very sensitive to the descriptions provided of the tool and arguments
 // (the example up there is way too simple). Treat descriptions like part of the system
 // prompt; tell the agent what to do.
 //
+// # Streaming
+//
+// [ContextWindow.CallModelStream] returns a channel of [StreamEvent]s (text
+// deltas, tool calls and results, usage) as the call proceeds, and persists
+// records just like [ContextWindow.CallModel].
+//
+//	    stream, err := cw.CallModelStream(ctx, contextwindow.CallModelOpts{})
+//	    for ev := range stream {
+//	      if ev.Type == contextwindow.StreamTextDelta {
+//	        fmt.Print(ev.Text)
+//	      }
+//	    }
+//
+// # Structured output
+//
+// [ContextWindow.CallModelStructured] asks for a JSON answer matching a
+// schema, derived from a Go type with [SchemaFor] unless one is given, and
+// unmarshals it. OpenAI models get the schema as a response format, Claude
+// as a tool it must call; invalid answers are retried.
+//
+//	    var v struct {
+//	      Safe bool `json:"safe"`
+//	    }
+//	    err := cw.CallModelStructured(ctx, &v, contextwindow.StructuredOpts{})
+//
+// # Generation settings
+//
+// [GenerationParams] (output limit, temperature, top_p, stop sequences, tool
+// choice, reasoning effort or thinking budget) can be set per call in
+// [CallModelOpts], or as defaults persisted with a context:
+//
+//	    cw.SetGenerationDefaults(contextwindow.GenerationParams{
+//	      Temperature:     contextwindow.Float(0.2),
+//	      ReasoningEffort: contextwindow.ReasoningLow,
+//	    })
+//
+// # Rewinding
+//
+// [ContextWindow.Rewind] undoes the last turns of a context, and
+// [ContextWindow.RewindTo] rewinds to just after a record; rewound records
+// are kept, not live, and server-side threading continues from where the
+// context was. Until something new is added, [ContextWindow.Redo] undoes a
+// rewind:
+//
+//	    cw.Rewind(1) // the tool loop went sideways
+//
+// # Search
+//
+// Records that aren't live are kept, and [ContextWindow.Search] finds them,
+// live or not, with a full-text index over every context:
+//
+//	    results, err := cw.Search(contextwindow.SearchQuery{Query: "deploy error"})
+//
 // # Summarization
 //
-// Models have context token limits (we use [github.com/peterheb/gotoken/cl100kbase] to
-// track usage); both input and output tokens count towards the limit.
+// Models have context token limits (we estimate usage with the model's
+// [Tokenizer], cl100k_base by default, or whatever you pass to
+// [ContextWindow.SetTokenizer]); both input and output tokens count towards
+// the limit.
 //
 // You can provide a summarizer model to automatically compact your context window:
 //
@@ -70,6 +153,18 @@
 //	    cw, err := contextwindow.New(model, summarizerModel, "")
 //
 // And then "compress" your context with [ContextWindow.SummarizeLiveContent].
+// To keep the last few exchanges verbatim, summarize just the older part:
+//
+//	    result, err := cw.SummarizeLiveContextWithOpts(ctx, contextwindow.SummarizeOpts{
+//	        KeepTurns: 3,
+//	    })
+//
+// Or let calls compact automatically once the context gets close to full:
+//
+//	    cw.SetCompactionPolicy(&contextwindow.CompactionPolicy{
+//	        TriggerRatio: 0.8,
+//	        TargetRatio:  0.5,
+//	    })
 //
 // # Under the hood
 //
@@ -80,6 +175,15 @@
 //
 // LLM conversations are stored in SQLite. If you don't care about persistant
 // storage for your context, just specify ":memory:" as your database path.
+// Storage goes through the [Store] interface, so you can also bring your own
+// with [NewContextWindowWithStore], or skip SQL entirely with [MemoryStore].
+//
+// Each model call records its token usage and its dollar cost, priced from a
+// [PriceTable] (override the defaults with [ContextWindow.SetPriceTable]).
+// [ContextWindow.Spend] and [ContextWindow.ContextSpend] total them over a
+// time range. Every call also gets a [ModelCall] entry in a persistent
+// ledger (latency, stop reason, the records it produced); read it back with
+// [ContextWindow.GetModelCalls] and [ContextWindow.ModelCalls].
 //
 // # Thread Safety
 //
@@ -102,16 +206,14 @@ package contextwindow
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 
-	"github.com/peterheb/gotoken"
-	_ "github.com/peterheb/gotoken/cl100kbase"
 	_ "modernc.org/sqlite"
 )
 
@@ -157,7 +259,8 @@ type CallOptsCapable interface {
 	) (events []Record, responseID *string, tokensUsed int, err error)
 }
 
-// Middleware allows hooking into tool call lifecycle events.
+// Middleware allows hooking into tool call lifecycle events. Tool calls from
+// one model turn run concurrently, so hooks must be safe for concurrent use.
 type Middleware interface {
 	// OnToolCall is invoked when a tool is about to be called.
 	OnToolCall(ctx context.Context, name, args string)
@@ -168,15 +271,21 @@ type Middleware interface {
 // ContextWindow holds our LLM context manager state.
 type ContextWindow struct {
 	model            Model
-	db               *sql.DB
+	store            Store
 	maxTokens        int
 	summarizer       Summarizer
 	summarizerPrompt string
+	compaction       *CompactionPolicy
 	middleware       []Middleware
 	metrics          *Metrics
 	currentContext   string
 	registeredTools  map[string]ToolDefinition
 	toolRunners      map[string]ToolRunner
+	toolConcurrency  int
+	toolApprover     ToolApprover
+	prices           PriceTable
+	tokenizer        Tokenizer
+	rewinds          map[string][]rewind // by context ID, for Redo
 }
 
 // ContextReader provides thread-safe read access to context window data.
@@ -197,6 +306,10 @@ func NewContextDB(dbpath string) (*sql.DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("open db: %w", err)
 	}
+	if dbpath == ":memory:" {
+		// Every connection to ":memory:" gets its own empty database.
+		db.SetMaxOpenConns(1)
+	}
 	if err = InitializeSchema(db); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("init schema: %w", err)
@@ -223,6 +336,26 @@ func NewContextWindowWithThreading(
 	model Model,
 	contextName string,
 	useServerSideThreading bool,
+) (*ContextWindow, error) {
+	return newContextWindow(NewSQLiteStore(db), model, contextName, useServerSideThreading)
+}
+
+// NewContextWindowWithStore initializes a ContextWindow backed by any
+// [Store], such as a [MemoryStore]. Closing the context window closes the
+// store.
+func NewContextWindowWithStore(
+	store Store,
+	model Model,
+	contextName string,
+) (*ContextWindow, error) {
+	return newContextWindow(store, model, contextName, false)
+}
+
+func newContextWindow(
+	store Store,
+	model Model,
+	contextName string,
+	useServerSideThreading bool,
 ) (*ContextWindow, error) {
 	if contextName == "" {
 		contextName = uuid.New().String()
@@ -230,12 +363,19 @@ func NewContextWindowWithThreading(
 
 	cw := &ContextWindow{
 		model:           model,
-		db:              db,
+		store:           store,
 		maxTokens:       4096,
 		metrics:         &Metrics{},
 		currentContext:  contextName,
 		registeredTools: make(map[string]ToolDefinition),
 		toolRunners:     make(map[string]ToolRunner),
+		toolConcurrency: DefaultToolConcurrency,
+		prices:          DefaultPriceTable(),
+		tokenizer:       CL100KTokenizer(),
+	}
+
+	if tokenizerCapable, ok := model.(TokenizerCapable); ok {
+		cw.tokenizer = tokenizerCapable.Tokenizer()
 	}
 
 	// If the model supports tool execution, configure it
@@ -243,10 +383,10 @@ func NewContextWindowWithThreading(
 		toolCapable.SetToolExecutor(cw)
 	}
 
-	_, err := GetContextByName(db, contextName)
+	c, err := store.GetContextByName(contextName)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			_, err = CreateContextWithThreading(db, contextName, useServerSideThreading)
+			c, err = store.CreateContext(contextName, useServerSideThreading)
 			if err != nil {
 				return nil, fmt.Errorf("create context: %w", err)
 			}
@@ -255,26 +395,45 @@ func NewContextWindowWithThreading(
 		}
 	}
 
+	if err := cw.syncTokenizer(c.ID); err != nil {
+		return nil, fmt.Errorf("sync tokenizer: %w", err)
+	}
+
 	return cw, nil
 }
 
+// contextIDByName looks up the ID of a named context.
+func (cw *ContextWindow) contextIDByName(name string) (string, error) {
+	c, err := cw.store.GetContextByName(name)
+	if err != nil {
+		return "", err
+	}
+	return c.ID, nil
+}
+
 // Close closes the database connection. Only call this if you opened the database
 // using NewContextWindow or NewContextWindowWithContext. If you used
 // NewContextWindowWithDB, you should close the database yourself.
 func (cw *ContextWindow) Close() error {
-	if cw.db == nil {
+	if cw.store == nil {
 		return nil
 	}
-	return cw.db.Close()
+	return cw.store.Close()
 }
 
 // AddPrompt logs a user prompt to the current context.
 func (cw *ContextWindow) AddPrompt(text string) error {
-	contextID, err := getContextIDByName(cw.db, cw.currentContext)
+	contextID, err := cw.contextIDByName(cw.currentContext)
 	if err != nil {
 		return fmt.Errorf("add prompt: %w", err)
 	}
-	_, err = InsertRecord(cw.db, contextID, Prompt, text, true)
+	_, err = cw.store.InsertRecord(Record{
+		ContextID: contextID,
+		Source:    Prompt,
+		Content:   text,
+		Live:      true,
+		EstTokens: cw.countTokens(text),
+	})
 	if err != nil {
 		return fmt.Errorf("add prompt: %w", err)
 	}
@@ -283,12 +442,44 @@ func (cw *ContextWindow) AddPrompt(text string) error {
 
 // AddToolCall logs a tool invocation to the current context.
 func (cw *ContextWindow) AddToolCall(name, args string) error {
-	contextID, err := getContextIDByName(cw.db, cw.currentContext)
+	contextID, err := cw.contextIDByName(cw.currentContext)
+	if err != nil {
+		return fmt.Errorf("add tool call: %w", err)
+	}
+	content := fmt.Sprintf("%s(%s)", name, args)
+	_, err = cw.store.InsertRecord(Record{
+		ContextID: contextID,
+		Source:    ToolCall,
+		Content:   content,
+		Live:      true,
+		EstTokens: cw.countTokens(content),
+		ToolName:  name,
+		ToolArgs:  rawToolArgs(args),
+	})
+	if err != nil {
+		return fmt.Errorf("add tool call: %w", err)
+	}
+	return nil
+}
+
+// AddToolCallWithID logs a tool invocation with the provider's tool call ID,
+// so that it can be replayed to the model as a structured tool call.
+func (cw *ContextWindow) AddToolCallWithID(id, name, args string) error {
+	contextID, err := cw.contextIDByName(cw.currentContext)
 	if err != nil {
 		return fmt.Errorf("add tool call: %w", err)
 	}
 	content := fmt.Sprintf("%s(%s)", name, args)
-	_, err = InsertRecord(cw.db, contextID, ToolCall, content, true)
+	_, err = cw.store.InsertRecord(Record{
+		ContextID:  contextID,
+		Source:     ToolCall,
+		Content:    content,
+		Live:       true,
+		EstTokens:  cw.countTokens(content),
+		ToolCallID: id,
+		ToolName:   name,
+		ToolArgs:   rawToolArgs(args),
+	})
 	if err != nil {
 		return fmt.Errorf("add tool call: %w", err)
 	}
@@ -297,11 +488,38 @@ func (cw *ContextWindow) AddToolCall(name, args string) error {
 
 // AddToolOutput logs a tool's output to the current context.
 func (cw *ContextWindow) AddToolOutput(output string) error {
-	contextID, err := getContextIDByName(cw.db, cw.currentContext)
+	contextID, err := cw.contextIDByName(cw.currentContext)
 	if err != nil {
 		return fmt.Errorf("add tool output: %w", err)
 	}
-	_, err = InsertRecord(cw.db, contextID, ToolOutput, output, true)
+	_, err = cw.store.InsertRecord(Record{
+		ContextID: contextID,
+		Source:    ToolOutput,
+		Content:   output,
+		Live:      true,
+		EstTokens: cw.countTokens(output),
+	})
+	if err != nil {
+		return fmt.Errorf("add tool output: %w", err)
+	}
+	return nil
+}
+
+// AddToolOutputWithID logs a tool's output for the tool call with the given ID.
+func (cw *ContextWindow) AddToolOutputWithID(id, name, output string) error {
+	contextID, err := cw.contextIDByName(cw.currentContext)
+	if err != nil {
+		return fmt.Errorf("add tool output: %w", err)
+	}
+	_, err = cw.store.InsertRecord(Record{
+		ContextID:  contextID,
+		Source:     ToolOutput,
+		Content:    output,
+		Live:       true,
+		EstTokens:  cw.countTokens(output),
+		ToolCallID: id,
+		ToolName:   name,
+	})
 	if err != nil {
 		return fmt.Errorf("add tool output: %w", err)
 	}
@@ -333,52 +551,47 @@ func (cw *ContextWindow) SetRecordLiveStateByRange(startIndex, endIndex int, liv
 		return fmt.Errorf("endIndex %d out of range (have %d records)", endIndex, len(liveRecords))
 	}
 
-	tx, err := cw.db.Begin()
-	if err != nil {
-		return fmt.Errorf("set record live state by range: %w", err)
-	}
-	defer tx.Rollback()
-
-	liveValue := 0
-	if live {
-		liveValue = 1
-	}
-
+	var ids []int64
 	for i := startIndex; i <= endIndex; i++ {
-		recordID := liveRecords[i].ID
-		_, err = tx.Exec(`UPDATE records SET live = ? WHERE id = ?`, liveValue, recordID)
-		if err != nil {
-			return fmt.Errorf("set record %d live state: %w", recordID, err)
-		}
+		ids = append(ids, liveRecords[i].ID)
 	}
 
-	return tx.Commit()
+	if err := cw.store.SetRecordsLive(ids, live); err != nil {
+		return fmt.Errorf("set record live state by range: %w", err)
+	}
+	return nil
 }
 
 // SetSystemPrompt sets the system prompt for the current context.
 func (cw *ContextWindow) SetSystemPrompt(text string) error {
-	contextID, err := getContextIDByName(cw.db, cw.currentContext)
+	contextID, err := cw.contextIDByName(cw.currentContext)
 	if err != nil {
 		return fmt.Errorf("set system prompt: %w", err)
 	}
 
-	tx, err := cw.db.Begin()
+	recs, err := cw.store.ListLiveRecords(contextID)
 	if err != nil {
 		return fmt.Errorf("set system prompt: %w", err)
 	}
-	defer tx.Rollback()
 
-	_, err = tx.Exec(`UPDATE records SET live = 0 WHERE context_id = ? AND source = ?`, contextID, SystemPrompt)
-	if err != nil {
-		return fmt.Errorf("set system prompt: %w", err)
+	var old []int64
+	for _, r := range recs {
+		if r.Source == SystemPrompt {
+			old = append(old, r.ID)
+		}
 	}
 
-	_, err = insertRecordTx(tx, contextID, SystemPrompt, text, true)
+	_, err = cw.store.ReplaceRecords(old, []Record{{
+		ContextID: contextID,
+		Source:    SystemPrompt,
+		Content:   text,
+		Live:      true,
+		EstTokens: cw.countTokens(text),
+	}})
 	if err != nil {
 		return fmt.Errorf("set system prompt: %w", err)
 	}
-
-	return tx.Commit()
+	return nil
 }
 
 // AddMiddleware registers middleware to hook into tool call events.
@@ -395,11 +608,11 @@ func (cw *ContextWindow) AddMiddleware(m Middleware) {
 // what's currently meaningful in your context --- it's what gets sent
 // to the LLM.
 func (cw *ContextWindow) LiveRecords() ([]Record, error) {
-	contextID, err := getContextIDByName(cw.db, cw.currentContext)
+	contextID, err := cw.contextIDByName(cw.currentContext)
 	if err != nil {
 		return nil, fmt.Errorf("live records: %w", err)
 	}
-	recs, err := ListLiveRecords(cw.db, contextID)
+	recs, err := cw.store.ListLiveRecords(contextID)
 	if err != nil {
 		return nil, fmt.Errorf("live records: %w", err)
 	}
@@ -409,6 +622,31 @@ func (cw *ContextWindow) LiveRecords() ([]Record, error) {
 // CallModelOpts contains options for model calls.
 type CallModelOpts struct {
 	DisableTools bool
+
+	// GenerationParams override the context's defaults (see
+	// [ContextWindow.SetGenerationDefaults]) for this call.
+	GenerationParams
+
+	// MaxToolRounds caps how many times the model can call tools and be
+	// called back with their results. Zero means no limit.
+	MaxToolRounds int
+
+	// ToolTimeout bounds each tool call; the context passed to
+	// ToolRunner.Run is cancelled when it expires. Zero means no timeout.
+	ToolTimeout time.Duration
+
+	// TokenBudget and TimeBudget stop the tool loop once the call has used
+	// that many tokens or run that long. They're checked before each tool
+	// round, so a call can overshoot by one model response. Zero means no
+	// limit.
+	TokenBudget int
+	TimeBudget  time.Duration
+
+	// ResponseSchema asks the model to answer with JSON matching a schema,
+	// using the provider's structured-output feature. Most callers want
+	// [ContextWindow.CallModelStructured] instead, which also validates the
+	// answer.
+	ResponseSchema *ResponseSchema
 }
 
 // CallModel drives an LLM. It composes live messages, invokes cw.model.Call,
@@ -420,18 +658,37 @@ func (cw *ContextWindow) CallModel(ctx context.Context) (string, error) {
 // CallModelWithOpts drives an LLM with options. It composes live messages, invokes cw.model.Call,
 // logs the response, updates token count, and triggers compaction.
 func (cw *ContextWindow) CallModelWithOpts(ctx context.Context, opts CallModelOpts) (string, error) {
-	contextID, err := getContextIDByName(cw.db, cw.currentContext)
+	return cw.callModel(ctx, opts, nil)
+}
+
+// callModel implements CallModelWithOpts and CallModelStream; stream is nil
+// for non-streaming calls.
+func (cw *ContextWindow) callModel(
+	ctx context.Context,
+	opts CallModelOpts,
+	stream chan<- StreamEvent,
+) (string, error) {
+	contextID, err := cw.contextIDByName(cw.currentContext)
 	if err != nil {
 		return "", fmt.Errorf("call model in context: %w", err)
 	}
 
+	if err := cw.maybeCompact(ctx, contextID); err != nil {
+		return "", fmt.Errorf("auto compact: %w", err)
+	}
+
 	// Get current context info to check threading mode
-	contextInfo, err := GetContext(cw.db, contextID)
+	contextInfo, err := cw.store.GetContext(contextID)
 	if err != nil {
 		return "", fmt.Errorf("get context info: %w", err)
 	}
 
-	recs, err := ListLiveRecords(cw.db, contextID)
+	opts.GenerationParams = opts.GenerationParams.withDefaults(contextInfo.Generation)
+	if err := opts.GenerationParams.validate(); err != nil {
+		return "", fmt.Errorf("call model: %w", err)
+	}
+
+	recs, err := cw.store.ListLiveRecords(contextID)
 	if err != nil {
 		return "", fmt.Errorf("list live records: %w", err)
 	}
@@ -439,12 +696,32 @@ func (cw *ContextWindow) CallModelWithOpts(ctx context.Context, opts CallModelOp
 	var events []Record
 	var tokensUsed int
 	var responseID *string
+	var loopErr *ToolLoopError
+
+	obs := &modelCallObserver{}
+	ctx = withModelCallObserver(ctx, obs)
+	started := time.Now()
 
 	// Serverside threading (`previous_response_id`) sends only the most recent prompt
 	// and a backlink to the last response, rather than sending the entire thread on
 	// every LLM call.
 	// TODO(tqbf): this stuff needs better testing; I don't really use it.
-	if contextInfo.UseServerSideThreading {
+	streamModel, canStream := cw.model.(StreamingCapable)
+	if stream != nil && canStream {
+		events, responseID, tokensUsed, err = streamModel.CallStream(
+			ctx,
+			contextInfo.UseServerSideThreading,
+			contextInfo.LastResponseID,
+			recs,
+			opts,
+			stream,
+		)
+		if errors.As(err, &loopErr) {
+			events, tokensUsed = loopErr.Events, loopErr.TokensUsed
+		} else if err != nil {
+			return "", cw.failModelCall(contextID, obs, started, fmt.Errorf("call model stream: %w", err))
+		}
+	} else if contextInfo.UseServerSideThreading {
 		if threadingModel, ok := cw.model.(ServerSideThreadingCapable); ok {
 			if optsModel, ok := threadingModel.(CallOptsCapable); ok {
 				events, responseID, tokensUsed, err = optsModel.CallWithThreadingAndOpts(
@@ -462,8 +739,10 @@ func (cw *ContextWindow) CallModelWithOpts(ctx context.Context, opts CallModelOp
 					recs,
 				)
 			}
-			if err != nil {
-				return "", fmt.Errorf("call model with threading: %w", err)
+			if errors.As(err, &loopErr) {
+				events, tokensUsed = loopErr.Events, loopErr.TokensUsed
+			} else if err != nil {
+				return "", cw.failModelCall(contextID, obs, started, fmt.Errorf("call model with threading: %w", err))
 			}
 		} else {
 			return "", fmt.Errorf("model does not support server-side threading")
@@ -475,31 +754,59 @@ func (cw *ContextWindow) CallModelWithOpts(ctx context.Context, opts CallModelOp
 		} else {
 			events, tokensUsed, err = cw.model.Call(ctx, recs)
 		}
-		if err != nil {
-			return "", fmt.Errorf("call model: %w", err)
+		if errors.As(err, &loopErr) {
+			events, tokensUsed = loopErr.Events, loopErr.TokensUsed
+		} else if err != nil {
+			return "", cw.failModelCall(contextID, obs, started, fmt.Errorf("call model: %w", err))
+		}
+	}
+
+	if stream != nil && !canStream {
+		for _, event := range events {
+			if event.Source == ModelResp {
+				sendStreamEvent(ctx, stream, StreamEvent{
+					Type: StreamTextDelta,
+					Text: event.Content,
+				})
+			}
 		}
 	}
+	sendStreamEvent(ctx, stream, StreamEvent{
+		Type:       StreamUsage,
+		TokensUsed: tokensUsed,
+	})
 
 	cw.metrics.Add(tokensUsed)
 	var lastMsg string
-	for _, event := range events {
-		_, err = InsertRecordWithResponseID(
-			cw.db,
-			contextID,
-			event.Source,
-			event.Content,
-			event.Live,
-			event.ResponseID,
-		)
+	for i, event := range events {
+		if event.Usage != nil {
+			event.Cost = cw.prices.Cost(event.Model, *event.Usage)
+		}
+		event.ContextID = contextID
+		event.EstTokens = cw.countTokens(event.Content)
+		events[i], err = cw.store.InsertRecord(event)
 		if err != nil {
 			return "", fmt.Errorf("insert model response: %w", err)
 		}
 		lastMsg = event.Content
 	}
 
+	var callErr error
+	if loopErr != nil {
+		callErr = loopErr
+	}
+	call := cw.ledgerEntry(contextID, obs, started, tokensUsed, events, callErr)
+	if err := cw.recordModelCall(call); err != nil {
+		return "", err
+	}
+
+	if loopErr != nil {
+		return "", loopErr
+	}
+
 	// Update the context's last response ID if we got one
 	if responseID != nil {
-		err = UpdateContextLastResponseID(cw.db, contextID, *responseID)
+		err = cw.store.UpdateLastResponseID(contextID, *responseID)
 		if err != nil {
 			return lastMsg, fmt.Errorf("update last response ID: %w", err)
 		}
@@ -508,6 +815,32 @@ func (cw *ContextWindow) CallModelWithOpts(ctx context.Context, opts CallModelOp
 	return lastMsg, nil
 }
 
+// failModelCall records a call that failed in the ledger, so that the
+// tokens spent on requests that succeeded before the failure are accounted
+// for, and returns err.
+func (cw *ContextWindow) failModelCall(
+	contextID string,
+	obs *modelCallObserver,
+	started time.Time,
+	err error,
+) error {
+	call := cw.ledgerEntry(contextID, obs, started, 0, nil, err)
+	if insertErr := cw.recordModelCall(call); insertErr != nil {
+		return errors.Join(err, insertErr)
+	}
+	return err
+}
+
+// recordModelCall adds call to the ledger and to this ContextWindow's
+// usage and cost totals.
+func (cw *ContextWindow) recordModelCall(call ModelCall) error {
+	if _, err := cw.store.InsertModelCall(call); err != nil {
+		return fmt.Errorf("insert model call: %w", err)
+	}
+	cw.metrics.AddUsage(call.Usage, call.Cost)
+	return nil
+}
+
 func (cw *ContextWindow) TotalTokens() int {
 	return cw.metrics.Total()
 }
@@ -516,11 +849,11 @@ func (cw *ContextWindow) TotalTokens() int {
 // in the context. Depending on your model, at some threshold of tokens
 // you'll want either to summarize, or to start a new context window.
 func (cw *ContextWindow) LiveTokens() (int, error) {
-	contextID, err := getContextIDByName(cw.db, cw.currentContext)
+	contextID, err := cw.contextIDByName(cw.currentContext)
 	if err != nil {
 		return 0, fmt.Errorf("live tokens in context: %w", err)
 	}
-	recs, err := ListLiveRecords(cw.db, contextID)
+	recs, err := cw.store.ListLiveRecords(contextID)
 	if err != nil {
 		return 0, fmt.Errorf("list live records: %w", err)
 	}
@@ -535,6 +868,8 @@ func (cw *ContextWindow) LiveTokens() (int, error) {
 type Metrics struct {
 	mu    sync.Mutex
 	total int
+	usage ModelUsage
+	cost  float64
 }
 
 func (m *Metrics) Add(n int) {
@@ -543,6 +878,31 @@ func (m *Metrics) Add(n int) {
 	m.mu.Unlock()
 }
 
+// AddUsage accumulates a usage breakdown and its cost; it doesn't change
+// Total.
+func (m *Metrics) AddUsage(u ModelUsage, cost float64) {
+	m.mu.Lock()
+	m.usage = m.usage.Add(u)
+	m.cost += cost
+	m.mu.Unlock()
+}
+
+// Usage returns the accumulated usage breakdown.
+func (m *Metrics) Usage() ModelUsage {
+	m.mu.Lock()
+	u := m.usage
+	m.mu.Unlock()
+	return u
+}
+
+// Cost returns the accumulated dollar cost.
+func (m *Metrics) Cost() float64 {
+	m.mu.Lock()
+	c := m.cost
+	m.mu.Unlock()
+	return c
+}
+
 func (m *Metrics) Total() int {
 	m.mu.Lock()
 	n := m.total
@@ -556,6 +916,11 @@ type TokenUsage struct {
 	Total   int     // cumulative tokens used across all calls
 	Max     int     // maximum tokens allowed in context window
 	Percent float64 // live/max as percentage (0.0-1.0)
+
+	// Usage breaks down the tokens used across all calls, as reported by
+	// models that provide a breakdown, and Cost prices them.
+	Usage ModelUsage
+	Cost  float64
 }
 
 // TokenUsage returns current token usage metrics optimized for UI display.
@@ -575,6 +940,8 @@ func (cw *ContextWindow) TokenUsage() (TokenUsage, error) {
 		Total:   cw.metrics.Total(),
 		Max:     cw.maxTokens,
 		Percent: percent,
+		Usage:   cw.metrics.Usage(),
+		Cost:    cw.metrics.Cost(),
 	}, nil
 }
 
@@ -584,6 +951,8 @@ type ContextStats struct {
 	TotalRecords int        // total number of records
 	LiveRecords  int        // number of live records
 	LastActivity *time.Time // timestamp of most recent record, nil if no records
+	Usage        ModelUsage // provider-reported usage of the context's model calls, from the ledger
+	Cost         float64    // dollar cost of the context's model calls, from the ledger
 }
 
 type TokenReporter interface {
@@ -592,27 +961,11 @@ type TokenReporter interface {
 	TokenUsage() (TokenUsage, error)
 }
 
-func tokenCount(s string) int {
-	tokOnce.Do(func() {
-		tok, tokErr = gotoken.GetTokenizer("cl100k_base")
-	})
-	if tokErr != nil {
-		return len(strings.Fields(s))
-	}
-	return tok.Count(s)
-}
-
-var (
-	tok     gotoken.Tokenizer
-	tokOnce sync.Once
-	tokErr  error
-)
-
 // Context management methods
 
 // CreateContext creates a new named context window.
 func (cw *ContextWindow) CreateContext(name string) error {
-	_, err := CreateContext(cw.db, name)
+	_, err := cw.store.CreateContext(name, false)
 	if err != nil {
 		return fmt.Errorf("create context: %w", err)
 	}
@@ -621,7 +974,7 @@ func (cw *ContextWindow) CreateContext(name string) error {
 
 // ListContexts returns all available context windows.
 func (cw *ContextWindow) ListContexts() ([]Context, error) {
-	contexts, err := ListContexts(cw.db)
+	contexts, err := cw.store.ListContexts()
 	if err != nil {
 		return nil, fmt.Errorf("list contexts: %w", err)
 	}
@@ -630,7 +983,7 @@ func (cw *ContextWindow) ListContexts() ([]Context, error) {
 
 // GetContext retrieves context metadata by name.
 func (cw *ContextWindow) GetContext(name string) (Context, error) {
-	ctx, err := GetContextByName(cw.db, name)
+	ctx, err := cw.store.GetContextByName(name)
 	if err != nil {
 		return Context{}, fmt.Errorf("get context: %w", err)
 	}
@@ -640,12 +993,12 @@ func (cw *ContextWindow) GetContext(name string) (Context, error) {
 // DeleteContext removes a context and all its records.
 func (cw *ContextWindow) DeleteContext(name string) error {
 	if name == cw.currentContext {
-		contexts, err := ListContexts(cw.db)
+		contexts, err := cw.store.ListContexts()
 		if err != nil {
 			return fmt.Errorf("list contexts for deletion: %w", err)
 		}
 		if len(contexts) <= 1 {
-			_, err := CreateContext(cw.db, "default")
+			_, err := cw.store.CreateContext("default", false)
 			if err != nil {
 				return fmt.Errorf("create replacement context: %w", err)
 			}
@@ -660,16 +1013,20 @@ func (cw *ContextWindow) DeleteContext(name string) error {
 		}
 	}
 
-	err := DeleteContextByName(cw.db, name)
+	ctx, err := cw.store.GetContextByName(name)
 	if err != nil {
 		return fmt.Errorf("delete context: %w", err)
 	}
+	if err := cw.store.DeleteContext(ctx.ID); err != nil {
+		return fmt.Errorf("delete context: %w", err)
+	}
+	delete(cw.rewinds, ctx.ID)
 	return nil
 }
 
 // ExportContext extracts a complete context with all its records.
 func (cw *ContextWindow) ExportContext(name string) (ContextExport, error) {
-	export, err := ExportContextByName(cw.db, name)
+	export, err := exportContext(cw.store, name)
 	if err != nil {
 		return ContextExport{}, fmt.Errorf("export context: %w", err)
 	}
@@ -678,11 +1035,47 @@ func (cw *ContextWindow) ExportContext(name string) (ContextExport, error) {
 
 // ExportContextJSON exports a context as JSON bytes.
 func (cw *ContextWindow) ExportContextJSON(name string) ([]byte, error) {
-	jsonData, err := ExportContextJSONByName(cw.db, name)
+	export, err := exportContext(cw.store, name)
 	if err != nil {
 		return nil, fmt.Errorf("export context json: %w", err)
 	}
-	return jsonData, nil
+	return json.MarshalIndent(export, "", "  ")
+}
+
+// ExportTranscript renders a context as a Markdown, HTML or chat JSONL
+// transcript. See [RenderTranscript].
+func (cw *ContextWindow) ExportTranscript(name string, opts TranscriptOpts) ([]byte, error) {
+	export, err := exportContext(cw.store, name)
+	if err != nil {
+		return nil, fmt.Errorf("export transcript: %w", err)
+	}
+	return RenderTranscript(export, opts)
+}
+
+// ImportContext recreates an exported context, under name if it isn't empty
+// (it's an error if the context exists). Records keep their timestamps,
+// liveness, response IDs and tool call details, and the context its
+// threading state, tokenizer, generation defaults and tools; the context
+// itself starts now, and isn't a fork of anything. It doesn't switch to the
+// imported context.
+func (cw *ContextWindow) ImportContext(export ContextExport, name string) error {
+	if _, err := importContext(cw.store, export, name); err != nil {
+		return fmt.Errorf("import context: %w", err)
+	}
+	return nil
+}
+
+// ImportContextJSON imports a context exported with
+// [ContextWindow.ExportContextJSON]. See [ContextWindow.ImportContext].
+func (cw *ContextWindow) ImportContextJSON(data []byte, name string) error {
+	var export ContextExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return fmt.Errorf("import context json: %w", err)
+	}
+	if _, err := importContext(cw.store, export, name); err != nil {
+		return fmt.Errorf("import context json: %w", err)
+	}
+	return nil
 }
 
 func (cw *ContextWindow) GetCurrentContext() string {
@@ -710,11 +1103,11 @@ func (cw *ContextWindow) SwitchContext(name string) error {
 		return fmt.Errorf("context name cannot be empty")
 	}
 
-	_, err := GetContextByName(cw.db, name)
+	c, err := cw.store.GetContextByName(name)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			// Context doesn't exist, create it with default settings
-			_, err = CreateContext(cw.db, name)
+			c, err = cw.store.CreateContext(name, false)
 			if err != nil {
 				return fmt.Errorf("create context: %w", err)
 			}
@@ -723,17 +1116,21 @@ func (cw *ContextWindow) SwitchContext(name string) error {
 		}
 	}
 
+	if err := cw.syncTokenizer(c.ID); err != nil {
+		return fmt.Errorf("switch context: %w", err)
+	}
+
 	cw.currentContext = name
 	return nil
 }
 
 // SetServerSideThreading enables or disables server-side threading for the current context.
 func (cw *ContextWindow) SetServerSideThreading(enabled bool) error {
-	contextID, err := getContextIDByName(cw.db, cw.currentContext)
+	contextID, err := cw.contextIDByName(cw.currentContext)
 	if err != nil {
 		return fmt.Errorf("get context ID: %w", err)
 	}
-	return SetContextServerSideThreading(cw.db, contextID, enabled)
+	return cw.store.SetServerSideThreading(contextID, enabled)
 }
 
 // IsServerSideThreadingEnabled returns whether server-side threading is enabled.
@@ -748,47 +1145,7 @@ func (cw *ContextWindow) IsServerSideThreadingEnabled() (bool, error) {
 // GetContextStats retrieves efficient statistics for any context.
 // All metrics are computed using database aggregations with existing indexes.
 func (cw *ContextWindow) GetContextStats(context Context) (ContextStats, error) {
-	stats := ContextStats{}
-
-	// Get record counts and live token sum in a single query
-	row := cw.db.QueryRow(`
-		SELECT 
-			COUNT(*) as total_records,
-			COUNT(CASE WHEN live = 1 THEN 1 END) as live_records,
-			COALESCE(SUM(CASE WHEN live = 1 THEN est_tokens ELSE 0 END), 0) as live_tokens,
-			MAX(ts) as last_activity
-		FROM records 
-		WHERE context_id = ?`,
-		context.ID,
-	)
-
-	var lastActivityStr sql.NullString
-	err := row.Scan(&stats.TotalRecords, &stats.LiveRecords, &stats.LiveTokens, &lastActivityStr)
-	if err != nil {
-		return ContextStats{}, fmt.Errorf("get context stats: %w", err)
-	}
-
-	if lastActivityStr.Valid && lastActivityStr.String != "" {
-		// Try multiple timestamp formats that SQLite might use
-		formats := []string{
-			"2006-01-02 15:04:05.999999999 -0700 MST", // SQLite format with timezone
-			"2006-01-02 15:04:05.999999999-07:00",
-			"2006-01-02 15:04:05.999999999",
-			"2006-01-02 15:04:05",
-			time.RFC3339,
-			time.RFC3339Nano,
-			"2006-01-02T15:04:05Z",
-			"2006-01-02T15:04:05.999999999Z",
-		}
-		for _, format := range formats {
-			if t, err := time.Parse(format, lastActivityStr.String); err == nil {
-				stats.LastActivity = &t
-				break
-			}
-		}
-	}
-
-	return stats, nil
+	return cw.store.ContextStats(context.ID)
 }
 
 // Reader returns a thread-safe read-only view of this ContextWindow.
@@ -855,6 +1212,16 @@ func (cr *ContextReader) ExportContextJSON(name string) ([]byte, error) {
 	return cr.cw.ExportContextJSON(name)
 }
 
+// Search runs a full-text search over records.
+func (cr *ContextReader) Search(q SearchQuery) ([]SearchResult, error) {
+	return cr.cw.Search(q)
+}
+
+// ExportTranscript renders a context as a transcript.
+func (cr *ContextReader) ExportTranscript(name string, opts TranscriptOpts) ([]byte, error) {
+	return cr.cw.ExportTranscript(name, opts)
+}
+
 // MaxTokens returns the maximum number of tokens allowed in the context window.
 func (cr *ContextReader) MaxTokens() int {
 	return cr.cw.MaxTokens()
@@ -862,5 +1229,54 @@ func (cr *ContextReader) MaxTokens() int {
 
 // Clone creates a copy of the current context with a new name.
 func (cw *ContextWindow) Clone(destName string) error {
-	return CloneContext(cw.db, cw.currentContext, destName)
+	return cw.store.CloneContext(cw.currentContext, destName)
+}
+
+// ForkContextAt creates a context named destName from the source context's
+// records up to and including recordID, in timestamp order, to branch a
+// conversation at that point. The fork records its parent and fork point (see
+// [ContextWindow.ContextAncestry]); records are copied with the liveness
+// they have now.
+func (cw *ContextWindow) ForkContextAt(source string, recordID int64, destName string) error {
+	if err := cw.store.ForkContext(source, recordID, destName); err != nil {
+		return fmt.Errorf("fork context: %w", err)
+	}
+	return nil
+}
+
+// ContextAncestry returns the contexts a context was forked from, its parent
+// first. It stops at a parent that has been deleted.
+func (cw *ContextWindow) ContextAncestry(name string) ([]Context, error) {
+	c, err := cw.store.GetContextByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("context ancestry: %w", err)
+	}
+
+	var ancestors []Context
+	seen := map[string]bool{c.ID: true}
+	for c.ParentID != "" && !seen[c.ParentID] {
+		seen[c.ParentID] = true
+		c, err = cw.store.GetContext(c.ParentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("context ancestry: %w", err)
+		}
+		ancestors = append(ancestors, c)
+	}
+	return ancestors, nil
+}
+
+// ContextChildren returns the contexts forked from a context, oldest first.
+func (cw *ContextWindow) ContextChildren(name string) ([]Context, error) {
+	c, err := cw.store.GetContextByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("context children: %w", err)
+	}
+	children, err := cw.store.ListChildContexts(c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("context children: %w", err)
+	}
+	return children, nil
 }
@@ -5,12 +5,16 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/openai/openai-go/v2"
 	"github.com/openai/openai-go/v2/packages/param"
@@ -27,7 +31,7 @@ type dummyModel struct {
 
 func (m *dummyModel) Call(ctx context.Context, inputs []Record) ([]Record, int, error) {
 	if m.closeDB && m.cw != nil {
-		m.cw.db.Close()
+		m.cw.store.Close()
 	}
 	return m.events, 0, nil
 }
@@ -66,7 +70,7 @@ func TestNewContextWindowAndClose(t *testing.T) {
 
 	cw, err := NewContextWindow(db, &dummyModel{}, "")
 	assert.NoError(t, err)
-	assert.NotNil(t, cw.db)
+	assert.NotNil(t, cw.store)
 
 	// Test record insertion before closing
 	err = cw.AddPrompt("test prompt")
@@ -89,7 +93,7 @@ func TestAddMethodsErrorPropagation(t *testing.T) {
 
 	cw, err := NewContextWindow(db, &dummyModel{}, "")
 	assert.NoError(t, err)
-	assert.NoError(t, cw.db.Close())
+	assert.NoError(t, cw.store.Close())
 	assert.Contains(t, cw.AddPrompt("p").Error(), "sql: database is closed")
 	assert.Contains(t, cw.AddToolCall("t", "a").Error(), "sql: database is closed")
 	assert.Contains(t, cw.AddToolOutput("o").Error(), "sql: database is closed")
@@ -840,6 +844,89 @@ func TestDatabaseSchemaWithResponseID(t *testing.T) {
 	assert.Equal(t, responseID, *recs[0].ResponseID)
 }
 
+func TestToolRecordsPersistCallIDs(t *testing.T) {
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	model := &MockModel{events: append(
+		toolCallRecords(CL100KTokenizer(), "call_1", "ls", `{"dir":"."}`, "no such dir", true),
+		Record{Source: ModelResp, Content: "done", Live: true},
+	)}
+	cw, err := NewContextWindow(db, model, "tool-ids")
+	assert.NoError(t, err)
+
+	_, err = cw.CallModel(context.Background())
+	assert.NoError(t, err)
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 3)
+
+	assert.Equal(t, ToolCall, recs[0].Source)
+	assert.Equal(t, `ls({"dir":"."})`, recs[0].Content)
+	assert.Equal(t, "call_1", recs[0].ToolCallID)
+	assert.Equal(t, "ls", recs[0].ToolName)
+	assert.JSONEq(t, `{"dir":"."}`, string(recs[0].ToolArgs))
+	assert.False(t, recs[0].ToolError)
+
+	assert.Equal(t, ToolOutput, recs[1].Source)
+	assert.Equal(t, "call_1", recs[1].ToolCallID)
+	assert.Equal(t, "ls", recs[1].ToolName)
+	assert.Nil(t, recs[1].ToolArgs)
+	assert.True(t, recs[1].ToolError)
+
+	assert.Empty(t, recs[2].ToolCallID)
+
+	err = cw.AddToolCall("legacy", "not json")
+	assert.NoError(t, err)
+	recs, err = cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy", recs[3].ToolName)
+	assert.Nil(t, recs[3].ToolArgs)
+
+	_, err = cw.ExportContextJSON("tool-ids")
+	assert.NoError(t, err)
+}
+
+func TestSchemaMigrationAddsToolColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := sql.Open("sqlite", path)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`
+CREATE TABLE contexts (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    start_time DATETIME NOT NULL
+);
+CREATE TABLE records (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    context_id TEXT NOT NULL,
+    ts         DATETIME NOT NULL,
+    source     INTEGER NOT NULL,
+    content    TEXT NOT NULL,
+    live       BOOLEAN NOT NULL,
+    est_tokens INTEGER NOT NULL
+);
+INSERT INTO contexts (id, name, start_time) VALUES ('c1', 'old', '2025-01-01 00:00:00');
+INSERT INTO records (context_id, ts, source, content, live, est_tokens)
+VALUES ('c1', '2025-01-01 00:00:01', 2, 'ls({})', 1, 3);
+`)
+	assert.NoError(t, err)
+
+	assert.NoError(t, InitializeSchema(db))
+
+	recs, err := ListLiveRecords(db, "c1")
+	assert.NoError(t, err)
+	assert.Len(t, recs, 1)
+	assert.Equal(t, "ls({})", recs[0].Content)
+	assert.Empty(t, recs[0].ToolCallID)
+	assert.Empty(t, recs[0].ToolName)
+	assert.False(t, recs[0].ToolError)
+}
+
 func TestSetContextServerSideThreading(t *testing.T) {
 	db, err := NewContextDB(":memory:")
 	assert.NoError(t, err)
@@ -2089,4 +2176,245 @@ func TestContextWindow_SetRecordLiveStateByRange_Revive(t *testing.T) {
 	liveRecordsAfterDead, err := cw.LiveRecords()
 	assert.NoError(t, err)
 	assert.Len(t, liveRecordsAfterDead, 0)
-}
\ No newline at end of file
+}
+func TestToolsScopedPerContext(t *testing.T) {
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err := NewContextWindow(db, &mockModel{}, "planner")
+	assert.NoError(t, err)
+
+	runner := func(result string) ToolRunner {
+		return ToolRunnerFunc(func(ctx context.Context, args json.RawMessage) (string, error) {
+			return result, nil
+		})
+	}
+	assert.NoError(t, cw.RegisterTool("plan", "plan definition", runner("planned")))
+
+	assert.NoError(t, cw.SwitchContext("executor"))
+	assert.Empty(t, cw.GetRegisteredTools())
+	_, err = cw.ExecuteTool(context.Background(), "plan", nil)
+	assert.Error(t, err)
+
+	assert.NoError(t, cw.RegisterTool("exec", "exec definition", runner("executed")))
+	assert.Error(t, cw.EnableTool("missing"))
+
+	tools := cw.GetRegisteredTools()
+	assert.Len(t, tools, 1)
+	assert.Equal(t, "exec", tools[0].Name)
+
+	assert.NoError(t, cw.SwitchContext("planner"))
+	tools = cw.GetRegisteredTools()
+	assert.Len(t, tools, 1)
+	assert.Equal(t, "plan", tools[0].Name)
+
+	assert.NoError(t, cw.EnableTool("exec"))
+	assert.NoError(t, cw.EnableTool("exec"))
+	assert.Len(t, cw.GetRegisteredTools(), 2)
+	out, err := cw.ExecuteTool(context.Background(), "exec", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "executed", out)
+
+	assert.NoError(t, cw.DisableTool("plan"))
+	tools = cw.GetRegisteredTools()
+	assert.Len(t, tools, 1)
+	assert.Equal(t, "exec", tools[0].Name)
+}
+
+func TestRunToolCallsConcurrently(t *testing.T) {
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err := NewContextWindow(db, &mockModel{}, "parallel")
+	assert.NoError(t, err)
+	cw.SetToolConcurrency(2)
+
+	var (
+		mu       sync.Mutex
+		inFlight int
+		peak     int
+	)
+	err = cw.RegisterTool("slow", "slow definition", ToolRunnerFunc(func(ctx context.Context, args json.RawMessage) (string, error) {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return string(args), nil
+	}))
+	assert.NoError(t, err)
+
+	middleware := &testMiddleware{}
+	calls := []pendingToolCall{
+		{ID: "a", Name: "slow", Args: `"a"`},
+		{ID: "b", Name: "slow", Args: `"b"`},
+		{ID: "c", Name: "missing", Args: `{}`},
+		{ID: "d", Name: "slow", Args: `"d"`},
+	}
+	results := runToolCalls(context.Background(), cw, []Middleware{middleware}, nil, calls, 0)
+
+	assert.Len(t, results, 4)
+	assert.Equal(t, `"a"`, results[0].Output)
+	assert.Equal(t, `"b"`, results[1].Output)
+	assert.Error(t, results[2].Err)
+	assert.Contains(t, results[2].Output, "error:")
+	assert.Equal(t, `"d"`, results[3].Output)
+	assert.Equal(t, 2, peak)
+
+	middleware.mu.Lock()
+	assert.Len(t, middleware.toolCalls, 4)
+	assert.Len(t, middleware.toolResults, 4)
+	middleware.mu.Unlock()
+}
+
+func TestToolApprover(t *testing.T) {
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err := NewContextWindow(db, &mockModel{}, "approval")
+	assert.NoError(t, err)
+
+	var ran []string
+	err = cw.RegisterTool("shell", "shell definition", ToolRunnerFunc(func(ctx context.Context, args json.RawMessage) (string, error) {
+		ran = append(ran, string(args))
+		return "ran " + string(args), nil
+	}))
+	assert.NoError(t, err)
+
+	cw.SetToolApprover(ToolApproverFunc(func(ctx context.Context, name string, args json.RawMessage) (ToolApproval, error) {
+		switch string(args) {
+		case `"rm -rf /"`:
+			return ToolApproval{Deny: true, Reason: "not on my watch"}, nil
+		case `"ls"`:
+			return ToolApproval{Args: json.RawMessage(`"ls -l"`)}, nil
+		}
+		return ToolApproval{}, nil
+	}))
+	cw.SetToolConcurrency(1)
+
+	middleware := &testMiddleware{}
+	calls := []pendingToolCall{
+		{ID: "a", Name: "shell", Args: `"rm -rf /"`},
+		{ID: "b", Name: "shell", Args: `"ls"`},
+		{ID: "c", Name: "shell", Args: `"pwd"`},
+	}
+	results := runToolCalls(context.Background(), cw, []Middleware{middleware}, nil, calls, 0)
+
+	assert.Equal(t, []string{`"ls -l"`, `"pwd"`}, ran)
+
+	assert.Equal(t, "not on my watch", results[0].Output)
+	var denied *ToolDeniedError
+	assert.ErrorAs(t, results[0].Err, &denied)
+
+	assert.Equal(t, `"ls -l"`, results[1].Args)
+	assert.Equal(t, `ran "ls -l"`, results[1].Output)
+	assert.NoError(t, results[1].Err)
+
+	assert.Equal(t, `ran "pwd"`, results[2].Output)
+
+	middleware.mu.Lock()
+	assert.Equal(t, `shell("ls -l")`, middleware.toolCalls[1])
+	assert.Equal(t, "shell:error:tool 'shell' denied: not on my watch", middleware.toolResults[0])
+	middleware.mu.Unlock()
+}
+
+func TestToolApproverRewritesReplayedCalls(t *testing.T) {
+	requests := make(map[string][]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body any
+		json.NewDecoder(r.Body).Decode(&body)
+		js, _ := json.Marshal(body)
+		requests[r.URL.Path] = append(requests[r.URL.Path], string(js))
+		first := len(requests[r.URL.Path]) == 1
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/v1/messages" && first:
+			io.WriteString(w, `{"id": "msg_1", "type": "message", "role": "assistant", "model": "claude-sonnet-4-5",
+				"content": [{"type": "tool_use", "id": "toolu_1", "name": "shell", "input": {"cmd": "ls"}}],
+				"stop_reason": "tool_use", "usage": {"input_tokens": 1, "output_tokens": 1}}`)
+		case r.URL.Path == "/v1/messages":
+			io.WriteString(w, `{"id": "msg_2", "type": "message", "role": "assistant", "model": "claude-sonnet-4-5",
+				"content": [{"type": "text", "text": "done"}], "stop_reason": "end_turn",
+				"usage": {"input_tokens": 1, "output_tokens": 1}}`)
+		case r.URL.Path == "/chat/completions" && first:
+			io.WriteString(w, `{"id": "chatcmpl-1", "object": "chat.completion", "created": 1, "model": "gpt-4o",
+				"choices": [{"index": 0, "finish_reason": "tool_calls", "message": {"role": "assistant", "content": null,
+					"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "shell", "arguments": "{\"cmd\":\"ls\"}"}}]}}],
+				"usage": {"prompt_tokens": 1, "completion_tokens": 1, "total_tokens": 2}}`)
+		case r.URL.Path == "/chat/completions":
+			io.WriteString(w, `{"id": "chatcmpl-2", "object": "chat.completion", "created": 1, "model": "gpt-4o",
+				"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "done"}}],
+				"usage": {"prompt_tokens": 1, "completion_tokens": 1, "total_tokens": 2}}`)
+		case r.URL.Path == "/responses" && first:
+			io.WriteString(w, `{"id": "resp_1", "object": "response", "created_at": 1, "status": "completed", "model": "gpt-4o",
+				"output": [{"type": "function_call", "id": "fc_1", "call_id": "call_1", "name": "shell",
+					"arguments": "{\"cmd\":\"ls\"}", "status": "completed"}],
+				"usage": {"input_tokens": 1, "output_tokens": 1, "total_tokens": 2}}`)
+		case r.URL.Path == "/responses":
+			io.WriteString(w, `{"id": "resp_2", "object": "response", "created_at": 1, "status": "completed", "model": "gpt-4o",
+				"output": [{"type": "message", "id": "msg_1", "role": "assistant", "status": "completed",
+					"content": [{"type": "output_text", "text": "done", "annotations": []}]}],
+				"usage": {"input_tokens": 1, "output_tokens": 1, "total_tokens": 2}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	opts := []ClientOption{WithBaseURL(srv.URL), WithAPIKey("test")}
+	claude, err := NewClaudeModel(ModelClaudeSonnet45, opts...)
+	assert.NoError(t, err)
+	chat, err := NewOpenAIModel(shared.ChatModelGPT4o, opts...)
+	assert.NoError(t, err)
+	resp, err := NewOpenAIResponsesModel(ResponsesModel4o, opts...)
+	assert.NoError(t, err)
+
+	for path, m := range map[string]Model{"/v1/messages": claude, "/chat/completions": chat, "/responses": resp} {
+		t.Run(path, func(t *testing.T) {
+			db, err := NewContextDB(":memory:")
+			assert.NoError(t, err)
+			defer db.Close()
+			cw, err := NewContextWindow(db, m, "approval")
+			assert.NoError(t, err)
+			if path == "/responses" {
+				assert.NoError(t, cw.SetServerSideThreading(true))
+			}
+
+			var ran string
+			assert.NoError(t, cw.RegisterTool("shell", NewTool("shell", "runs a command"), ToolRunnerFunc(
+				func(ctx context.Context, args json.RawMessage) (string, error) {
+					ran = string(args)
+					return "go.mod", nil
+				})))
+			cw.SetToolApprover(ToolApproverFunc(func(ctx context.Context, name string, args json.RawMessage) (ToolApproval, error) {
+				return ToolApproval{Args: json.RawMessage(`{"cmd":"ls -l"}`)}, nil
+			}))
+			assert.NoError(t, cw.AddPrompt("list files"))
+
+			_, err = cw.CallModel(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, `{"cmd":"ls -l"}`, ran)
+
+			// The model is shown the call that ran, as it was stored
+			if assert.Len(t, requests[path], 2) {
+				replay := requests[path][1]
+				assert.NotContains(t, replay, `"ls\"`)
+				assert.NotContains(t, replay, `"ls"`)
+				assert.NotContains(t, replay, "previous_response_id")
+				assert.Contains(t, replay, "ls -l")
+			}
+			recs, err := cw.LiveRecords()
+			assert.NoError(t, err)
+			assert.JSONEq(t, `{"cmd":"ls -l"}`, string(recs[1].ToolArgs))
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package contextwindow
+
+import (
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go/v2/shared"
+)
+
+// ModelPrice is what a model costs, in US dollars per million tokens.
+type ModelPrice struct {
+	Input      float64
+	Output     float64
+	CacheRead  float64
+	CacheWrite float64
+}
+
+// Cost returns the dollar cost of usage at this price. Input tokens read
+// from or written to the prompt cache are charged at the cache rates, and
+// the rest at the input rate; reasoning tokens are charged as output.
+func (p ModelPrice) Cost(u ModelUsage) float64 {
+	uncached := u.InputTokens - u.CacheReadTokens - u.CacheWriteTokens
+	return (float64(uncached)*p.Input +
+		float64(u.CacheReadTokens)*p.CacheRead +
+		float64(u.CacheWriteTokens)*p.CacheWrite +
+		float64(u.OutputTokens)*p.Output) / 1e6
+}
+
+// PriceTable maps model names to prices. A model without an exact entry
+// uses the longest entry that prefixes its name, so "claude-sonnet-4-5"
+// also prices "claude-sonnet-4-5-20250929".
+type PriceTable map[string]ModelPrice
+
+// Lookup finds the price for a model.
+func (t PriceTable) Lookup(model string) (ModelPrice, bool) {
+	if p, ok := t[model]; ok {
+		return p, true
+	}
+
+	var (
+		best  string
+		price ModelPrice
+	)
+	for name, p := range t {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best, price = name, p
+		}
+	}
+	return price, best != ""
+}
+
+// Cost returns the cost of usage on model, or zero if the model isn't in
+// the table.
+func (t PriceTable) Cost(model string, u ModelUsage) float64 {
+	p, ok := t.Lookup(model)
+	if !ok {
+		return 0
+	}
+	return p.Cost(u)
+}
+
+// DefaultPriceTable returns list prices for the models this package names.
+// Prices change; override them with [ContextWindow.SetPriceTable].
+func DefaultPriceTable() PriceTable {
+	return PriceTable{
+		ModelClaudeHaiku45:  {Input: 1, Output: 5, CacheRead: 0.10, CacheWrite: 1.25},
+		ModelClaudeSonnet45: {Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75},
+		ModelClaudeSonnet40: {Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75},
+		ModelClaudeOpus41:   {Input: 15, Output: 75, CacheRead: 1.50, CacheWrite: 18.75},
+
+		string(ResponsesModelGPT5):        {Input: 1.25, Output: 10, CacheRead: 0.125, CacheWrite: 1.25},
+		string(ResponsesModelGPT5Mini):    {Input: 0.25, Output: 2, CacheRead: 0.025, CacheWrite: 0.25},
+		string(ResponsesModel4o):          {Input: 2.50, Output: 10, CacheRead: 1.25, CacheWrite: 2.50},
+		string(ResponsesModelO4Mini):      {Input: 1.10, Output: 4.40, CacheRead: 0.275, CacheWrite: 1.10},
+		string(shared.ChatModelGPT4o):     {Input: 2.50, Output: 10, CacheRead: 1.25, CacheWrite: 2.50},
+		string(shared.ChatModelGPT4oMini): {Input: 0.15, Output: 0.60, CacheRead: 0.075, CacheWrite: 0.15},
+	}
+}
+
+// SetPriceTable replaces the prices used to cost model calls from now on;
+// calls already made keep the cost they were recorded with.
+func (cw *ContextWindow) SetPriceTable(prices PriceTable) {
+	cw.prices = prices
+}
+
+// TotalCost returns the dollar cost of all model calls made through this
+// ContextWindow, across contexts: the sum of the ledger entries it wrote.
+func (cw *ContextWindow) TotalCost() float64 {
+	return cw.metrics.Cost()
+}
+
+// Spend summarizes the model calls made in a time range, from the model
+// call ledger (see [ModelCall]).
+type Spend struct {
+	// Calls is the number of ledger entries; Requests is the number of
+	// provider requests they made, tool rounds included.
+	Calls    int
+	Requests int
+	Cost     float64
+	Usage    ModelUsage
+}
+
+// Spend returns what was spent on model calls in all contexts between from
+// (inclusive) and to (exclusive). A zero to means "until now". Copying a
+// context doesn't copy its spend.
+func (cw *ContextWindow) Spend(from, to time.Time) (Spend, error) {
+	return cw.store.Spend("", from, to)
+}
+
+// ContextSpend is like Spend, for a single context.
+func (cw *ContextWindow) ContextSpend(name string, from, to time.Time) (Spend, error) {
+	contextID, err := cw.contextIDByName(name)
+	if err != nil {
+		return Spend{}, err
+	}
+	return cw.store.Spend(contextID, from, to)
+}
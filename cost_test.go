@@ -0,0 +1,171 @@
+package contextwindow
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriceTableLookup(t *testing.T) {
+	prices := PriceTable{
+		"gpt-4o":      {Input: 2.5, Output: 10},
+		"gpt-4o-mini": {Input: 0.15, Output: 0.6},
+	}
+
+	p, ok := prices.Lookup("gpt-4o-mini-2024-07-18")
+	assert.True(t, ok)
+	assert.Equal(t, 0.15, p.Input)
+
+	p, ok = prices.Lookup("gpt-4o-2024-08-06")
+	assert.True(t, ok)
+	assert.Equal(t, 2.5, p.Input)
+
+	_, ok = prices.Lookup("o3")
+	assert.False(t, ok)
+	assert.Zero(t, prices.Cost("o3", ModelUsage{InputTokens: 1000}))
+}
+
+func TestModelPriceCost(t *testing.T) {
+	p := ModelPrice{Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75}
+	u := ModelUsage{
+		InputTokens:      1_000_000,
+		OutputTokens:     100_000,
+		CacheReadTokens:  500_000,
+		CacheWriteTokens: 100_000,
+	}
+	// 400k uncached at $3, 500k cached at $0.30, 100k written at $3.75,
+	// 100k out at $15.
+	assert.InDelta(t, 1.2+0.15+0.375+1.5, p.Cost(u), 1e-9)
+}
+
+func TestCostTracking(t *testing.T) {
+	m := fakeOpenAIModel(t, func(n int) string {
+		if n == 1 {
+			return chatToolCallReply(n)
+		}
+		return `{
+			"id": "chatcmpl-done", "object": "chat.completion", "created": 1, "model": "gpt-4o",
+			"choices": [{"index": 0, "finish_reason": "stop",
+				"message": {"role": "assistant", "content": "done"}}],
+			"usage": {"prompt_tokens": 15, "completion_tokens": 5, "total_tokens": 20}
+		}`
+	})
+
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err := NewContextWindow(db, m, "costs")
+	assert.NoError(t, err)
+	cw.SetPriceTable(PriceTable{"gpt-4o": {Input: 1_000, Output: 10_000}})
+
+	err = cw.RegisterTool("echo", NewTool("echo", "echoes"), ToolRunnerFunc(
+		func(ctx context.Context, args json.RawMessage) (string, error) {
+			return "echoed", nil
+		}))
+	assert.NoError(t, err)
+
+	start := time.Now()
+	assert.NoError(t, cw.AddPrompt("hi"))
+	_, err = cw.CallModel(context.Background())
+	assert.NoError(t, err)
+
+	// 20 input tokens at $1000/MTok plus 10 output at $10000/MTok.
+	const want = 0.02 + 0.1
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	last := recs[len(recs)-1]
+	assert.Equal(t, "gpt-4o", last.Model)
+	assert.InDelta(t, want, last.Cost, 1e-9)
+
+	assert.InDelta(t, want, cw.TotalCost(), 1e-9)
+
+	info, err := cw.GetCurrentContextInfo()
+	assert.NoError(t, err)
+	stats, err := cw.GetContextStats(info)
+	assert.NoError(t, err)
+	assert.InDelta(t, want, stats.Cost, 1e-9)
+
+	spend, err := cw.Spend(start, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, spend.Calls)
+	assert.Equal(t, 2, spend.Requests)
+	assert.InDelta(t, want, spend.Cost, 1e-9)
+	assert.Equal(t, 20, spend.Usage.InputTokens)
+
+	// Copies of a context don't copy its spend
+	assert.NoError(t, cw.Clone("costs-copy"))
+	spend, err = cw.Spend(start, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, spend.Calls)
+	assert.InDelta(t, want, spend.Cost, 1e-9)
+	copied, err := cw.GetContext("costs-copy")
+	assert.NoError(t, err)
+	stats, err = cw.GetContextStats(copied)
+	assert.NoError(t, err)
+	assert.Zero(t, stats.Cost)
+
+	spend, err = cw.ContextSpend("costs", start.Add(-time.Hour), start)
+	assert.NoError(t, err)
+	assert.Zero(t, spend.Calls)
+
+	assert.NoError(t, cw.CreateContext("other"))
+	spend, err = cw.ContextSpend("other", start, time.Time{})
+	assert.NoError(t, err)
+	assert.Zero(t, spend.Calls)
+}
+
+func TestSpendCountsFailedAndSummarizerCalls(t *testing.T) {
+	m := fakeOpenAIModel(t, func(n int) string {
+		if n == 1 {
+			return chatToolCallReply(n)
+		}
+		return `not json`
+	})
+	summarizer := fakeOpenAIModel(t, func(n int) string {
+		return `{
+			"id": "chatcmpl-sum", "object": "chat.completion", "created": 1, "model": "gpt-4o",
+			"choices": [{"index": 0, "finish_reason": "stop",
+				"message": {"role": "assistant", "content": "a summary"}}],
+			"usage": {"prompt_tokens": 40, "completion_tokens": 2, "total_tokens": 42}
+		}`
+	})
+
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err := NewContextWindow(db, m, "costs")
+	assert.NoError(t, err)
+	cw.SetSummarizer(summarizer)
+	cw.SetPriceTable(PriceTable{"gpt-4o": {Input: 1_000, Output: 10_000}})
+	err = cw.RegisterTool("echo", NewTool("echo", "echoes"), ToolRunnerFunc(
+		func(ctx context.Context, args json.RawMessage) (string, error) {
+			return "echoed", nil
+		}))
+	assert.NoError(t, err)
+
+	start := time.Now()
+	assert.NoError(t, cw.AddPrompt("hi"))
+	_, err = cw.CallModel(context.Background())
+	assert.Error(t, err)
+
+	// The request that succeeded before the failure is paid for
+	spend, err := cw.Spend(start, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, spend.Calls)
+	assert.Equal(t, 5, spend.Usage.InputTokens)
+	assert.InDelta(t, 0.005+0.05, spend.Cost, 1e-9)
+
+	_, err = cw.SummarizeLiveContext(context.Background())
+	assert.NoError(t, err)
+	spend, err = cw.ContextSpend("costs", start, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, spend.Calls)
+	assert.Equal(t, 45, spend.Usage.InputTokens)
+	assert.InDelta(t, spend.Cost, cw.TotalCost(), 1e-9)
+}
@@ -0,0 +1,199 @@
+package contextwindow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForkContextAt(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		responseID := "resp_1"
+		m := &dummyModel{events: []Record{{Source: ModelResp, Content: "first answer", Live: true, ResponseID: &responseID}}}
+		cw, err := NewContextWindowWithStore(s, m, "main")
+		assert.NoError(t, err)
+
+		assert.NoError(t, cw.AddPrompt("first"))
+		_, err = cw.CallModel(context.Background())
+		assert.NoError(t, err)
+		assert.NoError(t, cw.AddPromptWithAttachments("second", NewAttachment("a.txt", "text/plain", []byte("notes"))))
+		m.events = []Record{{Source: ModelResp, Content: "second answer", Live: true}}
+		_, err = cw.CallModel(context.Background())
+		assert.NoError(t, err)
+
+		recs, err := cw.LiveRecords()
+		assert.NoError(t, err)
+		assert.Len(t, recs, 4)
+
+		// Fork after the second prompt, to answer it differently
+		assert.NoError(t, cw.ForkContextAt("main", recs[2].ID, "what-if"))
+		fork, err := cw.GetContext("what-if")
+		assert.NoError(t, err)
+		main, err := cw.GetContext("main")
+		assert.NoError(t, err)
+		assert.Equal(t, main.ID, fork.ParentID)
+		assert.Equal(t, recs[2].ID, fork.ForkRecordID)
+		if assert.NotNil(t, fork.LastResponseID) {
+			assert.Equal(t, "resp_1", *fork.LastResponseID)
+		}
+
+		assert.NoError(t, cw.SwitchContext("what-if"))
+		forked, err := cw.LiveRecords()
+		assert.NoError(t, err)
+		if assert.Len(t, forked, 3) {
+			assert.Equal(t, "second", forked[2].Content)
+			assert.Equal(t, []byte("notes"), forked[2].Attachments[0].Data)
+		}
+
+		// Forks of forks have ancestry
+		assert.NoError(t, cw.ForkContextAt("what-if", forked[0].ID, "what-if-2"))
+		ancestry, err := cw.ContextAncestry("what-if-2")
+		assert.NoError(t, err)
+		if assert.Len(t, ancestry, 2) {
+			assert.Equal(t, "what-if", ancestry[0].Name)
+			assert.Equal(t, "main", ancestry[1].Name)
+		}
+		ancestry, err = cw.ContextAncestry("main")
+		assert.NoError(t, err)
+		assert.Empty(t, ancestry)
+
+		children, err := cw.ContextChildren("main")
+		assert.NoError(t, err)
+		if assert.Len(t, children, 1) {
+			assert.Equal(t, "what-if", children[0].Name)
+		}
+
+		assert.Error(t, cw.ForkContextAt("main", recs[1].ID, "what-if"))
+		assert.Error(t, cw.ForkContextAt("main", forked[0].ID, "elsewhere"))
+		assert.Error(t, cw.ForkContextAt("main", 9999, "missing"))
+
+		// A deleted parent ends the ancestry
+		assert.NoError(t, cw.DeleteContext("main"))
+		ancestry, err = cw.ContextAncestry("what-if-2")
+		assert.NoError(t, err)
+		assert.Len(t, ancestry, 1)
+	})
+}
+
+func TestForkAfterRewindThreadsFromLiveResponse(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		m := &dummyModel{}
+		cw, err := NewContextWindowWithStore(s, m, "main")
+		assert.NoError(t, err)
+		turn := func(prompt, responseID string) {
+			assert.NoError(t, cw.AddPrompt(prompt))
+			m.events = []Record{{Source: ModelResp, Content: prompt + " answer", Live: true, ResponseID: &responseID}}
+			_, err := cw.CallModel(context.Background())
+			assert.NoError(t, err)
+		}
+
+		turn("a", "resp_a")
+		assert.NoError(t, cw.Rewind(1))
+		turn("b", "resp_b")
+
+		live, err := cw.LiveRecords()
+		assert.NoError(t, err)
+		assert.NoError(t, cw.ForkContextAt("main", live[0].ID, "at-b"))
+		fork, err := cw.GetContext("at-b")
+		assert.NoError(t, err)
+		assert.Nil(t, fork.LastResponseID)
+
+		assert.NoError(t, cw.ForkContextAt("main", live[1].ID, "after-b"))
+		fork, err = cw.GetContext("after-b")
+		assert.NoError(t, err)
+		if assert.NotNil(t, fork.LastResponseID) {
+			assert.Equal(t, "resp_b", *fork.LastResponseID)
+		}
+	})
+}
+
+func TestCopiedContextsKeepTools(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		cw, err := NewContextWindowWithStore(s, &dummyModel{}, "main")
+		assert.NoError(t, err)
+		echo := ToolRunnerFunc(func(ctx context.Context, args json.RawMessage) (string, error) {
+			return string(args), nil
+		})
+		assert.NoError(t, cw.RegisterTool("echo", "echo definition", echo))
+		assert.NoError(t, cw.AddPrompt("hello"))
+		recs, err := cw.LiveRecords()
+		assert.NoError(t, err)
+
+		assert.NoError(t, cw.Clone("copy"))
+		assert.NoError(t, cw.ForkContextAt("main", recs[0].ID, "fork"))
+		for _, name := range []string{"copy", "fork"} {
+			assert.NoError(t, cw.SwitchContext(name))
+			has, err := cw.HasTool("echo")
+			assert.NoError(t, err)
+			assert.True(t, has, name)
+			tools, err := cw.ListTools()
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"echo"}, tools, name)
+		}
+
+		// The copies' tools are their own
+		assert.NoError(t, cw.DisableTool("echo"))
+		assert.NoError(t, cw.SwitchContext("main"))
+		has, err := cw.HasTool("echo")
+		assert.NoError(t, err)
+		assert.True(t, has)
+	})
+}
+
+func TestForkAfterSummary(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		cw, err := NewContextWindowWithStore(s, &dummyModel{}, "main")
+		assert.NoError(t, err)
+		cw.SetSummarizer(&mockSummarizer{summaryText: "turns one and two"})
+
+		for _, prompt := range []string{"one", "two", "three", "four"} {
+			assert.NoError(t, cw.AddPrompt(prompt))
+		}
+		result, err := cw.SummarizeLiveContextWithOpts(context.Background(), SummarizeOpts{KeepTurns: 2})
+		assert.NoError(t, err)
+		assert.NoError(t, cw.AcceptSummary(result))
+
+		recs, err := cw.LiveRecords()
+		assert.NoError(t, err)
+		if !assert.Len(t, recs, 3) {
+			return
+		}
+		assert.NoError(t, cw.ForkContextAt("main", recs[1].ID, "fork"))
+
+		assert.NoError(t, cw.SwitchContext("fork"))
+		forked, err := cw.LiveRecords()
+		assert.NoError(t, err)
+		var contents []string
+		for _, r := range forked {
+			contents = append(contents, r.Content)
+		}
+		assert.Equal(t, []string{"turns one and two", "three"}, contents)
+	})
+}
+
+func TestCopyContextIsAtomic(t *testing.T) {
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err := NewContextWindow(db, &dummyModel{}, "main")
+	assert.NoError(t, err)
+	assert.NoError(t, cw.AddPrompt("hello"))
+	assert.NoError(t, cw.RegisterTool("echo", "echo definition", nil))
+
+	// Fail the last step of the copy
+	_, err = db.Exec(`CREATE TRIGGER fail_tool_copy BEFORE INSERT ON context_tools
+		BEGIN SELECT RAISE(ABORT, 'no more tools'); END`)
+	assert.NoError(t, err)
+
+	assert.Error(t, cw.Clone("copy"))
+	_, err = cw.GetContext("copy")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	var n int
+	assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM records`).Scan(&n))
+	assert.Equal(t, 1, n)
+}
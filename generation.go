@@ -0,0 +1,178 @@
+package contextwindow
+
+import (
+	"fmt"
+)
+
+// ToolChoice controls whether the model calls tools: [ToolChoiceAuto],
+// [ToolChoiceNone], [ToolChoiceRequired], or the name of a tool it must
+// call. Empty leaves it to the provider, which is auto.
+//
+// Required and named choices apply to the first request of a call; once the
+// model has called a tool, it's free to answer.
+type ToolChoice string
+
+const (
+	ToolChoiceAuto     ToolChoice = "auto"
+	ToolChoiceNone     ToolChoice = "none"
+	ToolChoiceRequired ToolChoice = "required"
+)
+
+// toolName returns the tool a ToolChoice names, if it names one.
+func (t ToolChoice) toolName() (string, bool) {
+	switch t {
+	case "", ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired:
+		return "", false
+	}
+	return string(t), true
+}
+
+// forced reports whether the choice makes the model call a tool.
+func (t ToolChoice) forced() bool {
+	_, named := t.toolName()
+	return named || t == ToolChoiceRequired
+}
+
+// ReasoningEffort is how hard a reasoning model thinks before answering.
+type ReasoningEffort string
+
+const (
+	ReasoningLow    ReasoningEffort = "low"
+	ReasoningMedium ReasoningEffort = "medium"
+	ReasoningHigh   ReasoningEffort = "high"
+)
+
+// GenerationParams are provider-neutral sampling and generation settings.
+// Zero values leave the provider's default, except MaxTokens: Claude
+// requires one, so it defaults to 4096 plus any thinking budget. Claude
+// doesn't allow extended thinking with Temperature, TopP or a forced tool
+// choice, and calls that combine them fail.
+//
+// ReasoningEffort and ThinkingBudget are two ways of saying the same thing:
+// OpenAI models take an effort and Claude a token budget for extended
+// thinking, and each is derived from the other if only one is set.
+type GenerationParams struct {
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	// Stop sequences end generation. The Responses API doesn't support
+	// them; calls to it that set them fail.
+	Stop       []string   `json:"stop,omitempty"`
+	ToolChoice ToolChoice `json:"tool_choice,omitempty"`
+
+	ReasoningEffort ReasoningEffort `json:"reasoning_effort,omitempty"`
+	ThinkingBudget  int             `json:"thinking_budget,omitempty"`
+}
+
+// Float returns a pointer to f, for Temperature and TopP.
+func Float(f float64) *float64 {
+	return &f
+}
+
+// withDefaults fills the settings p leaves unset from defaults.
+func (p GenerationParams) withDefaults(defaults GenerationParams) GenerationParams {
+	if p.MaxTokens == 0 {
+		p.MaxTokens = defaults.MaxTokens
+	}
+	if p.Temperature == nil {
+		p.Temperature = defaults.Temperature
+	}
+	if p.TopP == nil {
+		p.TopP = defaults.TopP
+	}
+	if p.Stop == nil {
+		p.Stop = defaults.Stop
+	}
+	if p.ToolChoice == "" {
+		p.ToolChoice = defaults.ToolChoice
+	}
+	if p.ReasoningEffort == "" && p.ThinkingBudget == 0 {
+		p.ReasoningEffort = defaults.ReasoningEffort
+		p.ThinkingBudget = defaults.ThinkingBudget
+	}
+	return p
+}
+
+// Thinking budgets that correspond to each reasoning effort.
+const (
+	thinkingBudgetLow    = 2048
+	thinkingBudgetMedium = 8192
+	thinkingBudgetHigh   = 24576
+)
+
+// effort returns the reasoning effort, derived from the thinking budget if
+// it isn't set.
+func (p GenerationParams) effort() ReasoningEffort {
+	switch {
+	case p.ReasoningEffort != "":
+		return p.ReasoningEffort
+	case p.ThinkingBudget == 0:
+		return ""
+	case p.ThinkingBudget <= thinkingBudgetLow:
+		return ReasoningLow
+	case p.ThinkingBudget <= thinkingBudgetMedium:
+		return ReasoningMedium
+	default:
+		return ReasoningHigh
+	}
+}
+
+// thinkingBudget returns the thinking budget, derived from the reasoning
+// effort if it isn't set.
+func (p GenerationParams) thinkingBudget() int {
+	if p.ThinkingBudget > 0 {
+		return p.ThinkingBudget
+	}
+	switch p.ReasoningEffort {
+	case ReasoningLow:
+		return thinkingBudgetLow
+	case ReasoningMedium:
+		return thinkingBudgetMedium
+	case ReasoningHigh:
+		return thinkingBudgetHigh
+	}
+	return 0
+}
+
+// validate checks settings every provider rejects.
+func (p GenerationParams) validate() error {
+	switch p.ReasoningEffort {
+	case "", ReasoningLow, ReasoningMedium, ReasoningHigh:
+	default:
+		return fmt.Errorf("unknown reasoning effort %q", p.ReasoningEffort)
+	}
+	if p.MaxTokens < 0 || p.ThinkingBudget < 0 {
+		return fmt.Errorf("negative token limit")
+	}
+	return nil
+}
+
+// SetGenerationDefaults sets the generation settings of the current
+// context's model calls. They're persisted with the context; settings in
+// [CallModelOpts] override them call by call.
+func (cw *ContextWindow) SetGenerationDefaults(p GenerationParams) error {
+	if err := p.validate(); err != nil {
+		return fmt.Errorf("set generation defaults: %w", err)
+	}
+	contextID, err := cw.contextIDByName(cw.currentContext)
+	if err != nil {
+		return fmt.Errorf("set generation defaults: %w", err)
+	}
+	if err := cw.store.SetContextGeneration(contextID, p); err != nil {
+		return fmt.Errorf("set generation defaults: %w", err)
+	}
+	return nil
+}
+
+// GenerationDefaults returns the generation settings of the current context.
+func (cw *ContextWindow) GenerationDefaults() (GenerationParams, error) {
+	contextID, err := cw.contextIDByName(cw.currentContext)
+	if err != nil {
+		return GenerationParams{}, fmt.Errorf("generation defaults: %w", err)
+	}
+	c, err := cw.store.GetContext(contextID)
+	if err != nil {
+		return GenerationParams{}, fmt.Errorf("generation defaults: %w", err)
+	}
+	return c.Generation, nil
+}
@@ -0,0 +1,207 @@
+package contextwindow
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/responses"
+	"github.com/stretchr/testify/assert"
+)
+
+// optsModel records the options of its last call.
+type optsModel struct {
+	opts CallModelOpts
+}
+
+func (m *optsModel) Call(ctx context.Context, inputs []Record) ([]Record, int, error) {
+	return m.CallWithOpts(ctx, inputs, CallModelOpts{})
+}
+
+func (m *optsModel) CallWithOpts(ctx context.Context, inputs []Record, opts CallModelOpts) ([]Record, int, error) {
+	m.opts = opts
+	return []Record{{Source: ModelResp, Content: "ok", Live: true}}, 1, nil
+}
+
+func (m *optsModel) CallWithThreadingAndOpts(
+	ctx context.Context,
+	useServerSideThreading bool,
+	lastResponseID *string,
+	inputs []Record,
+	opts CallModelOpts,
+) ([]Record, *string, int, error) {
+	events, tokens, err := m.CallWithOpts(ctx, inputs, opts)
+	return events, nil, tokens, err
+}
+
+func TestGenerationDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gen.db")
+	db, err := NewContextDB(path)
+	assert.NoError(t, err)
+
+	m := &optsModel{}
+	cw, err := NewContextWindow(db, m, "gen")
+	assert.NoError(t, err)
+
+	defaults := GenerationParams{
+		MaxTokens:       1000,
+		Temperature:     Float(0.2),
+		Stop:            []string{"END"},
+		ToolChoice:      ToolChoiceNone,
+		ReasoningEffort: ReasoningLow,
+	}
+	assert.NoError(t, cw.SetGenerationDefaults(defaults))
+	assert.Error(t, cw.SetGenerationDefaults(GenerationParams{ReasoningEffort: "extreme"}))
+
+	assert.NoError(t, cw.AddPrompt("hi"))
+	_, err = cw.CallModelWithOpts(context.Background(), CallModelOpts{
+		GenerationParams: GenerationParams{Temperature: Float(0.9), ThinkingBudget: 10000},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, GenerationParams{
+		MaxTokens:      1000,
+		Temperature:    Float(0.9),
+		Stop:           []string{"END"},
+		ToolChoice:     ToolChoiceNone,
+		ThinkingBudget: 10000,
+	}, m.opts.GenerationParams)
+
+	assert.NoError(t, cw.Clone("copy"))
+	assert.NoError(t, db.Close())
+
+	db, err = NewContextDB(path)
+	assert.NoError(t, err)
+	defer db.Close()
+	for _, name := range []string{"gen", "copy"} {
+		cw, err = NewContextWindow(db, m, name)
+		assert.NoError(t, err)
+		got, err := cw.GenerationDefaults()
+		assert.NoError(t, err)
+		assert.Equal(t, defaults, got, name)
+	}
+
+	mem, err := NewContextWindowWithStore(NewMemoryStore(), m, "mem")
+	assert.NoError(t, err)
+	assert.NoError(t, mem.SetGenerationDefaults(defaults))
+	got, err := mem.GenerationDefaults()
+	assert.NoError(t, err)
+	assert.Equal(t, defaults, got)
+}
+
+func TestReasoningEffortAndThinkingBudget(t *testing.T) {
+	assert.Equal(t, ReasoningEffort(""), GenerationParams{}.effort())
+	assert.Equal(t, 0, GenerationParams{}.thinkingBudget())
+	assert.Equal(t, ReasoningLow, GenerationParams{ThinkingBudget: 1024}.effort())
+	assert.Equal(t, ReasoningMedium, GenerationParams{ThinkingBudget: 8192}.effort())
+	assert.Equal(t, ReasoningHigh, GenerationParams{ThinkingBudget: 32000}.effort())
+	assert.Equal(t, thinkingBudgetMedium, GenerationParams{ReasoningEffort: ReasoningMedium}.thinkingBudget())
+	assert.Equal(t, 5000, GenerationParams{ReasoningEffort: ReasoningLow, ThinkingBudget: 5000}.thinkingBudget())
+}
+
+func TestGenerationParamsMapping(t *testing.T) {
+	g := GenerationParams{
+		MaxTokens:       500,
+		Temperature:     Float(0.5),
+		TopP:            Float(0.9),
+		Stop:            []string{"END"},
+		ToolChoice:      "lookup",
+		ReasoningEffort: ReasoningHigh,
+	}
+	marshal := func(v any) map[string]any {
+		js, err := json.Marshal(v)
+		assert.NoError(t, err)
+		var m map[string]any
+		assert.NoError(t, json.Unmarshal(js, &m))
+		return m
+	}
+
+	claude := anthropic.MessageNewParams{Tools: getClaudeToolParams([]ToolDefinition{
+		{Name: "lookup", Definition: NewTool("lookup", "looks up")},
+	})}
+	claudeGenerationParams(&claude, g)
+	body := marshal(claude)
+	assert.EqualValues(t, 500, body["max_tokens"])
+	assert.EqualValues(t, 0.5, body["temperature"])
+	assert.EqualValues(t, 0.9, body["top_p"])
+	assert.Equal(t, []any{"END"}, body["stop_sequences"])
+	assert.Equal(t, map[string]any{"type": "tool", "name": "lookup"}, body["tool_choice"])
+	assert.Equal(t, map[string]any{"type": "enabled", "budget_tokens": float64(thinkingBudgetHigh)}, body["thinking"])
+
+	// Without a limit, Claude leaves room for the answer after thinking
+	claude = anthropic.MessageNewParams{}
+	claudeGenerationParams(&claude, GenerationParams{ThinkingBudget: 2000, ToolChoice: ToolChoiceRequired})
+	body = marshal(claude)
+	assert.EqualValues(t, 2000+claudeDefaultMaxTokens, body["max_tokens"])
+	assert.Nil(t, body["tool_choice"])
+
+	chat := openai.ChatCompletionNewParams{Tools: getToolParamsFromDefinitions([]ToolDefinition{
+		{Name: "lookup", Definition: NewTool("lookup", "looks up")},
+	})}
+	openAIGenerationParams(&chat, g)
+	body = marshal(chat)
+	assert.EqualValues(t, 500, body["max_completion_tokens"])
+	assert.EqualValues(t, 0.5, body["temperature"])
+	assert.Equal(t, []any{"END"}, body["stop"])
+	assert.Equal(t, "high", body["reasoning_effort"])
+	assert.Equal(t, map[string]any{"type": "function", "function": map[string]any{"name": "lookup"}}, body["tool_choice"])
+
+	chat = openai.ChatCompletionNewParams{Tools: chat.Tools}
+	openAIGenerationParams(&chat, GenerationParams{ToolChoice: ToolChoiceRequired})
+	assert.Equal(t, "required", marshal(chat)["tool_choice"])
+
+	resp := responses.ResponseNewParams{Tools: getResponsesToolParamsFromDefinitions([]ToolDefinition{
+		{Name: "lookup", Definition: NewTool("lookup", "looks up")},
+	})}
+	responsesGenerationParams(&resp, g, 0)
+	body = marshal(resp)
+	assert.EqualValues(t, 500, body["max_output_tokens"])
+	assert.EqualValues(t, 0.9, body["top_p"])
+	assert.Equal(t, map[string]any{"effort": "high"}, body["reasoning"])
+	assert.Equal(t, map[string]any{"type": "function", "name": "lookup"}, body["tool_choice"])
+
+	_, _, _, err := (&OpenAIResponsesModel{}).CallWithThreadingAndOpts(context.Background(), false, nil, nil,
+		CallModelOpts{GenerationParams: GenerationParams{Stop: []string{"END"}}})
+	assert.ErrorContains(t, err, "stop sequences")
+
+	// Forced tool choices don't outlive the first round
+	resp = responses.ResponseNewParams{Tools: resp.Tools}
+	responsesGenerationParams(&resp, g, 1)
+	assert.Nil(t, marshal(resp)["tool_choice"])
+}
+
+func TestClaudeThinkingConflicts(t *testing.T) {
+	tools := getClaudeToolParams([]ToolDefinition{{Name: "lookup", Definition: NewTool("lookup", "looks up")}})
+	for _, tc := range []struct {
+		g    GenerationParams
+		want string
+	}{
+		{GenerationParams{ThinkingBudget: 500}, "minimum"},
+		{GenerationParams{ThinkingBudget: 2000, MaxTokens: 2000}, "max tokens"},
+		{GenerationParams{ReasoningEffort: ReasoningLow, Temperature: Float(0.2)}, "temperature"},
+		{GenerationParams{ReasoningEffort: ReasoningLow, TopP: Float(0.9)}, "top p"},
+		{GenerationParams{ReasoningEffort: ReasoningLow, ToolChoice: ToolChoiceRequired}, "tool choice"},
+		{GenerationParams{ReasoningEffort: ReasoningLow, ToolChoice: "lookup"}, "tool choice"},
+	} {
+		params := anthropic.MessageNewParams{Tools: tools}
+		claudeGenerationParams(&params, tc.g)
+		assert.ErrorContains(t, checkClaudeThinking(&params), tc.want)
+	}
+
+	params := anthropic.MessageNewParams{Tools: tools}
+	claudeGenerationParams(&params, GenerationParams{ReasoningEffort: ReasoningLow, ToolChoice: ToolChoiceAuto})
+	assert.NoError(t, checkClaudeThinking(&params))
+	params = anthropic.MessageNewParams{}
+	claudeGenerationParams(&params, GenerationParams{Temperature: Float(0.2), ToolChoice: ToolChoiceRequired})
+	assert.NoError(t, checkClaudeThinking(&params))
+
+	// A response schema forces a tool too
+	m := &ClaudeModel{}
+	_, _, err := m.CallWithOpts(context.Background(), []Record{{Source: Prompt, Content: "hi"}}, CallModelOpts{
+		GenerationParams: GenerationParams{ReasoningEffort: ReasoningLow},
+		ResponseSchema:   &ResponseSchema{Name: "answer", Schema: map[string]any{"type": "object"}},
+	})
+	assert.ErrorContains(t, err, "response schema")
+}
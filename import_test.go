@@ -0,0 +1,108 @@
+package contextwindow
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportContext(t *testing.T) {
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	responseID := "resp_1"
+	m := &dummyModel{events: []Record{
+		{Source: ToolCall, Content: `ls({"dir":"."})`, Live: true, ToolCallID: "call_1", ToolName: "ls", ToolArgs: json.RawMessage(`{"dir":"."}`)},
+		{Source: ToolOutput, Content: "a.txt", Live: true, ToolCallID: "call_1", ToolName: "ls"},
+		{Source: ModelResp, Content: "one file", Live: true, ResponseID: &responseID},
+	}}
+	cw, err := NewContextWindow(db, m, "src")
+	assert.NoError(t, err)
+	assert.NoError(t, cw.SetSystemPrompt("be brief"))
+	assert.NoError(t, cw.AddPromptWithAttachments("list files", NewAttachment("a.txt", "text/plain", []byte("notes"))))
+	_, err = cw.CallModel(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, cw.AddPrompt("scratch"))
+	assert.NoError(t, cw.SetRecordLiveStateByRange(5, 5, false))
+	assert.NoError(t, cw.store.UpdateLastResponseID(mustContextID(t, cw, "src"), responseID))
+	assert.NoError(t, cw.AddTool(NewTool("ls", "lists files"), nil))
+	assert.NoError(t, cw.SetGenerationDefaults(GenerationParams{Temperature: Float(0.2)}))
+
+	data, err := cw.ExportContextJSON("src")
+	assert.NoError(t, err)
+	var export ContextExport
+	assert.NoError(t, json.Unmarshal(data, &export))
+	assert.Equal(t, ContextExportVersion, export.Version)
+
+	check := func(t *testing.T, dst *ContextWindow, name string) {
+		imported, err := dst.ExportContext(name)
+		assert.NoError(t, err)
+		assert.Equal(t, name, imported.Context.Name)
+		assert.NotEqual(t, export.Context.ID, imported.Context.ID)
+		assert.Equal(t, export.Context.LastResponseID, imported.Context.LastResponseID)
+		assert.Equal(t, export.Context.Generation, imported.Context.Generation)
+		if assert.Len(t, imported.Tools, 1) {
+			assert.Equal(t, "ls", imported.Tools[0].ToolName)
+		}
+		if !assert.Len(t, imported.Records, len(export.Records)) {
+			return
+		}
+		for i, want := range export.Records {
+			got := imported.Records[i]
+			assert.True(t, want.Timestamp.Equal(got.Timestamp), i)
+			assert.Equal(t, imported.Context.ID, got.ContextID)
+			assert.Equal(t, want.Source, got.Source)
+			assert.Equal(t, want.Content, got.Content)
+			assert.Equal(t, want.Live, got.Live)
+			assert.Equal(t, want.ResponseID, got.ResponseID)
+			assert.Equal(t, want.ToolCallID, got.ToolCallID)
+			assert.Equal(t, want.ToolName, got.ToolName)
+			assert.Equal(t, want.Attachments, got.Attachments)
+		}
+		assert.False(t, imported.Records[5].Live)
+	}
+
+	t.Run("renamed", func(t *testing.T) {
+		assert.NoError(t, cw.ImportContextJSON(data, "copy"))
+		check(t, cw, "copy")
+		assert.Equal(t, "src", cw.GetCurrentContext())
+	})
+
+	t.Run("another store", func(t *testing.T) {
+		dst, err := NewContextWindowWithStore(NewMemoryStore(), m, "default")
+		assert.NoError(t, err)
+		assert.NoError(t, dst.ImportContext(export, ""))
+		check(t, dst, "src")
+	})
+
+	t.Run("database", func(t *testing.T) {
+		other, err := NewContextDB(":memory:")
+		assert.NoError(t, err)
+		defer other.Close()
+		c, err := ImportContextJSON(other, data, "")
+		assert.NoError(t, err)
+		assert.Equal(t, "src", c.Name)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		assert.ErrorContains(t, cw.ImportContextJSON(data, ""), "already exists")
+		assert.Error(t, cw.ImportContextJSON([]byte("{"), "bad"))
+
+		bad := export
+		bad.Version = 0
+		assert.ErrorContains(t, cw.ImportContext(bad, "bad"), "missing export version")
+		bad.Version = ContextExportVersion + 1
+		assert.ErrorContains(t, cw.ImportContext(bad, "bad"), "newer")
+
+		bad = export
+		bad.Records = append([]Record{}, export.Records...)
+		bad.Records[1].Attachments = []Attachment{{MediaType: "text/plain", Data: []byte("edited"), Hash: export.Records[1].Attachments[0].Hash}}
+		assert.ErrorContains(t, cw.ImportContext(bad, "bad"), "hash")
+
+		_, err := cw.GetContext("bad")
+		assert.Error(t, err)
+	})
+}
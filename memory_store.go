@@ -0,0 +1,592 @@
+package contextwindow
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is a map-based implementation of [Store]. Nothing is
+// persisted; it's meant for tests and for embedding contexts in your own
+// persistence layer. It's safe for concurrent use.
+type MemoryStore struct {
+	mu       sync.Mutex
+	contexts map[string]Context
+	records  []Record
+	tools    map[string][]ContextTool
+	calls    []ModelCall
+	nextID   int64
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		contexts: make(map[string]Context),
+		tools:    make(map[string][]ContextTool),
+	}
+}
+
+func (m *MemoryStore) CreateContext(name string, useServerSideThreading bool) (Context, error) {
+	if name == "" {
+		return Context{}, fmt.Errorf("context name cannot be empty")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.contextByName(name); ok {
+		c.UseServerSideThreading = useServerSideThreading
+		m.contexts[c.ID] = c
+		return c, nil
+	}
+
+	c := Context{
+		ID:                     uuid.New().String(),
+		Name:                   name,
+		StartTime:              time.Now().UTC(),
+		UseServerSideThreading: useServerSideThreading,
+	}
+	m.contexts[c.ID] = c
+	return c, nil
+}
+
+func (m *MemoryStore) GetContext(contextID string) (Context, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.contexts[contextID]
+	if !ok {
+		return Context{}, fmt.Errorf("get context %s: %w", contextID, sql.ErrNoRows)
+	}
+	return c, nil
+}
+
+func (m *MemoryStore) GetContextByName(name string) (Context, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.contextByName(name)
+	if !ok {
+		return Context{}, fmt.Errorf("get context '%s': %w", name, sql.ErrNoRows)
+	}
+	return c, nil
+}
+
+func (m *MemoryStore) contextByName(name string) (Context, bool) {
+	for _, c := range m.contexts {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Context{}, false
+}
+
+func (m *MemoryStore) ListContexts() ([]Context, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var contexts []Context
+	for _, c := range m.contexts {
+		contexts = append(contexts, c)
+	}
+	sort.Slice(contexts, func(i, j int) bool {
+		return contexts[i].StartTime.After(contexts[j].StartTime)
+	})
+	return contexts, nil
+}
+
+func (m *MemoryStore) DeleteContext(contextID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.contexts, contextID)
+	delete(m.tools, contextID)
+
+	kept := m.records[:0]
+	for _, r := range m.records {
+		if r.ContextID != contextID {
+			kept = append(kept, r)
+		}
+	}
+	m.records = kept
+
+	keptCalls := m.calls[:0]
+	for _, c := range m.calls {
+		if c.ContextID != contextID {
+			keptCalls = append(keptCalls, c)
+		}
+	}
+	m.calls = keptCalls
+	return nil
+}
+
+func (m *MemoryStore) CloneContext(sourceName, destName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.copyContext(sourceName, destName, 0); err != nil {
+		return fmt.Errorf("clone from %s to %s: %w", sourceName, destName, err)
+	}
+	return nil
+}
+
+func (m *MemoryStore) ForkContext(sourceName string, recordID int64, destName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	src, ok := m.contextByName(sourceName)
+	if !ok {
+		return fmt.Errorf("fork %s at %d: source context not found: %w", sourceName, recordID, sql.ErrNoRows)
+	}
+	found := false
+	for _, r := range m.records {
+		if r.ID == recordID {
+			if r.ContextID != src.ID {
+				return fmt.Errorf("fork %s at %d: record is in another context", sourceName, recordID)
+			}
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("fork %s at %d: record not found: %w", sourceName, recordID, sql.ErrNoRows)
+	}
+
+	dest, err := m.copyContext(sourceName, destName, recordID)
+	if err != nil {
+		return fmt.Errorf("fork %s at %d: %w", sourceName, recordID, err)
+	}
+	dest.ParentID = src.ID
+	dest.ForkRecordID = recordID
+	for _, r := range m.recordsIn(dest.ID, false) {
+		if r.Live && r.Source == ModelResp && r.ResponseID != nil {
+			dest.LastResponseID = r.ResponseID
+		}
+	}
+	m.contexts[dest.ID] = dest
+	return nil
+}
+
+func (m *MemoryStore) ListChildContexts(contextID string) ([]Context, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var children []Context
+	for _, c := range m.contexts {
+		if c.ParentID == contextID {
+			children = append(children, c)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].StartTime.Before(children[j].StartTime)
+	})
+	return children, nil
+}
+
+// copyContext copies a context, its records (up to and including upTo, in
+// timestamp order, if upTo isn't zero) and its enabled tools. The caller
+// holds m.mu.
+func (m *MemoryStore) copyContext(sourceName, destName string, upTo int64) (Context, error) {
+	if sourceName == "" || destName == "" {
+		return Context{}, fmt.Errorf("source and destination context names cannot be empty")
+	}
+
+	src, ok := m.contextByName(sourceName)
+	if !ok {
+		return Context{}, fmt.Errorf("source context not found: %w", sql.ErrNoRows)
+	}
+	if _, ok := m.contextByName(destName); ok {
+		return Context{}, fmt.Errorf("destination context already exists")
+	}
+
+	dest := Context{
+		ID:                     uuid.New().String(),
+		Name:                   destName,
+		StartTime:              time.Now().UTC(),
+		UseServerSideThreading: src.UseServerSideThreading,
+		Tokenizer:              src.Tokenizer,
+		Generation:             src.Generation,
+	}
+	m.contexts[dest.ID] = dest
+
+	for _, r := range m.recordsIn(src.ID, false) {
+		id := r.ID
+		r = cloneRecord(r)
+		r.ContextID = dest.ID
+		m.nextID++
+		r.ID = m.nextID
+		m.records = append(m.records, r)
+		if id == upTo {
+			break
+		}
+	}
+	for _, t := range m.tools[src.ID] {
+		t.ContextID = dest.ID
+		m.nextID++
+		t.ID = m.nextID
+		m.tools[dest.ID] = append(m.tools[dest.ID], t)
+	}
+	return dest, nil
+}
+
+func (m *MemoryStore) SetServerSideThreading(contextID string, enabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.contexts[contextID]
+	if !ok {
+		return nil
+	}
+	c.UseServerSideThreading = enabled
+	m.contexts[contextID] = c
+	return nil
+}
+
+func (m *MemoryStore) UpdateLastResponseID(contextID, responseID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.contexts[contextID]
+	if !ok {
+		return nil
+	}
+	c.LastResponseID = &responseID
+	m.contexts[contextID] = c
+	return nil
+}
+
+func (m *MemoryStore) ClearLastResponseID(contextID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.contexts[contextID]
+	if !ok {
+		return nil
+	}
+	c.LastResponseID = nil
+	m.contexts[contextID] = c
+	return nil
+}
+
+func (m *MemoryStore) SetContextTokenizer(contextID, tokenizer string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.contexts[contextID]
+	if !ok {
+		return nil
+	}
+	c.Tokenizer = tokenizer
+	m.contexts[contextID] = c
+	return nil
+}
+
+func (m *MemoryStore) SetContextGeneration(contextID string, p GenerationParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.contexts[contextID]
+	if !ok {
+		return nil
+	}
+	c.Generation = p
+	m.contexts[contextID] = c
+	return nil
+}
+
+func (m *MemoryStore) InsertRecord(rec Record) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insert(rec), nil
+}
+
+func (m *MemoryStore) insert(rec Record) Record {
+	rec = cloneRecord(rec)
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now().UTC()
+	}
+	if rec.EstTokens == 0 {
+		rec.EstTokens = recordTokens(cl100kTokenizer, rec)
+	}
+	for i := range rec.Attachments {
+		rec.Attachments[i].Hash = blobHash(rec.Attachments[i].Data)
+	}
+	m.nextID++
+	rec.ID = m.nextID
+	m.records = append(m.records, rec)
+	return cloneRecord(rec)
+}
+
+// cloneRecord copies the pointers and slices of a record, so that the
+// store's records and the caller's don't share memory, as they don't with
+// SQLiteStore.
+func cloneRecord(r Record) Record {
+	if r.ResponseID != nil {
+		id := *r.ResponseID
+		r.ResponseID = &id
+	}
+	if r.Usage != nil {
+		usage := *r.Usage
+		r.Usage = &usage
+	}
+	r.ToolArgs = slices.Clone(r.ToolArgs)
+	if r.Attachments != nil {
+		attachments := make([]Attachment, len(r.Attachments))
+		for i, a := range r.Attachments {
+			a.Data = slices.Clone(a.Data)
+			attachments[i] = a
+		}
+		r.Attachments = attachments
+	}
+	return r
+}
+
+func (m *MemoryStore) ListRecords(contextID string) ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.recordsIn(contextID, false), nil
+}
+
+func (m *MemoryStore) ListLiveRecords(contextID string) ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.recordsIn(contextID, true), nil
+}
+
+// recordsIn returns copies of a context's records in timestamp order.
+func (m *MemoryStore) recordsIn(contextID string, liveOnly bool) []Record {
+	var recs []Record
+	for _, r := range m.records {
+		if r.ContextID == contextID && (r.Live || !liveOnly) {
+			recs = append(recs, cloneRecord(r))
+		}
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].Timestamp.Before(recs[j].Timestamp)
+	})
+	return recs
+}
+
+// Search approximates FTS5: it evaluates the query's words, phrases,
+// prefixes and operators case-insensitively, and ranks results by how many
+// words matched. Queries using other FTS5 syntax, such as column filters,
+// fail with a [SearchQueryError].
+func (m *MemoryStore) Search(q SearchQuery) ([]SearchResult, error) {
+	expr, err := parseSearchQuery(q.Query)
+	if err != nil {
+		return nil, fmt.Errorf("search records: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var results []SearchResult
+	for _, r := range m.records {
+		if !q.matches(r) {
+			continue
+		}
+		hits, snippet := memorySearch(r.Content, expr)
+		if hits == 0 {
+			continue
+		}
+		results = append(results, SearchResult{Record: cloneRecord(r), Snippet: snippet, Rank: -float64(hits)})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Rank < results[j].Rank
+	})
+	if len(results) > q.limit() {
+		results = results[:q.limit()]
+	}
+	return results, nil
+}
+
+func (m *MemoryStore) SetRecordsLive(ids []int64, live bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.setLive(ids, live)
+	return nil
+}
+
+func (m *MemoryStore) setLive(ids []int64, live bool) {
+	set := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	for i := range m.records {
+		if set[m.records[i].ID] {
+			m.records[i].Live = live
+		}
+	}
+}
+
+func (m *MemoryStore) SetRecordTokens(tokens map[int64]int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.records {
+		if n, ok := tokens[m.records[i].ID]; ok {
+			m.records[i].EstTokens = n
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) ReplaceRecords(kill []int64, add []Record) ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.setLive(kill, false)
+
+	var added []Record
+	for _, rec := range add {
+		added = append(added, m.insert(rec))
+	}
+	return added, nil
+}
+
+func (m *MemoryStore) ContextStats(contextID string) (ContextStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var stats ContextStats
+	for _, r := range m.recordsIn(contextID, false) {
+		stats.TotalRecords++
+		if r.Live {
+			stats.LiveRecords++
+			stats.LiveTokens += r.EstTokens
+		}
+		ts := r.Timestamp
+		if stats.LastActivity == nil || ts.After(*stats.LastActivity) {
+			stats.LastActivity = &ts
+		}
+	}
+	spend := m.spend(contextID, time.Time{}, time.Time{})
+	stats.Usage = spend.Usage
+	stats.Cost = spend.Cost
+	return stats, nil
+}
+
+func (m *MemoryStore) Spend(contextID string, from, to time.Time) (Spend, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.spend(contextID, from, to), nil
+}
+
+// spend sums the ledger entries in a context started between from and to.
+// The caller holds m.mu.
+func (m *MemoryStore) spend(contextID string, from, to time.Time) Spend {
+	var spend Spend
+	for _, c := range m.calls {
+		if contextID != "" && c.ContextID != contextID {
+			continue
+		}
+		if c.StartedAt.Before(from) || (!to.IsZero() && !c.StartedAt.Before(to)) {
+			continue
+		}
+		spend.Calls++
+		spend.Requests += c.Requests
+		spend.Cost += c.Cost
+		spend.Usage = spend.Usage.Add(c.Usage)
+	}
+	return spend
+}
+
+func (m *MemoryStore) InsertModelCall(call ModelCall) (ModelCall, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	call.ID = m.nextID
+	call.RecordIDs = append([]int64{}, call.RecordIDs...)
+	m.calls = append(m.calls, call)
+	return call, nil
+}
+
+func (m *MemoryStore) ListModelCalls(contextID string, from, to time.Time) ([]ModelCall, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var calls []ModelCall
+	for _, c := range m.calls {
+		if contextID != "" && c.ContextID != contextID {
+			continue
+		}
+		if c.StartedAt.Before(from) || (!to.IsZero() && !c.StartedAt.Before(to)) {
+			continue
+		}
+		c.RecordIDs = append([]int64{}, c.RecordIDs...)
+		calls = append(calls, c)
+	}
+	sort.SliceStable(calls, func(i, j int) bool {
+		return calls[i].StartedAt.Before(calls[j].StartedAt)
+	})
+	return calls, nil
+}
+
+func (m *MemoryStore) AddContextTool(contextID, toolName string) (ContextTool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tools[contextID] {
+		if t.ToolName == toolName {
+			return ContextTool{}, fmt.Errorf("add context tool: %s already exists", toolName)
+		}
+	}
+
+	m.nextID++
+	t := ContextTool{
+		ID:        m.nextID,
+		ContextID: contextID,
+		ToolName:  toolName,
+		CreatedAt: time.Now().UTC(),
+	}
+	m.tools[contextID] = append(m.tools[contextID], t)
+	return t, nil
+}
+
+func (m *MemoryStore) ListContextTools(contextID string) ([]ContextTool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]ContextTool(nil), m.tools[contextID]...), nil
+}
+
+func (m *MemoryStore) RemoveContextTool(contextID, toolName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tools := m.tools[contextID]
+	for i, t := range tools {
+		if t.ToolName == toolName {
+			m.tools[contextID] = append(tools[:i], tools[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) HasContextTool(contextID, toolName string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tools[contextID] {
+		if t.ToolName == toolName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Close is a no-op; a MemoryStore has nothing to release.
+func (m *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,135 @@
+package contextwindow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreContextWindow(t *testing.T) {
+	cw, err := NewContextWindowWithStore(NewMemoryStore(), &mockModel{}, "mem")
+	assert.NoError(t, err)
+	defer cw.Close()
+
+	assert.NoError(t, cw.SetSystemPrompt("be terse"))
+	assert.NoError(t, cw.SetSystemPrompt("be very terse"))
+	assert.NoError(t, cw.AddPrompt("hello"))
+
+	resp, err := cw.CallModel(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "Mock response", resp)
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 3)
+	assert.Equal(t, SystemPrompt, recs[0].Source)
+	assert.Equal(t, "be very terse", recs[0].Content)
+	assert.Equal(t, Prompt, recs[1].Source)
+	assert.Equal(t, ModelResp, recs[2].Source)
+
+	ctx, err := cw.GetCurrentContextInfo()
+	assert.NoError(t, err)
+	stats, err := cw.GetContextStats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, stats.TotalRecords)
+	assert.Equal(t, 3, stats.LiveRecords)
+
+	assert.NoError(t, cw.SetRecordLiveStateByRange(1, 2, false))
+	recs, err = cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 1)
+}
+
+func TestMemoryStoreSummarize(t *testing.T) {
+	cw, err := NewContextWindowWithStore(NewMemoryStore(), &dummyModel{}, "mem")
+	assert.NoError(t, err)
+	defer cw.Close()
+
+	cw.SetSummarizer(&mockSummarizer{summaryText: "summary"})
+	assert.NoError(t, cw.AddPrompt("one"))
+	assert.NoError(t, cw.AddPrompt("two"))
+
+	result, err := cw.SummarizeLiveContext(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, cw.AcceptSummary(result))
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 1)
+	assert.Equal(t, "summary", recs[0].Content)
+}
+
+func TestMemoryStoreContexts(t *testing.T) {
+	cw, err := NewContextWindowWithStore(NewMemoryStore(), &dummyModel{}, "a")
+	assert.NoError(t, err)
+	defer cw.Close()
+
+	assert.NoError(t, cw.AddPrompt("in a"))
+	err = cw.AddTool(NewTool("echo", "echoes"), ToolRunnerFunc(
+		func(ctx context.Context, args json.RawMessage) (string, error) { return "", nil }))
+	assert.NoError(t, err)
+
+	assert.NoError(t, cw.Clone("b"))
+	assert.NoError(t, cw.SwitchContext("b"))
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 1)
+	assert.Equal(t, "in a", recs[0].Content)
+
+	export, err := cw.ExportContext("a")
+	assert.NoError(t, err)
+	assert.Len(t, export.Records, 1)
+	assert.Len(t, export.Tools, 1)
+
+	contexts, err := cw.ListContexts()
+	assert.NoError(t, err)
+	assert.Len(t, contexts, 2)
+
+	assert.NoError(t, cw.DeleteContext("a"))
+	_, err = cw.GetContext("a")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestStoresDontShareRecordMemory(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		c, err := s.CreateContext("main", false)
+		assert.NoError(t, err)
+		responseID := "resp_1"
+		in := Record{
+			ContextID:   c.ID,
+			Source:      ModelResp,
+			Content:     "answer",
+			Live:        true,
+			ResponseID:  &responseID,
+			ToolArgs:    json.RawMessage(`{"a":1}`),
+			Usage:       &ModelUsage{InputTokens: 1},
+			Attachments: []Attachment{{MediaType: "text/plain", Data: []byte("notes")}},
+		}
+		out, err := s.InsertRecord(in)
+		assert.NoError(t, err)
+
+		// Changing what went in or came out doesn't change what's stored
+		responseID = "changed"
+		in.ToolArgs[1] = 'x'
+		in.Usage.InputTokens = 2
+		in.Attachments[0].Data[0] = 'x'
+		out.Usage.OutputTokens = 3
+		recs, err := s.ListRecords(c.ID)
+		assert.NoError(t, err)
+		recs[0].Attachments[0].Data[1] = 'x'
+		*recs[0].ResponseID = "changed"
+
+		recs, err = s.ListRecords(c.ID)
+		assert.NoError(t, err)
+		if assert.Len(t, recs, 1) {
+			r := recs[0]
+			assert.Equal(t, "resp_1", *r.ResponseID)
+			assert.JSONEq(t, `{"a":1}`, string(r.ToolArgs))
+			assert.Equal(t, ModelUsage{InputTokens: 1}, *r.Usage)
+			assert.Equal(t, []byte("notes"), r.Attachments[0].Data)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package contextwindow
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ModelCall is one entry in the model call ledger: a single CallModel (or
+// CallModelWithOpts, or CallModelStream), however many requests and tool
+// rounds it took, or a single summarizer call. Unlike [Metrics], the ledger
+// is persisted in the store, so it survives restarts; it's the source of
+// [ContextWindow.Spend] and [ContextStats] usage.
+type ModelCall struct {
+	ID         int64     `json:"id"`
+	ContextID  string    `json:"context_id"`
+	Model      string    `json:"model,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+
+	// Latency is the time spent waiting on the provider, summed over the
+	// call's requests; it excludes time spent running tools.
+	Latency  time.Duration `json:"latency"`
+	Requests int           `json:"requests"`
+
+	TokensUsed int        `json:"tokens_used"`
+	Usage      ModelUsage `json:"usage"`
+	Cost       float64    `json:"cost"`
+
+	// StopReason is the provider's stop reason for the last request.
+	StopReason string `json:"stop_reason,omitempty"`
+
+	// RecordIDs are the records the call produced, in order. Summarizer
+	// calls produce none until their summary is accepted.
+	RecordIDs []int64 `json:"record_ids"`
+
+	// Error is set for calls that failed or hit a tool loop limit.
+	Error string `json:"error,omitempty"`
+}
+
+// GetModelCalls returns the ledger entries for a context, oldest first.
+func (cw *ContextWindow) GetModelCalls(context Context) ([]ModelCall, error) {
+	return cw.store.ListModelCalls(context.ID, time.Time{}, time.Time{})
+}
+
+// ModelCalls returns the ledger entries for all contexts that started
+// between from (inclusive) and to (exclusive), oldest first. A zero to means
+// "until now".
+func (cw *ContextWindow) ModelCalls(from, to time.Time) ([]ModelCall, error) {
+	return cw.store.ListModelCalls("", from, to)
+}
+
+// modelCallObserver collects what the adapters report about each request
+// of a model call, for the ledger. callModel hangs one off the context.
+type modelCallObserver struct {
+	mu         sync.Mutex
+	model      string
+	requests   int
+	latency    time.Duration
+	usage      ModelUsage
+	stopReason string
+}
+
+type modelCallObserverKey struct{}
+
+func withModelCallObserver(ctx context.Context, obs *modelCallObserver) context.Context {
+	return context.WithValue(ctx, modelCallObserverKey{}, obs)
+}
+
+// observeModelResponse records a successful request with the call's
+// observer, if it has one.
+func observeModelResponse(ctx context.Context, req ModelRequest, resp ModelResponse) {
+	obs, ok := ctx.Value(modelCallObserverKey{}).(*modelCallObserver)
+	if !ok {
+		return
+	}
+
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+	obs.model = req.Model
+	obs.requests++
+	obs.latency += resp.Latency
+	obs.usage = obs.usage.Add(resp.Usage)
+	obs.stopReason = resp.StopReason
+}
+
+// ledgerEntry builds the ledger entry for a finished call from what was
+// observed and the records it produced. Records carrying usage override the
+// observed usage, for models that don't go through the adapters; events
+// that weren't stored aren't listed in RecordIDs.
+func (cw *ContextWindow) ledgerEntry(
+	contextID string,
+	obs *modelCallObserver,
+	started time.Time,
+	tokensUsed int,
+	events []Record,
+	callErr error,
+) ModelCall {
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+
+	call := ModelCall{
+		ContextID:  contextID,
+		Model:      obs.model,
+		StartedAt:  started.UTC(),
+		FinishedAt: time.Now().UTC(),
+		Latency:    obs.latency,
+		Requests:   obs.requests,
+		TokensUsed: tokensUsed,
+		Usage:      obs.usage,
+		StopReason: obs.stopReason,
+		RecordIDs:  []int64{},
+	}
+	if call.Requests == 0 {
+		call.Latency = call.FinishedAt.Sub(call.StartedAt)
+	}
+
+	var reported bool
+	for _, event := range events {
+		if event.ID != 0 {
+			call.RecordIDs = append(call.RecordIDs, event.ID)
+		}
+		if event.Usage == nil {
+			continue
+		}
+		if !reported {
+			call.Usage, call.Cost, reported = ModelUsage{}, 0, true
+		}
+		call.Usage = call.Usage.Add(*event.Usage)
+		call.Cost += event.Cost
+		if event.Model != "" {
+			call.Model = event.Model
+		}
+	}
+	if !reported {
+		call.Cost = cw.prices.Cost(call.Model, call.Usage)
+	}
+	if call.TokensUsed == 0 {
+		call.TokensUsed = call.Usage.Total()
+	}
+	if callErr != nil {
+		call.Error = callErr.Error()
+	}
+	return call
+}
@@ -0,0 +1,133 @@
+package contextwindow
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
+	"github.com/openai/openai-go/v2/shared"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelCallLedger(t *testing.T) {
+	m := fakeOpenAIModel(t, func(n int) string {
+		if n == 1 {
+			return chatToolCallReply(n)
+		}
+		return `{
+			"id": "chatcmpl-done", "object": "chat.completion", "created": 1, "model": "gpt-4o",
+			"choices": [{"index": 0, "finish_reason": "stop",
+				"message": {"role": "assistant", "content": "done"}}],
+			"usage": {"prompt_tokens": 15, "completion_tokens": 5, "total_tokens": 20}
+		}`
+	})
+
+	path := filepath.Join(t.TempDir(), "cw.db")
+	db, err := NewContextDB(path)
+	assert.NoError(t, err)
+
+	cw, err := NewContextWindow(db, m, "ledger")
+	assert.NoError(t, err)
+	err = cw.RegisterTool("echo", NewTool("echo", "echoes"), ToolRunnerFunc(
+		func(ctx context.Context, args json.RawMessage) (string, error) {
+			return "echoed", nil
+		}))
+	assert.NoError(t, err)
+
+	assert.NoError(t, cw.AddPrompt("hi"))
+	_, err = cw.CallModel(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	// The ledger outlives the process; TotalTokens doesn't.
+	db, err = NewContextDB(path)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err = NewContextWindow(db, m, "ledger")
+	assert.NoError(t, err)
+	assert.Zero(t, cw.TotalTokens())
+
+	info, err := cw.GetCurrentContextInfo()
+	assert.NoError(t, err)
+	calls, err := cw.GetModelCalls(info)
+	assert.NoError(t, err)
+	assert.Len(t, calls, 1)
+
+	call := calls[0]
+	assert.Equal(t, info.ID, call.ContextID)
+	assert.Equal(t, "gpt-4o", call.Model)
+	assert.Equal(t, 2, call.Requests)
+	assert.Equal(t, "stop", call.StopReason)
+	assert.Equal(t, 30, call.TokensUsed)
+	assert.Equal(t, 20, call.Usage.InputTokens)
+	assert.Greater(t, call.Latency, time.Duration(0))
+	assert.False(t, call.FinishedAt.Before(call.StartedAt))
+	assert.Empty(t, call.Error)
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	var produced []int64
+	for _, r := range recs[1:] {
+		produced = append(produced, r.ID)
+	}
+	assert.Equal(t, produced, call.RecordIDs)
+
+	calls, err = cw.ModelCalls(time.Now(), time.Time{})
+	assert.NoError(t, err)
+	assert.Empty(t, calls)
+
+	assert.NoError(t, cw.DeleteContext("ledger"))
+	calls, err = cw.ModelCalls(time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.Empty(t, calls)
+}
+
+func TestModelCallLedgerRecordsFailures(t *testing.T) {
+	var n int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		if n == 1 {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, chatToolCallReply(n))
+			return
+		}
+		http.Error(w, `{"error": {"message": "bad"}}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	client := openai.NewClient(
+		option.WithAPIKey("test"),
+		option.WithBaseURL(srv.URL),
+		option.WithMaxRetries(0),
+	)
+	m := &OpenAIModel{client: &client, model: shared.ChatModelGPT4o}
+
+	cw, err := NewContextWindowWithStore(NewMemoryStore(), m, "failing")
+	assert.NoError(t, err)
+	err = cw.RegisterTool("echo", NewTool("echo", "echoes"), ToolRunnerFunc(
+		func(ctx context.Context, args json.RawMessage) (string, error) {
+			return "echoed", nil
+		}))
+	assert.NoError(t, err)
+
+	assert.NoError(t, cw.AddPrompt("hi"))
+	_, err = cw.CallModel(context.Background())
+	assert.Error(t, err)
+
+	calls, err := cw.ModelCalls(time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, calls, 1)
+	assert.Equal(t, 1, calls[0].Requests)
+	assert.Equal(t, 10, calls[0].TokensUsed)
+	assert.Equal(t, "tool_calls", calls[0].StopReason)
+	assert.Empty(t, calls[0].RecordIDs)
+	assert.NotEmpty(t, calls[0].Error)
+}
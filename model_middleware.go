@@ -0,0 +1,90 @@
+package contextwindow
+
+import (
+	"context"
+	"time"
+)
+
+// ModelMiddleware is an optional interface for [Middleware] that want to see
+// every request the model adapters make, including the continuations sent
+// after tool calls. Add it with [ContextWindow.AddMiddleware] like any other
+// middleware.
+type ModelMiddleware interface {
+	// OnModelRequest is invoked before each request. The context it returns
+	// is used for the request and passed to the matching OnModelResponse or
+	// OnModelError, so it can carry a trace span.
+	OnModelRequest(ctx context.Context, req ModelRequest) context.Context
+	// OnModelResponse is invoked when a request succeeds.
+	OnModelResponse(ctx context.Context, req ModelRequest, resp ModelResponse)
+	// OnModelError is invoked when a request fails.
+	OnModelError(ctx context.Context, req ModelRequest, err error)
+}
+
+// ModelRequest describes one request to a model provider.
+type ModelRequest struct {
+	Provider string // "anthropic", "openai" or "openai-responses"
+	Model    string
+
+	// Inputs are the records the request was built from: the call's inputs,
+	// followed by the tool calls and outputs of earlier rounds.
+	Inputs []Record
+	Opts   CallModelOpts
+
+	// Round is 0 for the first request of a call, and n for the
+	// continuation after the nth round of tool calls.
+	Round int
+
+	// Attempt is 0 for the first try of a request, and n for the nth retry
+	// under a RetryPolicy.
+	Attempt int
+}
+
+// ModelResponse describes the outcome of a successful ModelRequest.
+type ModelResponse struct {
+	Usage      ModelUsage
+	Latency    time.Duration
+	StopReason string // as reported by the provider, e.g. "end_turn" or "tool_calls"
+	ResponseID string
+}
+
+// observeModelRequest runs send, invoking any ModelMiddleware around it.
+// send reports what middleware should see of a successful response; the
+// latency is filled in here.
+func observeModelRequest[T any](
+	ctx context.Context,
+	middleware []Middleware,
+	req ModelRequest,
+	send func(ctx context.Context) (T, ModelResponse, error),
+) (T, error) {
+	var hooks []ModelMiddleware
+	for _, m := range middleware {
+		if mm, ok := m.(ModelMiddleware); ok {
+			hooks = append(hooks, mm)
+			ctx = mm.OnModelRequest(ctx, req)
+		}
+	}
+
+	start := time.Now()
+	resp, info, err := send(ctx)
+	if err != nil {
+		for _, mm := range hooks {
+			mm.OnModelError(ctx, req, err)
+		}
+		return resp, err
+	}
+
+	info.Latency = time.Since(start)
+	observeModelResponse(ctx, req, info)
+	for _, mm := range hooks {
+		mm.OnModelResponse(ctx, req, info)
+	}
+	return resp, nil
+}
+
+// requestInputs returns the records behind a request in a given round.
+func requestInputs(inputs, events []Record) []Record {
+	if len(events) == 0 {
+		return inputs
+	}
+	return append(inputs[:len(inputs):len(inputs)], events...)
+}
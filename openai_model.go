@@ -2,12 +2,11 @@ package contextwindow
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"os"
 
 	"github.com/openai/openai-go/v2"
 	"github.com/openai/openai-go/v2/option"
+	"github.com/openai/openai-go/v2/packages/param"
 	"github.com/openai/openai-go/v2/shared"
 )
 
@@ -23,15 +22,19 @@ type OpenAIModel struct {
 	model        shared.ChatModel
 	middleware   []Middleware
 	toolExecutor ToolExecutor
+	retry        *RetryPolicy
 }
 
 type llmToolParam = openai.ChatCompletionToolUnionParam
 
-func NewOpenAIModel(model shared.ChatModel) (*OpenAIModel, error) {
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		return nil, fmt.Errorf("OPENAI_API_KEY not set")
+// NewOpenAIModel returns an OpenAI chat completions model. The API key comes
+// from OPENAI_API_KEY unless set with [WithAPIKey].
+func NewOpenAIModel(model shared.ChatModel, opts ...ClientOption) (*OpenAIModel, error) {
+	cfg, err := newClientConfig("OPENAI_API_KEY", opts)
+	if err != nil {
+		return nil, err
 	}
-	client := openai.NewClient(option.WithAPIKey(os.Getenv("OPENAI_API_KEY")))
+	client := openai.NewClient(cfg.openAIOptions()...)
 	return &OpenAIModel{client: &client, model: model}, nil
 }
 
@@ -39,6 +42,16 @@ func (o *OpenAIModel) MaxTokens() int {
 	return 128_000
 }
 
+// Tokenizer returns the tokenizer for the model, cl100k_base.
+func (o *OpenAIModel) Tokenizer() Tokenizer {
+	return cl100kTokenizer
+}
+
+// SupportsResponseSchema reports that the model honors response_format.
+func (o *OpenAIModel) SupportsResponseSchema() bool {
+	return true
+}
+
 // SetMiddleware sets the middleware for the OpenAI model
 func (o *OpenAIModel) SetMiddleware(middleware []Middleware) {
 	o.middleware = middleware
@@ -49,6 +62,19 @@ func (o *OpenAIModel) SetToolExecutor(executor ToolExecutor) {
 	o.toolExecutor = executor
 }
 
+// SetRetryPolicy implements RetryCapable interface
+func (o *OpenAIModel) SetRetryPolicy(policy *RetryPolicy) {
+	o.retry = policy
+}
+
+// requestOptions turns off SDK retries when we do our own.
+func (o *OpenAIModel) requestOptions() []option.RequestOption {
+	if o.retry == nil {
+		return nil
+	}
+	return []option.RequestOption{option.WithMaxRetries(0)}
+}
+
 func (o *OpenAIModel) Call(
 	ctx context.Context,
 	inputs []Record,
@@ -60,28 +86,38 @@ func (o *OpenAIModel) CallWithOpts(
 	ctx context.Context,
 	inputs []Record,
 	opts CallModelOpts,
+) ([]Record, int, error) {
+	return o.call(ctx, inputs, opts, nil)
+}
+
+// CallStream implements StreamingCapable interface
+func (o *OpenAIModel) CallStream(
+	ctx context.Context,
+	useServerSideThreading bool,
+	lastResponseID *string,
+	inputs []Record,
+	opts CallModelOpts,
+	stream chan<- StreamEvent,
+) ([]Record, *string, int, error) {
+	if useServerSideThreading {
+		return nil, nil, 0, fmt.Errorf("server-side threading not supported by OpenAI completions API")
+	}
+
+	events, tokensUsed, err := o.call(ctx, inputs, opts, stream)
+	return events, nil, tokensUsed, err
+}
+
+func (o *OpenAIModel) call(
+	ctx context.Context,
+	inputs []Record,
+	opts CallModelOpts,
+	stream chan<- StreamEvent,
 ) ([]Record, int, error) {
 	var availableTools []ToolDefinition
 	if o.toolExecutor != nil && !opts.DisableTools {
 		availableTools = o.toolExecutor.GetRegisteredTools()
 	}
-	var messages []openai.ChatCompletionMessageParamUnion
-	for _, rec := range inputs {
-		switch rec.Source {
-		case SystemPrompt:
-			messages = append([]openai.ChatCompletionMessageParamUnion{openai.SystemMessage(rec.Content)}, messages...)
-		case Prompt:
-			messages = append(messages, openai.UserMessage(rec.Content))
-		case ModelResp:
-			messages = append(messages, openai.AssistantMessage(rec.Content))
-		case ToolCall:
-			// For now, we'll just put the raw content in a message.
-			// This will need to be revisited.
-			messages = append(messages, openai.AssistantMessage(rec.Content))
-		case ToolOutput:
-			messages = append(messages, openai.UserMessage(rec.Content))
-		}
-	}
+	messages := openAIMessages(inputs)
 
 	toolParams := getToolParamsFromDefinitions(availableTools)
 
@@ -90,7 +126,28 @@ func (o *OpenAIModel) CallWithOpts(
 		Messages: messages,
 		Tools:    toolParams,
 	}
-	resp, err := o.client.Chat.Completions.New(ctx, params)
+	openAIGenerationParams(&params, opts.GenerationParams)
+	if rs := opts.ResponseSchema; rs != nil {
+		params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{
+				JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
+					Name:   rs.Name,
+					Schema: rs.Schema,
+					Strict: param.NewOpt(rs.Strict),
+				},
+			},
+		}
+		if rs.Description != "" {
+			params.ResponseFormat.OfJSONSchema.JSONSchema.Description = param.NewOpt(rs.Description)
+		}
+	}
+	req := ModelRequest{
+		Provider: "openai",
+		Model:    string(o.model),
+		Inputs:   inputs,
+		Opts:     opts,
+	}
+	resp, err := o.send(ctx, req, params, stream)
 	if err != nil {
 		return nil, 0, fmt.Errorf("OpenAI chat: %w", err)
 	}
@@ -101,43 +158,49 @@ func (o *OpenAIModel) CallWithOpts(
 	choice := resp.Choices[0].Message
 
 	var events []Record
+	usage := openAIChatUsage(resp.Usage)
+	loop := newToolLoop(opts)
 	for len(choice.ToolCalls) > 0 {
-		messages = append(messages, choice.ToParam())
+		if err := loop.next(events, usage.Total()); err != nil {
+			return nil, 0, err
+		}
 
+		var calls []pendingToolCall
 		for _, tc := range choice.ToolCalls {
-			for _, m := range o.middleware {
-				m.OnToolCall(ctx, tc.Function.Name, string(tc.Function.Arguments))
-			}
+			calls = append(calls, pendingToolCall{
+				ID:   tc.ID,
+				Name: tc.Function.Name,
+				Args: tc.Function.Arguments,
+			})
+		}
 
-			out, err := o.toolExecutor.ExecuteTool(ctx, tc.Function.Name, json.RawMessage(tc.Function.Arguments))
-			if err != nil {
-				out = fmt.Sprintf("error: %s", err)
-			}
+		results := runToolCalls(ctx, o.toolExecutor, o.middleware, stream, calls, opts.ToolTimeout)
 
-			for _, m := range o.middleware {
-				m.OnToolResult(ctx, tc.Function.Name, out, err)
+		// The tool calls are replayed with the arguments the tools ran
+		// with, which a ToolApprover may have rewritten
+		assistant := choice.ToParam()
+		for i, tc := range assistant.OfAssistant.ToolCalls {
+			if tc.OfFunction != nil {
+				tc.OfFunction.Function.Arguments = results[i].Args
 			}
+		}
+		messages = append(messages, assistant)
 
-			messages = append(messages, openai.ToolMessage(out, tc.ID))
+		for i, call := range calls {
+			out := results[i].Output
+			messages = append(messages, openai.ToolMessage(out, call.ID))
 
 			// Also record these events for persistence
-			call := fmt.Sprintf("%s(%s)", tc.Function.Name, tc.Function.Arguments)
-			events = append(events, Record{
-				Source:    ToolCall,
-				Content:   call,
-				Live:      true,
-				EstTokens: tokenCount(call),
-			})
-			events = append(events, Record{
-				Source:    ToolOutput,
-				Content:   out,
-				Live:      true,
-				EstTokens: tokenCount(out),
-			})
+			events = append(events, toolCallRecords(o.Tokenizer(), call.ID, call.Name, results[i].Args, out, results[i].Err != nil)...)
 		}
 
 		params.Messages = messages
-		resp, err := o.client.Chat.Completions.New(ctx, params)
+		if opts.ToolChoice.forced() {
+			params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{}
+		}
+		req.Inputs = requestInputs(inputs, events)
+		req.Round++
+		resp, err = o.send(ctx, req, params, stream)
 		if err != nil {
 			return nil, 0, fmt.Errorf("OpenAI chat: %w", err)
 		}
@@ -146,16 +209,18 @@ func (o *OpenAIModel) CallWithOpts(
 		}
 
 		choice = resp.Choices[0].Message
+		usage = usage.Add(openAIChatUsage(resp.Usage))
 	}
 
 	events = append(events, Record{
 		Source:    ModelResp,
 		Content:   choice.Content,
 		Live:      true,
-		EstTokens: tokenCount(choice.Content),
+		EstTokens: o.Tokenizer().CountTokens(choice.Content),
+		Usage:     &usage,
+		Model:     string(o.model),
 	})
-	tokensUsed := int(resp.Usage.TotalTokens)
-	return events, tokensUsed, nil
+	return events, usage.Total(), nil
 }
 
 // CallWithThreading implements ServerSideThreadingCapable interface
@@ -185,6 +250,197 @@ func (o *OpenAIModel) CallWithThreadingAndOpts(
 	return events, nil, tokensUsed, err
 }
 
+// openAIGenerationParams maps generation settings to chat completion
+// params. Tool choice is only sent with tools.
+func openAIGenerationParams(params *openai.ChatCompletionNewParams, g GenerationParams) {
+	if g.MaxTokens > 0 {
+		params.MaxCompletionTokens = param.NewOpt(int64(g.MaxTokens))
+	}
+	if g.Temperature != nil {
+		params.Temperature = param.NewOpt(*g.Temperature)
+	}
+	if g.TopP != nil {
+		params.TopP = param.NewOpt(*g.TopP)
+	}
+	if len(g.Stop) > 0 {
+		params.Stop = openai.ChatCompletionNewParamsStopUnion{OfStringArray: g.Stop}
+	}
+	if effort := g.effort(); effort != "" {
+		params.ReasoningEffort = shared.ReasoningEffort(effort)
+	}
+
+	if len(params.Tools) == 0 || g.ToolChoice == "" {
+		return
+	}
+	if name, ok := g.ToolChoice.toolName(); ok {
+		params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{
+			OfFunctionToolChoice: &openai.ChatCompletionNamedToolChoiceParam{
+				Function: openai.ChatCompletionNamedToolChoiceFunctionParam{Name: name},
+			},
+		}
+		return
+	}
+	params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{
+		OfAuto: param.NewOpt(string(g.ToolChoice)),
+	}
+}
+
+// openAIMessages converts records to chat completion messages.
+func openAIMessages(inputs []Record) []openai.ChatCompletionMessageParamUnion {
+	var messages []openai.ChatCompletionMessageParamUnion
+	for _, rec := range inputs {
+		switch rec.Source {
+		case SystemPrompt:
+			messages = append([]openai.ChatCompletionMessageParamUnion{openai.SystemMessage(rec.Content)}, messages...)
+		case Prompt:
+			if len(rec.Attachments) > 0 {
+				messages = append(messages, openai.UserMessage(openAIPromptParts(rec)))
+				continue
+			}
+			messages = append(messages, openai.UserMessage(rec.Content))
+		case ModelResp:
+			messages = append(messages, openai.AssistantMessage(rec.Content))
+		case ToolCall:
+			messages = appendOpenAIToolCall(messages, rec)
+		case ToolOutput:
+			if rec.ToolCallID == "" {
+				messages = append(messages, openai.UserMessage(rec.Content))
+				continue
+			}
+			messages = append(messages, openai.ToolMessage(rec.Content, rec.ToolCallID))
+		}
+	}
+	return messages
+}
+
+// openAIPromptParts converts a prompt with attachments to content parts:
+// its text, followed by images as image_url parts, PDFs as file parts and
+// text documents as text.
+func openAIPromptParts(rec Record) []openai.ChatCompletionContentPartUnionParam {
+	var parts []openai.ChatCompletionContentPartUnionParam
+	if rec.Content != "" {
+		parts = append(parts, openai.TextContentPart(rec.Content))
+	}
+	for _, a := range rec.Attachments {
+		switch {
+		case a.IsImage():
+			parts = append(parts, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{
+				URL: a.dataURL(),
+			}))
+		case a.IsPDF():
+			parts = append(parts, openai.FileContentPart(openai.ChatCompletionContentPartFileFileParam{
+				FileData: openai.String(a.dataURL()),
+				Filename: openai.String(a.filename()),
+			}))
+		default:
+			parts = append(parts, openai.TextContentPart(a.filename()+":\n"+string(a.Data)))
+		}
+	}
+	return parts
+}
+
+// appendOpenAIToolCall replays a ToolCall record as an assistant tool_calls
+// message, merging consecutive calls into one message. Records without a
+// tool call ID (from before we stored them) are sent as assistant text.
+func appendOpenAIToolCall(
+	messages []openai.ChatCompletionMessageParamUnion,
+	rec Record,
+) []openai.ChatCompletionMessageParamUnion {
+	if rec.ToolCallID == "" {
+		return append(messages, openai.AssistantMessage(rec.Content))
+	}
+
+	call := openai.ChatCompletionMessageToolCallUnionParam{
+		OfFunction: &openai.ChatCompletionMessageFunctionToolCallParam{
+			ID: rec.ToolCallID,
+			Function: openai.ChatCompletionMessageFunctionToolCallFunctionParam{
+				Name:      rec.ToolName,
+				Arguments: string(toolArgsOrEmpty(rec)),
+			},
+		},
+	}
+
+	if n := len(messages); n > 0 {
+		last := messages[n-1].OfAssistant
+		if last != nil && len(last.ToolCalls) > 0 {
+			last.ToolCalls = append(last.ToolCalls, call)
+			return messages
+		}
+	}
+	return append(messages, openai.ChatCompletionMessageParamUnion{
+		OfAssistant: &openai.ChatCompletionAssistantMessageParam{
+			ToolCalls: []openai.ChatCompletionMessageToolCallUnionParam{call},
+		},
+	})
+}
+
+// send makes one chat completion request on behalf of req, under
+// ModelMiddleware.
+func (o *OpenAIModel) send(
+	ctx context.Context,
+	req ModelRequest,
+	params openai.ChatCompletionNewParams,
+	stream chan<- StreamEvent,
+) (*openai.ChatCompletion, error) {
+	return sendModelRequest(ctx, o.retry, o.middleware, req, func(ctx context.Context) (*openai.ChatCompletion, ModelResponse, error) {
+		resp, err := o.newCompletion(ctx, params, stream)
+		if err != nil {
+			return nil, ModelResponse{}, err
+		}
+		info := ModelResponse{
+			Usage:      openAIChatUsage(resp.Usage),
+			ResponseID: resp.ID,
+		}
+		if len(resp.Choices) > 0 {
+			info.StopReason = resp.Choices[0].FinishReason
+		}
+		return resp, info, nil
+	})
+}
+
+// newCompletion sends one chat completion request, streaming content deltas
+// to stream if it isn't nil.
+func (o *OpenAIModel) newCompletion(
+	ctx context.Context,
+	params openai.ChatCompletionNewParams,
+	stream chan<- StreamEvent,
+) (*openai.ChatCompletion, error) {
+	if stream == nil {
+		return o.client.Chat.Completions.New(ctx, params, o.requestOptions()...)
+	}
+
+	params.StreamOptions = openai.ChatCompletionStreamOptionsParam{
+		IncludeUsage: openai.Bool(true),
+	}
+	s := o.client.Chat.Completions.NewStreaming(ctx, params, o.requestOptions()...)
+	defer s.Close()
+
+	var acc openai.ChatCompletionAccumulator
+	streamed := false
+	for s.Next() {
+		chunk := s.Current()
+		if !acc.AddChunk(chunk) {
+			return nil, fmt.Errorf("accumulate stream: mismatched chunk")
+		}
+		for _, c := range chunk.Choices {
+			if c.Delta.Content != "" {
+				streamed = true
+				sendStreamEvent(ctx, stream, StreamEvent{
+					Type: StreamTextDelta,
+					Text: c.Delta.Content,
+				})
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		if streamed {
+			return nil, &streamInterruptedError{err}
+		}
+		return nil, err
+	}
+	return &acc.ChatCompletion, nil
+}
+
 // getToolParamsFromDefinitions converts ToolDefinitions to OpenAI tool parameters.
 func getToolParamsFromDefinitions(availableTools []ToolDefinition) []llmToolParam {
 	var toolParams []llmToolParam
@@ -3,10 +3,17 @@ package contextwindow
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
 	"github.com/openai/openai-go/v2/packages/param"
 	"github.com/openai/openai-go/v2/shared"
 	"github.com/stretchr/testify/assert"
@@ -111,3 +118,288 @@ func TestOpenAIModel_SystemPrompt(t *testing.T) {
 
 	assert.Contains(t, resp, "MUMON")
 }
+
+func TestOpenAIMessagesToolReplay(t *testing.T) {
+	inputs := []Record{
+		{Source: SystemPrompt, Content: "be terse"},
+		{Source: Prompt, Content: "list files"},
+	}
+	inputs = append(inputs, toolCallRecords(CL100KTokenizer(), "call_1", "ls", `{"dir":"."}`, "go.mod", false)...)
+	inputs = append(inputs, Record{Source: ModelResp, Content: "go.mod"})
+	inputs = append(inputs, Record{Source: ToolCall, Content: "old(x)"})
+
+	messages := openAIMessages(inputs)
+	assert.Len(t, messages, 6)
+
+	assert.NotNil(t, messages[0].OfSystem)
+	assert.NotNil(t, messages[1].OfUser)
+
+	call := messages[2].OfAssistant
+	assert.NotNil(t, call)
+	assert.Len(t, call.ToolCalls, 1)
+	assert.Equal(t, "call_1", call.ToolCalls[0].OfFunction.ID)
+	assert.Equal(t, "ls", call.ToolCalls[0].OfFunction.Function.Name)
+	assert.JSONEq(t, `{"dir":"."}`, call.ToolCalls[0].OfFunction.Function.Arguments)
+
+	result := messages[3].OfTool
+	assert.NotNil(t, result)
+	assert.Equal(t, "call_1", result.ToolCallID)
+
+	assert.NotNil(t, messages[4].OfAssistant)
+	legacy := messages[5].OfAssistant
+	assert.NotNil(t, legacy)
+	assert.Empty(t, legacy.ToolCalls)
+}
+
+// fakeOpenAIModel returns an OpenAIModel talking to a test server that
+// answers every chat completion request with reply.
+func fakeOpenAIModel(t *testing.T, reply func(n int) string) *OpenAIModel {
+	var n int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, reply(n))
+	}))
+	t.Cleanup(srv.Close)
+
+	client := openai.NewClient(
+		option.WithAPIKey("test"),
+		option.WithBaseURL(srv.URL),
+		option.WithMaxRetries(0),
+	)
+	return &OpenAIModel{client: &client, model: shared.ChatModelGPT4o}
+}
+
+func chatToolCallReply(n int) string {
+	return fmt.Sprintf(`{
+		"id": "chatcmpl-%d", "object": "chat.completion", "created": 1, "model": "gpt-4o",
+		"choices": [{"index": 0, "finish_reason": "tool_calls", "message": {
+			"role": "assistant", "content": null,
+			"tool_calls": [{"id": "call_%d", "type": "function",
+				"function": {"name": "echo", "arguments": "{}"}}]
+		}}],
+		"usage": {"prompt_tokens": 5, "completion_tokens": 5, "total_tokens": 10}
+	}`, n, n)
+}
+
+func TestOpenAIModelToolLoopLimits(t *testing.T) {
+	m := fakeOpenAIModel(t, chatToolCallReply)
+
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err := NewContextWindow(db, m, "limits")
+	assert.NoError(t, err)
+	err = cw.RegisterTool("echo", NewTool("echo", "echoes"), ToolRunnerFunc(
+		func(ctx context.Context, args json.RawMessage) (string, error) {
+			return "echoed", nil
+		}))
+	assert.NoError(t, err)
+	assert.NoError(t, cw.AddPrompt("loop forever"))
+
+	_, err = cw.CallModelWithOpts(context.Background(), CallModelOpts{MaxToolRounds: 2})
+	var loopErr *ToolLoopError
+	assert.ErrorAs(t, err, &loopErr)
+	assert.Equal(t, LimitToolRounds, loopErr.Limit)
+	assert.Equal(t, 2, loopErr.Rounds)
+	assert.Equal(t, 30, loopErr.TokensUsed)
+
+	// The two completed rounds are persisted.
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 5)
+	assert.Equal(t, "call_1", recs[1].ToolCallID)
+	assert.Equal(t, "call_2", recs[3].ToolCallID)
+
+	_, err = cw.CallModelWithOpts(context.Background(), CallModelOpts{TokenBudget: 25})
+	assert.ErrorAs(t, err, &loopErr)
+	assert.Equal(t, LimitTokenBudget, loopErr.Limit)
+	assert.Equal(t, 2, loopErr.Rounds)
+}
+
+func TestOpenAIModelToolTimeout(t *testing.T) {
+	m := fakeOpenAIModel(t, func(n int) string {
+		if n == 1 {
+			return chatToolCallReply(n)
+		}
+		return `{
+			"id": "chatcmpl-done", "object": "chat.completion", "created": 1, "model": "gpt-4o",
+			"choices": [{"index": 0, "finish_reason": "stop",
+				"message": {"role": "assistant", "content": "done"}}],
+			"usage": {"prompt_tokens": 5, "completion_tokens": 5, "total_tokens": 10}
+		}`
+	})
+
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err := NewContextWindow(db, m, "timeout")
+	assert.NoError(t, err)
+	err = cw.RegisterTool("echo", NewTool("echo", "echoes"), ToolRunnerFunc(
+		func(ctx context.Context, args json.RawMessage) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}))
+	assert.NoError(t, err)
+	assert.NoError(t, cw.AddPrompt("hang"))
+
+	resp, err := cw.CallModelWithOpts(context.Background(), CallModelOpts{ToolTimeout: 10 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.Equal(t, "done", resp)
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 4)
+	assert.Contains(t, recs[2].Content, "deadline exceeded")
+}
+
+type recordingModelMiddleware struct {
+	testMiddleware
+	requests  []ModelRequest
+	responses []ModelResponse
+	errs      []error
+	sawKey    bool
+}
+
+type traceKey struct{}
+
+func (m *recordingModelMiddleware) OnModelRequest(ctx context.Context, req ModelRequest) context.Context {
+	m.requests = append(m.requests, req)
+	return context.WithValue(ctx, traceKey{}, true)
+}
+
+func (m *recordingModelMiddleware) OnModelResponse(ctx context.Context, req ModelRequest, resp ModelResponse) {
+	m.sawKey = ctx.Value(traceKey{}) != nil
+	m.responses = append(m.responses, resp)
+}
+
+func (m *recordingModelMiddleware) OnModelError(ctx context.Context, req ModelRequest, err error) {
+	m.errs = append(m.errs, err)
+}
+
+func TestOpenAIModelMiddleware(t *testing.T) {
+	m := fakeOpenAIModel(t, func(n int) string {
+		if n == 1 {
+			return chatToolCallReply(n)
+		}
+		return `{
+			"id": "chatcmpl-done", "object": "chat.completion", "created": 1, "model": "gpt-4o",
+			"choices": [{"index": 0, "finish_reason": "stop",
+				"message": {"role": "assistant", "content": "done"}}],
+			"usage": {"prompt_tokens": 7, "completion_tokens": 3, "total_tokens": 10}
+		}`
+	})
+
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err := NewContextWindow(db, m, "middleware")
+	assert.NoError(t, err)
+	err = cw.RegisterTool("echo", NewTool("echo", "echoes"), ToolRunnerFunc(
+		func(ctx context.Context, args json.RawMessage) (string, error) {
+			return "echoed", nil
+		}))
+	assert.NoError(t, err)
+
+	mw := &recordingModelMiddleware{}
+	cw.AddMiddleware(mw)
+	assert.NoError(t, cw.AddPrompt("hi"))
+
+	_, err = cw.CallModel(context.Background())
+	assert.NoError(t, err)
+
+	assert.Len(t, mw.requests, 2)
+	assert.Equal(t, "openai", mw.requests[0].Provider)
+	assert.Equal(t, 0, mw.requests[0].Round)
+	assert.Len(t, mw.requests[0].Inputs, 1)
+	assert.Equal(t, 1, mw.requests[1].Round)
+	assert.Len(t, mw.requests[1].Inputs, 3)
+
+	assert.Len(t, mw.responses, 2)
+	assert.Equal(t, "tool_calls", mw.responses[0].StopReason)
+	assert.Equal(t, "stop", mw.responses[1].StopReason)
+	assert.Equal(t, ModelUsage{InputTokens: 7, OutputTokens: 3}, mw.responses[1].Usage)
+	assert.Equal(t, "chatcmpl-done", mw.responses[1].ResponseID)
+	assert.True(t, mw.sawKey)
+	assert.Empty(t, mw.errs)
+	assert.Len(t, mw.toolCalls, 1)
+}
+
+func TestOpenAIModelMiddlewareError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": {"message": "nope"}}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	client := openai.NewClient(option.WithAPIKey("test"), option.WithBaseURL(srv.URL))
+	m := &OpenAIModel{client: &client, model: shared.ChatModelGPT4o}
+
+	mw := &recordingModelMiddleware{}
+	m.SetMiddleware([]Middleware{mw})
+
+	_, _, err := m.Call(context.Background(), []Record{{Source: Prompt, Content: "hi"}})
+	assert.Error(t, err)
+	assert.Len(t, mw.requests, 1)
+	assert.Len(t, mw.errs, 1)
+	assert.Empty(t, mw.responses)
+}
+
+func TestOpenAIModelUsageAcrossToolRounds(t *testing.T) {
+	m := fakeOpenAIModel(t, func(n int) string {
+		if n == 1 {
+			return chatToolCallReply(n)
+		}
+		return `{
+			"id": "chatcmpl-done", "object": "chat.completion", "created": 1, "model": "gpt-4o",
+			"choices": [{"index": 0, "finish_reason": "stop",
+				"message": {"role": "assistant", "content": "done"}}],
+			"usage": {"prompt_tokens": 20, "completion_tokens": 8, "total_tokens": 28,
+				"prompt_tokens_details": {"cached_tokens": 16},
+				"completion_tokens_details": {"reasoning_tokens": 4}}
+		}`
+	})
+
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err := NewContextWindow(db, m, "usage")
+	assert.NoError(t, err)
+	err = cw.RegisterTool("echo", NewTool("echo", "echoes"), ToolRunnerFunc(
+		func(ctx context.Context, args json.RawMessage) (string, error) {
+			return "echoed", nil
+		}))
+	assert.NoError(t, err)
+	assert.NoError(t, cw.AddPrompt("hi"))
+
+	_, err = cw.CallModel(context.Background())
+	assert.NoError(t, err)
+
+	want := ModelUsage{
+		InputTokens:     25,
+		OutputTokens:    13,
+		CacheReadTokens: 16,
+		ReasoningTokens: 4,
+	}
+
+	usage, err := cw.TokenUsage()
+	assert.NoError(t, err)
+	assert.Equal(t, 38, usage.Total)
+	assert.Equal(t, want, usage.Usage)
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	last := recs[len(recs)-1]
+	assert.Equal(t, ModelResp, last.Source)
+	assert.Equal(t, &want, last.Usage)
+	assert.Nil(t, recs[0].Usage)
+
+	info, err := cw.GetCurrentContextInfo()
+	assert.NoError(t, err)
+	stats, err := cw.GetContextStats(info)
+	assert.NoError(t, err)
+	assert.Equal(t, want, stats.Usage)
+}
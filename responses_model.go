@@ -2,9 +2,8 @@ package contextwindow
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/openai/openai-go/v2"
@@ -19,13 +18,17 @@ type OpenAIResponsesModel struct {
 	model        shared.ResponsesModel
 	middleware   []Middleware
 	toolExecutor ToolExecutor
+	retry        *RetryPolicy
 }
 
-func NewOpenAIResponsesModel(model shared.ResponsesModel) (*OpenAIResponsesModel, error) {
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		return nil, fmt.Errorf("OPENAI_API_KEY not set")
+// NewOpenAIResponsesModel returns an OpenAI Responses API model. The API key
+// comes from OPENAI_API_KEY unless set with [WithAPIKey].
+func NewOpenAIResponsesModel(model shared.ResponsesModel, opts ...ClientOption) (*OpenAIResponsesModel, error) {
+	cfg, err := newClientConfig("OPENAI_API_KEY", opts)
+	if err != nil {
+		return nil, err
 	}
-	client := openai.NewClient(option.WithAPIKey(os.Getenv("OPENAI_API_KEY")))
+	client := openai.NewClient(cfg.openAIOptions()...)
 	return &OpenAIResponsesModel{client: &client, model: model}, nil
 }
 
@@ -33,6 +36,16 @@ func (o *OpenAIResponsesModel) MaxTokens() int {
 	return 128_000
 }
 
+// Tokenizer returns the tokenizer for the model, cl100k_base.
+func (o *OpenAIResponsesModel) Tokenizer() Tokenizer {
+	return cl100kTokenizer
+}
+
+// SupportsResponseSchema reports that the model honors text.format.
+func (o *OpenAIResponsesModel) SupportsResponseSchema() bool {
+	return true
+}
+
 func (o *OpenAIResponsesModel) SetMiddleware(middleware []Middleware) {
 	o.middleware = middleware
 }
@@ -41,27 +54,17 @@ func (o *OpenAIResponsesModel) SetToolExecutor(executor ToolExecutor) {
 	o.toolExecutor = executor
 }
 
-func encodeMessage(msg, src string) responses.ResponseInputItemUnionParam {
-	// this is fucking satanic
-	ricups := []responses.ResponseInputContentUnionParam{}
-	ricups = append(ricups, responses.ResponseInputContentParamOfInputText(msg))
-	return responses.ResponseInputItemParamOfInputMessage(ricups, src)
+func (o *OpenAIResponsesModel) SetRetryPolicy(policy *RetryPolicy) {
+	o.retry = policy
 }
 
-// 	content := make([]responses.ResponseInputContentUnionParam, 0, len(message.Content))
-
-// 	for _, block := range message.Content {
-// 		item, err := EncodeUserContentBlock(block)
-// 		if err != nil {
-// 			return responses.ResponseInputItemUnionParam{}, fmt.Errorf("failed to encode content block: %w", err)
-// 		}
-// 		if item != nil {
-// 			content = append(content, *item)
-// 		}
-// 	}
-
-// 	return responses.ResponseInputItemParamOfInputMessage(content, "user"), nil
-// }
+// requestOptions turns off SDK retries when we do our own.
+func (o *OpenAIResponsesModel) requestOptions() []option.RequestOption {
+	if o.retry == nil {
+		return nil
+	}
+	return []option.RequestOption{option.WithMaxRetries(0)}
+}
 
 func (o *OpenAIResponsesModel) Call(
 	ctx context.Context,
@@ -79,48 +82,50 @@ func (o *OpenAIResponsesModel) CallWithOpts(
 	return events, tokensUsed, err
 }
 
-// callLLM is a wrapper helper for all LLM calls that handles threading logic
+// callLLM makes one Responses request with the given input items. If a
+// request threaded onto previousResponseID fails, it's retried with the full
+// history of req.Inputs, unless it failed after streaming text: the retry
+// would stream that text again.
 func (o *OpenAIResponsesModel) callLLM(
 	ctx context.Context,
-	fullMessageHistory string,
+	req ModelRequest,
+	input responses.ResponseInputParam,
 	toolParams []responses.ToolUnionParam,
 	previousResponseID *string,
+	stream chan<- StreamEvent,
 ) (*responses.Response, error) {
 	params := responses.ResponseNewParams{
 		Model:             o.model,
 		Tools:             toolParams,
 		ParallelToolCalls: param.NewOpt(true),
+		Input:             responses.ResponseNewParamsInputUnion{OfInputItemList: input},
 	}
-
 	if previousResponseID != nil {
-		// Server-side threading: extract just the latest prompt
-		latestPrompt := o.extractLatestPromptFromHistory(fullMessageHistory)
-		if latestPrompt != "" {
-			params.Input = responses.ResponseNewParamsInputUnion{
-				OfString: param.NewOpt(latestPrompt),
-			}
-			params.PreviousResponseID = param.NewOpt(*previousResponseID)
-		} else {
-			// Fallback if can't extract prompt
-			params.Input = responses.ResponseNewParamsInputUnion{
-				OfString: param.NewOpt(fullMessageHistory),
-			}
+		params.PreviousResponseID = param.NewOpt(*previousResponseID)
+	}
+	responsesGenerationParams(&params, req.Opts.GenerationParams, req.Round)
+	if rs := req.Opts.ResponseSchema; rs != nil {
+		params.Text = responses.ResponseTextConfigParam{
+			Format: responses.ResponseFormatTextConfigUnionParam{
+				OfJSONSchema: &responses.ResponseFormatTextJSONSchemaConfigParam{
+					Name:   rs.Name,
+					Schema: rs.Schema,
+					Strict: param.NewOpt(rs.Strict),
+				},
+			},
 		}
-	} else {
-		// Client-side threading: use full message history
-		params.Input = responses.ResponseNewParamsInputUnion{
-			OfString: param.NewOpt(fullMessageHistory),
+		if rs.Description != "" {
+			params.Text.Format.OfJSONSchema.Description = param.NewOpt(rs.Description)
 		}
 	}
 
-	resp, err := o.client.Responses.New(ctx, params)
-	if err != nil && previousResponseID != nil {
+	resp, err := o.send(ctx, req, params, stream)
+	var interrupted *streamInterruptedError
+	if err != nil && previousResponseID != nil && !errors.As(err, &interrupted) {
 		// If server-side threading failed, try falling back to client-side
-		params.Input = responses.ResponseNewParamsInputUnion{
-			OfString: param.NewOpt(fullMessageHistory),
-		}
+		params.Input = responses.ResponseNewParamsInputUnion{OfInputItemList: responsesInputItems(req.Inputs)}
 		params.PreviousResponseID = param.Null[string]()
-		resp, err = o.client.Responses.New(ctx, params)
+		resp, err = o.send(ctx, req, params, stream)
 		if err != nil {
 			return nil, fmt.Errorf("OpenAI responses (fallback): %w", err)
 		}
@@ -131,6 +136,78 @@ func (o *OpenAIResponsesModel) callLLM(
 	return resp, nil
 }
 
+// send makes one Responses request on behalf of req, under ModelMiddleware.
+func (o *OpenAIResponsesModel) send(
+	ctx context.Context,
+	req ModelRequest,
+	params responses.ResponseNewParams,
+	stream chan<- StreamEvent,
+) (*responses.Response, error) {
+	return sendModelRequest(ctx, o.retry, o.middleware, req, func(ctx context.Context) (*responses.Response, ModelResponse, error) {
+		resp, err := o.newResponse(ctx, params, stream)
+		if err != nil {
+			return nil, ModelResponse{}, err
+		}
+		stop := string(resp.Status)
+		if resp.IncompleteDetails.Reason != "" {
+			stop = resp.IncompleteDetails.Reason
+		}
+		return resp, ModelResponse{
+			Usage:      responsesUsage(resp.Usage),
+			StopReason: stop,
+			ResponseID: resp.ID,
+		}, nil
+	})
+}
+
+// newResponse sends one Responses request, streaming output text deltas to
+// stream if it isn't nil.
+func (o *OpenAIResponsesModel) newResponse(
+	ctx context.Context,
+	params responses.ResponseNewParams,
+	stream chan<- StreamEvent,
+) (*responses.Response, error) {
+	if stream == nil {
+		return o.client.Responses.New(ctx, params, o.requestOptions()...)
+	}
+
+	s := o.client.Responses.NewStreaming(ctx, params, o.requestOptions()...)
+	defer s.Close()
+
+	var resp *responses.Response
+	streamed := false
+	for s.Next() {
+		event := s.Current()
+		switch event.Type {
+		case "response.output_text.delta":
+			streamed = true
+			sendStreamEvent(ctx, stream, StreamEvent{
+				Type: StreamTextDelta,
+				Text: event.Delta,
+			})
+		case "response.completed":
+			completed := event.Response
+			resp = &completed
+		case "response.failed", "response.incomplete":
+			err := fmt.Errorf("response %s", strings.TrimPrefix(event.Type, "response."))
+			if streamed {
+				return nil, &streamInterruptedError{err}
+			}
+			return nil, err
+		}
+	}
+	if err := s.Err(); err != nil {
+		if streamed {
+			return nil, &streamInterruptedError{err}
+		}
+		return nil, err
+	}
+	if resp == nil {
+		return nil, fmt.Errorf("stream ended without a completed response")
+	}
+	return resp, nil
+}
+
 func (o *OpenAIResponsesModel) CallWithThreading(
 	ctx context.Context,
 	useServerSideThreading bool,
@@ -147,6 +224,33 @@ func (o *OpenAIResponsesModel) CallWithThreadingAndOpts(
 	inputs []Record,
 	opts CallModelOpts,
 ) ([]Record, *string, int, error) {
+	return o.call(ctx, useServerSideThreading, lastResponseID, inputs, opts, nil)
+}
+
+// CallStream implements StreamingCapable interface
+func (o *OpenAIResponsesModel) CallStream(
+	ctx context.Context,
+	useServerSideThreading bool,
+	lastResponseID *string,
+	inputs []Record,
+	opts CallModelOpts,
+	stream chan<- StreamEvent,
+) ([]Record, *string, int, error) {
+	return o.call(ctx, useServerSideThreading, lastResponseID, inputs, opts, stream)
+}
+
+func (o *OpenAIResponsesModel) call(
+	ctx context.Context,
+	useServerSideThreading bool,
+	lastResponseID *string,
+	inputs []Record,
+	opts CallModelOpts,
+	stream chan<- StreamEvent,
+) ([]Record, *string, int, error) {
+	if len(opts.Stop) > 0 {
+		return nil, nil, 0, fmt.Errorf("stop sequences not supported by OpenAI responses API")
+	}
+
 	var availableTools []ToolDefinition
 	if o.toolExecutor != nil && !opts.DisableTools {
 		availableTools = o.toolExecutor.GetRegisteredTools()
@@ -154,25 +258,28 @@ func (o *OpenAIResponsesModel) CallWithThreadingAndOpts(
 
 	toolParams := getResponsesToolParamsFromDefinitions(availableTools)
 
-	// Always prepare full conversation history
-	fullMessageHistory := o.convertRecordsToInput(inputs)
-
-	// Determine if we should use server-side threading
+	// With server-side threading, send only what the server hasn't seen
+	// since the last response; otherwise send the whole conversation.
+	input := responsesInputItems(inputs)
 	var previousResponseID *string
 	if useServerSideThreading && lastResponseID != nil && o.canUseServerSideThreading(inputs) {
 		previousResponseID = lastResponseID
+		input = responsesInputItems(responsesThreadRecords(inputs))
 	}
 
 	// Make the LLM call through our wrapper
-	resp, err := o.callLLM(ctx, fullMessageHistory, toolParams, previousResponseID)
+	req := ModelRequest{
+		Provider: "openai-responses",
+		Model:    string(o.model),
+		Inputs:   inputs,
+		Opts:     opts,
+	}
+	resp, err := o.callLLM(ctx, req, input, toolParams, previousResponseID, stream)
 	if err != nil {
 		return nil, nil, 0, err
 	}
 
-	var (
-		events         []Record
-		toolCallsFound = false
-	)
+	var events []Record
 
 	hasToolCall := func(r []responses.ResponseOutputItemUnion) bool {
 		for _, it := range r {
@@ -184,83 +291,54 @@ func (o *OpenAIResponsesModel) CallWithThreadingAndOpts(
 		return false
 	}
 
-	toolCallsFound = hasToolCall(resp.Output)
-
-	rawToolCall := func(it *responses.ResponseOutputItemUnion) string {
-		for _, m := range o.middleware {
-			m.OnToolCall(ctx, it.Name, it.Arguments)
-		}
-
-		out, err := o.toolExecutor.ExecuteTool(ctx, it.Name, json.RawMessage(it.Arguments))
-		if err != nil {
-			out = fmt.Sprintf("error: %s", err)
+	usage := responsesUsage(resp.Usage)
+	loop := newToolLoop(opts)
+	for hasToolCall(resp.Output) {
+		if err := loop.next(events, usage.Total()); err != nil {
+			return nil, nil, 0, err
 		}
 
-		for _, m := range o.middleware {
-			m.OnToolResult(ctx, it.Name, out, err)
+		var calls []pendingToolCall
+		for _, item := range resp.Output {
+			if item.Type == "function_call" {
+				calls = append(calls, pendingToolCall{
+					ID:   item.CallID,
+					Name: item.Name,
+					Args: item.Arguments,
+				})
+			}
 		}
 
-		return out
-	}
-
-	type responseItem = responses.ResponseOutputItemUnion
-	type toolResult struct {
-		item   responseItem
-		output string
-	}
-
-	// Handle tool calls - build up the conversation history progressively
-	currentHistory := fullMessageHistory
-
-	for toolCallsFound {
-		toolResults := []toolResult{}
-		var toolCallsText []string
-
-		for _, lastResponseItem := range resp.Output {
-			if lastResponseItem.Type == "function_call" {
-				var (
-					out  = rawToolCall(&lastResponseItem)
-					call = fmt.Sprintf("%s(%s)", lastResponseItem.Name, lastResponseItem.Arguments)
-				)
-
-				// save the tool call & output to the database
-				events = append(events, Record{
-					Source:    ToolCall,
-					Content:   call,
-					Live:      true,
-					EstTokens: tokenCount(call),
-				})
-				events = append(events, Record{
-					Source:    ToolOutput,
-					Content:   out,
-					Live:      true,
-					EstTokens: tokenCount(out),
-				})
+		results := runToolCalls(ctx, o.toolExecutor, o.middleware, stream, calls, opts.ToolTimeout)
 
-				toolResults = append(toolResults, toolResult{
-					item:   lastResponseItem,
-					output: out,
-				})
+		var outputs responses.ResponseInputParam
+		rewritten := false
+		for i, c := range calls {
+			rewritten = rewritten || results[i].Args != c.Args
+			out := results[i].Output
 
-				// Add to text representation
-				toolCallsText = append(toolCallsText, "Tool Call: "+call)
-				toolCallsText = append(toolCallsText, "Tool Output: "+out)
-			}
+			// save the tool call & output to the database
+			events = append(events, toolCallRecords(o.Tokenizer(), c.ID, c.Name, results[i].Args, out, results[i].Err != nil)...)
+			outputs = append(outputs, responses.ResponseInputItemParamOfFunctionCallOutput(c.ID, out))
 		}
 
-		// Update the conversation history with tool interactions
-		for _, toolText := range toolCallsText {
-			currentHistory += "\n" + toolText
+		// A threaded conversation stays threaded through tool calls: the
+		// server already has the function calls, so only their outputs
+		// are sent. Otherwise, or if a ToolApprover rewrote the arguments
+		// of a call the server has, the whole conversation is sent again.
+		req.Inputs = requestInputs(inputs, events)
+		req.Round++
+		if useServerSideThreading && !rewritten {
+			input, previousResponseID = outputs, &resp.ID
+		} else {
+			input, previousResponseID = responsesInputItems(req.Inputs), nil
 		}
-
-		// For tool calls, always use client-side threading (full history)
-		// because tool call state is complex
-		resp, err = o.callLLM(ctx, currentHistory, toolParams, nil)
+		resp, err = o.callLLM(ctx, req, input, toolParams, previousResponseID, stream)
 		if err != nil {
 			return nil, nil, 0, fmt.Errorf("tool call response: %w", err)
 		}
 
-		toolCallsFound = hasToolCall(resp.Output)
+		usage = usage.Add(responsesUsage(resp.Usage))
 	}
 
 	content := resp.OutputText()
@@ -268,31 +346,154 @@ func (o *OpenAIResponsesModel) CallWithThreadingAndOpts(
 		Source:     ModelResp,
 		Content:    content,
 		Live:       true,
-		EstTokens:  tokenCount(content),
+		EstTokens:  o.Tokenizer().CountTokens(content),
 		ResponseID: &resp.ID,
+		Usage:      &usage,
+		Model:      string(o.model),
 	})
 
-	tokensUsed := int(resp.Usage.TotalTokens)
-	return events, &resp.ID, tokensUsed, nil
+	return events, &resp.ID, usage.Total(), nil
+}
+
+// responsesGenerationParams maps generation settings to Responses params.
+// The Responses API has no stop sequences. Tool choice is only sent with
+// tools, and forced choices only in the first round of a call.
+func responsesGenerationParams(params *responses.ResponseNewParams, g GenerationParams, round int) {
+	if g.MaxTokens > 0 {
+		params.MaxOutputTokens = param.NewOpt(int64(g.MaxTokens))
+	}
+	if g.Temperature != nil {
+		params.Temperature = param.NewOpt(*g.Temperature)
+	}
+	if g.TopP != nil {
+		params.TopP = param.NewOpt(*g.TopP)
+	}
+	if effort := g.effort(); effort != "" {
+		params.Reasoning = shared.ReasoningParam{Effort: shared.ReasoningEffort(effort)}
+	}
+
+	if len(params.Tools) == 0 || g.ToolChoice == "" || (round > 0 && g.ToolChoice.forced()) {
+		return
+	}
+	if name, ok := g.ToolChoice.toolName(); ok {
+		params.ToolChoice = responses.ResponseNewParamsToolChoiceUnion{
+			OfFunctionTool: &responses.ToolChoiceFunctionParam{Name: name},
+		}
+		return
+	}
+	params.ToolChoice = responses.ResponseNewParamsToolChoiceUnion{
+		OfToolChoiceMode: param.NewOpt(responses.ToolChoiceOptions(g.ToolChoice)),
+	}
 }
 
-func (o *OpenAIResponsesModel) convertRecordsToInput(inputs []Record) string {
-	var parts []string
+// responsesInputItems converts records to Responses input items: system,
+// user and assistant messages, and function_call and function_call_output
+// items for tool records. Tool records without a call ID (from before we
+// stored them) are sent as assistant and user text.
+func responsesInputItems(inputs []Record) responses.ResponseInputParam {
+	var items responses.ResponseInputParam
 	for _, rec := range inputs {
 		switch rec.Source {
 		case SystemPrompt:
-			parts = append([]string{"System: " + rec.Content}, parts...)
+			items = append(responses.ResponseInputParam{
+				responses.ResponseInputItemParamOfMessage(rec.Content, responses.EasyInputMessageRoleSystem),
+			}, items...)
 		case Prompt:
-			parts = append(parts, "User: "+rec.Content)
+			items = append(items, responsesUserMessage(rec))
 		case ModelResp:
-			parts = append(parts, "Assistant: "+rec.Content)
+			items = append(items, responses.ResponseInputItemParamOfMessage(rec.Content, responses.EasyInputMessageRoleAssistant))
 		case ToolCall:
-			parts = append(parts, "Tool Call: "+rec.Content)
+			if rec.ToolCallID == "" {
+				items = append(items, responses.ResponseInputItemParamOfMessage(rec.Content, responses.EasyInputMessageRoleAssistant))
+				continue
+			}
+			items = append(items, responses.ResponseInputItemParamOfFunctionCall(
+				string(toolArgsOrEmpty(rec)),
+				rec.ToolCallID,
+				rec.ToolName,
+			))
 		case ToolOutput:
-			parts = append(parts, "Tool Output: "+rec.Content)
+			if rec.ToolCallID == "" {
+				items = append(items, responses.ResponseInputItemParamOfMessage(rec.Content, responses.EasyInputMessageRoleUser))
+				continue
+			}
+			items = append(items, responses.ResponseInputItemParamOfFunctionCallOutput(rec.ToolCallID, rec.Content))
+		}
+	}
+	return items
+}
+
+// responsesUserMessage converts a prompt to a user message. With
+// attachments, its content is the text followed by input_image and
+// input_file parts.
+func responsesUserMessage(rec Record) responses.ResponseInputItemUnionParam {
+	if len(rec.Attachments) == 0 {
+		return responses.ResponseInputItemParamOfMessage(rec.Content, responses.EasyInputMessageRoleUser)
+	}
+
+	var content responses.ResponseInputMessageContentListParam
+	if rec.Content != "" {
+		content = append(content, responses.ResponseInputContentParamOfInputText(rec.Content))
+	}
+	for _, a := range rec.Attachments {
+		content = append(content, responsesAttachmentContent(a))
+	}
+	return responses.ResponseInputItemParamOfMessage(content, responses.EasyInputMessageRoleUser)
+}
+
+// responsesThreadRecords returns the records a server-side threaded request
+// sends: everything after the last model response, which the server already
+// has. Without a model response, it's everything from the latest prompt.
+func responsesThreadRecords(inputs []Record) []Record {
+	start := -1
+	for i := len(inputs) - 1; i >= 0; i-- {
+		if inputs[i].Source == ModelResp {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		for i := len(inputs) - 1; i >= 0; i-- {
+			if inputs[i].Source == Prompt {
+				start = i
+				break
+			}
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+
+	var recs []Record
+	for _, rec := range inputs[start:] {
+		if rec.Source != SystemPrompt {
+			recs = append(recs, rec)
+		}
+	}
+	return recs
+}
+
+// responsesAttachmentContent converts an attachment to an input_image or
+// input_file part, or input_text for text documents.
+func responsesAttachmentContent(a Attachment) responses.ResponseInputContentUnionParam {
+	switch {
+	case a.IsImage():
+		return responses.ResponseInputContentUnionParam{
+			OfInputImage: &responses.ResponseInputImageParam{
+				Detail:   responses.ResponseInputImageDetailAuto,
+				ImageURL: param.NewOpt(a.dataURL()),
+			},
 		}
+	case a.IsPDF():
+		return responses.ResponseInputContentUnionParam{
+			OfInputFile: &responses.ResponseInputFileParam{
+				FileData: param.NewOpt(a.dataURL()),
+				Filename: param.NewOpt(a.filename()),
+			},
+		}
+	default:
+		return responses.ResponseInputContentParamOfInputText(a.filename() + ":\n" + string(a.Data))
 	}
-	return strings.Join(parts, "\n")
 }
 
 func getResponsesToolParamsFromDefinitions(availableTools []ToolDefinition) []responses.ToolUnionParam {
@@ -335,16 +536,9 @@ func getResponsesToolParamsFromDefinitions(availableTools []ToolDefinition) []re
 }
 
 // canUseServerSideThreading checks if server-side threading is safe to use
-// Returns false if there are gaps in response IDs, tool calls, or other issues
+// Returns false if there are gaps in response IDs or other issues. Tool calls
+// are fine: they're part of the server's thread.
 func (o *OpenAIResponsesModel) canUseServerSideThreading(inputs []Record) bool {
-	// Check for any tool calls in the conversation
-	// Tool calls create complex state that may not thread properly
-	for _, input := range inputs {
-		if input.Source == ToolCall || input.Source == ToolOutput {
-			return false
-		}
-	}
-
 	// Look for the most recent model response to check for gaps
 	var lastModelResponse *Record
 	for i := len(inputs) - 1; i >= 0; i-- {
@@ -379,25 +573,3 @@ func (o *OpenAIResponsesModel) canUseServerSideThreading(inputs []Record) bool {
 
 	return true
 }
-
-// extractLatestPromptFromHistory extracts the most recent "User:" line from full message history
-func (o *OpenAIResponsesModel) extractLatestPromptFromHistory(fullHistory string) string {
-	lines := strings.Split(fullHistory, "\n")
-	for i := len(lines) - 1; i >= 0; i-- {
-		line := strings.TrimSpace(lines[i])
-		if strings.HasPrefix(line, "User: ") {
-			return line
-		}
-	}
-	return ""
-}
-
-// getLatestPrompt extracts the most recent user prompt from inputs for server-side threading
-func (o *OpenAIResponsesModel) getLatestPrompt(inputs []Record) string {
-	for i := len(inputs) - 1; i >= 0; i-- {
-		if inputs[i].Source == Prompt {
-			return "User: " + inputs[i].Content
-		}
-	}
-	return ""
-}
@@ -2,9 +2,15 @@ package contextwindow
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
+	"github.com/openai/openai-go/v2"
 	"github.com/openai/openai-go/v2/option"
 	"github.com/openai/openai-go/v2/packages/param"
 	"github.com/openai/openai-go/v2/responses"
@@ -76,22 +82,26 @@ func TestNewOpenAIResponsesModel_NoAPIKey(t *testing.T) {
 	assert.Contains(t, err.Error(), "OPENAI_API_KEY not set")
 }
 
-func TestOpenAIResponsesModel_ConvertRecordsToInput(t *testing.T) {
-	t.Setenv("OPENAI_API_KEY", "test-key")
-
-	model, err := NewOpenAIResponsesModel(shared.ResponsesModelO1Pro)
-	assert.NoError(t, err)
-
+func TestResponsesInputItems(t *testing.T) {
 	inputs := []Record{
-		{Source: Prompt, Content: "Hello"},
+		{Source: Prompt, Content: "Hello\nacross lines"},
+		{Source: SystemPrompt, Content: "Be brief"},
 		{Source: ModelResp, Content: "Hi there!"},
-		{Source: ToolCall, Content: "search(\"test\")"},
-		{Source: ToolOutput, Content: "search results"},
+		{Source: ToolCall, Content: `search("test")`, ToolCallID: "call_1", ToolName: "search", ToolArgs: json.RawMessage(`{"q":"test"}`)},
+		{Source: ToolOutput, Content: "search results", ToolCallID: "call_1"},
+		{Source: ToolCall, Content: "legacy()"},
 	}
 
-	result := model.convertRecordsToInput(inputs)
-	expected := "User: Hello\nAssistant: Hi there!\nTool Call: search(\"test\")\nTool Output: search results"
-	assert.Equal(t, expected, result)
+	body, err := json.Marshal(responsesInputItems(inputs))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"role": "system", "content": "Be brief"},
+		{"role": "user", "content": "Hello\nacross lines"},
+		{"role": "assistant", "content": "Hi there!"},
+		{"type": "function_call", "call_id": "call_1", "name": "search", "arguments": "{\"q\":\"test\"}"},
+		{"type": "function_call_output", "call_id": "call_1", "output": "search results"},
+		{"role": "assistant", "content": "legacy()"}
+	]`, string(body))
 }
 
 func TestOpenAIResponsesModel_SystemPrompt(t *testing.T) {
@@ -134,14 +144,15 @@ func TestCanUseServerSideThreading(t *testing.T) {
 	}
 	assert.True(t, model.canUseServerSideThreading(cleanInputs))
 
-	// Test case 2: Has tool calls - should not work
+	// Test case 2: Has tool calls - they're part of the thread, so it works
 	toolInputs := []Record{
 		{Source: Prompt, Content: "Hello"},
+		{Source: ToolCall, Content: "some_tool({})", ToolCallID: "call_1"},
+		{Source: ToolOutput, Content: "tool output", ToolCallID: "call_1"},
 		{Source: ModelResp, Content: "Hi there", ResponseID: &responseID},
-		{Source: ToolCall, Content: "some_tool({})"},
-		{Source: ToolOutput, Content: "tool output"},
+		{Source: Prompt, Content: "Thanks"},
 	}
-	assert.False(t, model.canUseServerSideThreading(toolInputs))
+	assert.True(t, model.canUseServerSideThreading(toolInputs))
 
 	// Test case 3: Missing response ID - should not work
 	missingIDInputs := []Record{
@@ -171,61 +182,86 @@ func TestCanUseServerSideThreading(t *testing.T) {
 	assert.True(t, model.canUseServerSideThreading(noResponseInputs))
 }
 
-func TestGetLatestPrompt(t *testing.T) {
-	model := &OpenAIResponsesModel{}
+func TestResponsesThreadRecords(t *testing.T) {
+	responseID := "resp_123"
 
-	// Test with multiple prompts
+	// Everything after the last response is sent, multi-line prompts intact
 	inputs := []Record{
 		{Source: SystemPrompt, Content: "System message"},
 		{Source: Prompt, Content: "First prompt"},
-		{Source: ModelResp, Content: "First response"},
-		{Source: Prompt, Content: "Second prompt"},
-		{Source: ModelResp, Content: "Second response"},
-		{Source: Prompt, Content: "Latest prompt"},
+		{Source: ModelResp, Content: "First response", ResponseID: &responseID},
+		{Source: ToolCall, Content: "lookup({})", ToolCallID: "call_1"},
+		{Source: ToolOutput, Content: "found it", ToolCallID: "call_1"},
+		{Source: Prompt, Content: "Latest prompt\nwith a second line"},
 	}
+	assert.Equal(t, inputs[3:], responsesThreadRecords(inputs))
 
-	result := model.getLatestPrompt(inputs)
-	assert.Equal(t, "User: Latest prompt", result)
-
-	// Test with no prompts
-	noPromptInputs := []Record{
+	// Without a response, the latest prompt is sent
+	noResponseInputs := []Record{
 		{Source: SystemPrompt, Content: "System message"},
-		{Source: ModelResp, Content: "Response"},
+		{Source: Prompt, Content: "Earlier prompt"},
+		{Source: Prompt, Content: "Latest prompt"},
 	}
+	assert.Equal(t, noResponseInputs[2:], responsesThreadRecords(noResponseInputs))
 
-	result = model.getLatestPrompt(noPromptInputs)
-	assert.Equal(t, "", result)
+	assert.Empty(t, responsesThreadRecords([]Record{{Source: SystemPrompt, Content: "System message"}}))
 }
 
-func TestExtractLatestPromptFromHistory(t *testing.T) {
-	model := &OpenAIResponsesModel{}
+func TestResponsesThreadedToolLoop(t *testing.T) {
+	var bodies []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		n := len(bodies)
+
+		output := `[{"type": "message", "id": "msg_1", "role": "assistant", "status": "completed",
+			"content": [{"type": "output_text", "text": "done", "annotations": []}]}]`
+		if n == 1 || n == 3 {
+			output = fmt.Sprintf(`[{"type": "function_call", "id": "fc_%d", "call_id": "call_%d",
+				"name": "echo", "arguments": "{}", "status": "completed"}]`, n, n)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, fmt.Sprintf(`{"id": "resp_%d", "object": "response", "created_at": 1,
+			"status": "completed", "model": "gpt-4o", "output": %s,
+			"usage": {"input_tokens": 5, "output_tokens": 5, "total_tokens": 10}}`, n, output))
+	}))
+	defer srv.Close()
+
+	client := openai.NewClient(option.WithAPIKey("test"), option.WithBaseURL(srv.URL), option.WithMaxRetries(0))
+	m := &OpenAIResponsesModel{client: &client, model: ResponsesModel4o}
+
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+	cw, err := NewContextWindow(db, m, "threaded")
+	assert.NoError(t, err)
+	assert.NoError(t, cw.SetServerSideThreading(true))
+	assert.NoError(t, cw.RegisterTool("echo", NewTool("echo", "echoes"), ToolRunnerFunc(
+		func(ctx context.Context, args json.RawMessage) (string, error) {
+			return "echoed", nil
+		})))
+
+	assert.NoError(t, cw.AddPrompt("first\nprompt"))
+	_, err = cw.CallModel(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, cw.AddPrompt("second"))
+	_, err = cw.CallModel(context.Background())
+	assert.NoError(t, err)
 
-	// Test with multiple user prompts in history
-	fullHistory := `System: You are a helpful assistant
-User: Hello
-Assistant: Hi there
-User: How are you?
-Assistant: I'm doing well
-User: What's the weather?`
-
-	result := model.extractLatestPromptFromHistory(fullHistory)
-	assert.Equal(t, "User: What's the weather?", result)
-
-	// Test with no user prompts
-	noUserHistory := `System: You are a helpful assistant
-Assistant: Hi there
-Assistant: I'm doing well`
-
-	result = model.extractLatestPromptFromHistory(noUserHistory)
-	assert.Equal(t, "", result)
-
-	// Test with user prompt mixed with tool calls
-	complexHistory := `User: Hello
-Assistant: Hi
-Tool Call: get_weather({})
-Tool Output: Sunny
-User: Thanks`
-
-	result = model.extractLatestPromptFromHistory(complexHistory)
-	assert.Equal(t, "User: Thanks", result)
+	assert.Len(t, bodies, 4)
+
+	// The tool round continues the thread with just the function output
+	assert.Nil(t, bodies[0]["previous_response_id"])
+	assert.Equal(t, "resp_1", bodies[1]["previous_response_id"])
+	assert.Equal(t, []any{map[string]any{
+		"type": "function_call_output", "call_id": "call_1", "output": "echoed",
+	}}, bodies[1]["input"])
+
+	// The next turn threads onto the last response, tool calls and all
+	assert.Equal(t, "resp_2", bodies[2]["previous_response_id"])
+	assert.Equal(t, []any{map[string]any{
+		"role": "user", "content": "second",
+	}}, bodies[2]["input"])
+	assert.Equal(t, "resp_3", bodies[3]["previous_response_id"])
 }
@@ -0,0 +1,182 @@
+package contextwindow
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go/v2"
+)
+
+// RetryPolicy controls how model adapters retry failed requests, including
+// the continuations sent after tool calls, so that a rate limit in the
+// middle of a tool loop doesn't throw away the tool calls already made.
+//
+// With a policy set, the SDKs' own retries are turned off. Each attempt is
+// reported to [ModelMiddleware] separately; see ModelRequest.Attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts per request, including
+	// the first. Values below 2 disable retries.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry; it doubles on
+	// each retry after that, up to MaxBackoff (if set).
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Jitter randomizes each wait by up to this fraction of it (0.2 means
+	// ±20%).
+	Jitter float64
+
+	// Retryable decides whether an error is worth retrying. The default
+	// retries rate limits (429), overload (529), other 5xx and 408/409
+	// responses, and network errors.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy returns a policy suitable for most interactive uses:
+// 4 attempts, starting at 1s and capped at 30s, with 20% jitter.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Jitter:         0.2,
+	}
+}
+
+// RetryCapable is an optional interface for models that retry failed
+// requests; [ContextWindow.SetRetryPolicy] passes its policy along to them.
+type RetryCapable interface {
+	SetRetryPolicy(policy *RetryPolicy)
+}
+
+// SetRetryPolicy sets the retry policy of the model, if it supports one;
+// pass nil to go back to the SDK defaults.
+func (cw *ContextWindow) SetRetryPolicy(policy *RetryPolicy) {
+	if retryCapable, ok := cw.model.(RetryCapable); ok {
+		retryCapable.SetRetryPolicy(policy)
+	}
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var interrupted *streamInterruptedError
+	if errors.As(err, &interrupted) {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return defaultRetryable(err)
+}
+
+func defaultRetryable(err error) bool {
+	if status, _ := apiErrorResponse(err); status != 0 {
+		return status == http.StatusRequestTimeout ||
+			status == http.StatusConflict ||
+			status == http.StatusTooManyRequests ||
+			status >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// backoff returns how long to wait before retry n (starting at 0), honoring
+// a Retry-After header on err if there is one.
+func (p *RetryPolicy) backoff(n int, err error) time.Duration {
+	if _, header := apiErrorResponse(err); header != nil {
+		if d, ok := retryAfter(header); ok {
+			return d
+		}
+	}
+
+	d := p.InitialBackoff << n
+	if p.MaxBackoff > 0 && (d > p.MaxBackoff || d <= 0) {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// retryAfter parses the Retry-After header (seconds or an HTTP date) and
+// OpenAI's retry-after-ms.
+func retryAfter(header http.Header) (time.Duration, bool) {
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	v := header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs >= 0 {
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// apiErrorResponse returns the HTTP status and headers of a provider API
+// error, or zero and nil if err isn't one.
+func apiErrorResponse(err error) (int, http.Header) {
+	var aerr *anthropic.Error
+	if errors.As(err, &aerr) {
+		return aerr.StatusCode, responseHeader(aerr.Response)
+	}
+	var oerr *openai.Error
+	if errors.As(err, &oerr) {
+		return oerr.StatusCode, responseHeader(oerr.Response)
+	}
+	return 0, nil
+}
+
+func responseHeader(resp *http.Response) http.Header {
+	if resp == nil {
+		return nil
+	}
+	return resp.Header
+}
+
+// streamInterruptedError marks a streamed request that failed after output
+// was already delivered; retrying it would repeat that output.
+type streamInterruptedError struct {
+	err error
+}
+
+func (e *streamInterruptedError) Error() string { return e.err.Error() }
+func (e *streamInterruptedError) Unwrap() error { return e.err }
+
+// sendModelRequest makes a model request under middleware, retrying it as
+// policy allows.
+func sendModelRequest[T any](
+	ctx context.Context,
+	policy *RetryPolicy,
+	middleware []Middleware,
+	req ModelRequest,
+	send func(ctx context.Context) (T, ModelResponse, error),
+) (T, error) {
+	for attempt := 0; ; attempt++ {
+		req.Attempt = attempt
+		resp, err := observeModelRequest(ctx, middleware, req, send)
+		if err == nil || policy == nil || attempt+1 >= policy.MaxAttempts || !policy.retryable(err) {
+			return resp, err
+		}
+
+		select {
+		case <-time.After(policy.backoff(attempt, err)):
+		case <-ctx.Done():
+			return resp, err
+		}
+	}
+}
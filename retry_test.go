@@ -0,0 +1,118 @@
+package contextwindow
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
+	"github.com/openai/openai-go/v2/shared"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyRetriesToolContinuation(t *testing.T) {
+	var n int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		switch n {
+		case 1:
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, chatToolCallReply(n))
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, `{"error": {"message": "slow down"}}`, http.StatusTooManyRequests)
+		case 3:
+			http.Error(w, `{"error": {"message": "overloaded"}}`, 529)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{
+				"id": "chatcmpl-done", "object": "chat.completion", "created": 1, "model": "gpt-4o",
+				"choices": [{"index": 0, "finish_reason": "stop",
+					"message": {"role": "assistant", "content": "done"}}],
+				"usage": {"prompt_tokens": 5, "completion_tokens": 5, "total_tokens": 10}
+			}`)
+		}
+	}))
+	defer srv.Close()
+
+	client := openai.NewClient(option.WithAPIKey("test"), option.WithBaseURL(srv.URL))
+	m := &OpenAIModel{client: &client, model: shared.ChatModelGPT4o}
+
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err := NewContextWindow(db, m, "retry")
+	assert.NoError(t, err)
+
+	var toolRuns int
+	err = cw.RegisterTool("echo", NewTool("echo", "echoes"), ToolRunnerFunc(
+		func(ctx context.Context, args json.RawMessage) (string, error) {
+			toolRuns++
+			return "echoed", nil
+		}))
+	assert.NoError(t, err)
+
+	mw := &recordingModelMiddleware{}
+	cw.AddMiddleware(mw)
+	cw.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+	assert.NoError(t, cw.AddPrompt("hi"))
+
+	resp, err := cw.CallModel(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "done", resp)
+	assert.Equal(t, 1, toolRuns)
+	assert.Equal(t, 4, n)
+
+	assert.Len(t, mw.requests, 4)
+	assert.Equal(t, 1, mw.requests[3].Round)
+	assert.Equal(t, 2, mw.requests[3].Attempt)
+	assert.Len(t, mw.errs, 2)
+}
+
+func TestRetryPolicyGivesUp(t *testing.T) {
+	var n int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		http.Error(w, `{"error": {"message": "bad"}}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	client := openai.NewClient(option.WithAPIKey("test"), option.WithBaseURL(srv.URL))
+	m := &OpenAIModel{client: &client, model: shared.ChatModelGPT4o}
+	m.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+	_, _, err := m.Call(context.Background(), []Record{{Source: Prompt, Content: "hi"}})
+	assert.Error(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, p.backoff(0, nil))
+	assert.Equal(t, 4*time.Second, p.backoff(2, nil))
+	assert.Equal(t, 5*time.Second, p.backoff(3, nil))
+
+	p.Jitter = 0.5
+	for range 10 {
+		d := p.backoff(1, nil)
+		assert.GreaterOrEqual(t, d, time.Second)
+		assert.LessOrEqual(t, d, 3*time.Second)
+	}
+
+	d, ok := retryAfter(http.Header{"Retry-After": []string{"7"}})
+	assert.True(t, ok)
+	assert.Equal(t, 7*time.Second, d)
+
+	d, ok = retryAfter(http.Header{"Retry-After-Ms": []string{"250"}})
+	assert.True(t, ok)
+	assert.Equal(t, 250*time.Millisecond, d)
+
+	_, ok = retryAfter(http.Header{})
+	assert.False(t, ok)
+}
@@ -0,0 +1,167 @@
+package contextwindow
+
+import (
+	"fmt"
+)
+
+// rewind is what Redo needs to undo a rewind.
+type rewind struct {
+	ids            []int64 // records the rewind made not live
+	lastResponseID *string // the context's last response ID before it
+	lastRecordID   int64   // the newest record in the context at the time
+}
+
+// Rewind undoes the last turns of the current context: it rewinds to just
+// before the prompt that started the turns-th last turn. See
+// [ContextWindow.RewindTo].
+func (cw *ContextWindow) Rewind(turns int) error {
+	if turns < 1 {
+		return fmt.Errorf("rewind: turns must be positive, got %d", turns)
+	}
+	contextID, err := cw.contextIDByName(cw.currentContext)
+	if err != nil {
+		return fmt.Errorf("rewind: %w", err)
+	}
+	recs, err := cw.store.ListLiveRecords(contextID)
+	if err != nil {
+		return fmt.Errorf("rewind: %w", err)
+	}
+
+	var prompts []Record
+	for _, r := range recs {
+		if r.Source == Prompt {
+			prompts = append(prompts, r)
+		}
+	}
+	if turns > len(prompts) {
+		return fmt.Errorf("rewind: %d turns requested, context has %d", turns, len(prompts))
+	}
+
+	if err := cw.rewindAt(contextID, prompts[len(prompts)-turns].ID, false); err != nil {
+		return fmt.Errorf("rewind: %w", err)
+	}
+	return nil
+}
+
+// RewindTo rewinds the current context to just after recordID: later
+// records are marked not live (they're kept, for auditing), and the
+// context's last response ID is restored to the response recordID was
+// answered in or followed, so server-side threading continues from there.
+// System prompts stay live.
+//
+// A rewind can be undone with [ContextWindow.Redo] until something new is
+// added to the context.
+func (cw *ContextWindow) RewindTo(recordID int64) error {
+	contextID, err := cw.contextIDByName(cw.currentContext)
+	if err != nil {
+		return fmt.Errorf("rewind to %d: %w", recordID, err)
+	}
+	if err := cw.rewindAt(contextID, recordID, true); err != nil {
+		return fmt.Errorf("rewind to %d: %w", recordID, err)
+	}
+	return nil
+}
+
+// rewindAt marks a context's live records from recordID on (or after it, if
+// keep is set) not live, restores its last response ID, and remembers how to
+// redo it. "After" means later in timestamp order, not a higher ID: a
+// summary is newer than the records it follows.
+func (cw *ContextWindow) rewindAt(contextID string, recordID int64, keep bool) error {
+	c, err := cw.store.GetContext(contextID)
+	if err != nil {
+		return err
+	}
+	recs, err := cw.store.ListRecords(contextID)
+	if err != nil {
+		return err
+	}
+
+	cut := -1
+	for i, rec := range recs {
+		if rec.ID == recordID {
+			cut = i
+			break
+		}
+	}
+	if cut < 0 {
+		return fmt.Errorf("record not in context %s", c.Name)
+	}
+	if keep {
+		cut++
+	}
+
+	r := rewind{lastResponseID: c.LastResponseID}
+	var responseID *string
+	for i, rec := range recs {
+		r.lastRecordID = max(r.lastRecordID, rec.ID)
+		switch {
+		case i < cut:
+			// Responses an earlier rewind abandoned aren't threaded from
+			if rec.Live && rec.Source == ModelResp && rec.ResponseID != nil {
+				responseID = rec.ResponseID
+			}
+		case rec.Live && rec.Source != SystemPrompt:
+			r.ids = append(r.ids, rec.ID)
+		}
+	}
+	if len(r.ids) == 0 {
+		return nil
+	}
+
+	if err := cw.store.SetRecordsLive(r.ids, false); err != nil {
+		return err
+	}
+	if err := cw.setLastResponseID(contextID, responseID); err != nil {
+		return err
+	}
+
+	if cw.rewinds == nil {
+		cw.rewinds = make(map[string][]rewind)
+	}
+	cw.rewinds[contextID] = append(cw.rewinds[contextID], r)
+	return nil
+}
+
+// Redo undoes the most recent rewind of the current context, making the
+// records it rewound live again. It fails if records have been added to the
+// context since, which also discards the context's other rewinds.
+func (cw *ContextWindow) Redo() error {
+	contextID, err := cw.contextIDByName(cw.currentContext)
+	if err != nil {
+		return fmt.Errorf("redo: %w", err)
+	}
+	stack := cw.rewinds[contextID]
+	if len(stack) == 0 {
+		return fmt.Errorf("redo: nothing to redo")
+	}
+	r := stack[len(stack)-1]
+	cw.rewinds[contextID] = stack[:len(stack)-1]
+
+	recs, err := cw.store.ListRecords(contextID)
+	if err != nil {
+		return fmt.Errorf("redo: %w", err)
+	}
+	for _, rec := range recs {
+		if rec.ID > r.lastRecordID {
+			delete(cw.rewinds, contextID)
+			return fmt.Errorf("redo: records were added since the rewind")
+		}
+	}
+
+	if err := cw.store.SetRecordsLive(r.ids, true); err != nil {
+		return fmt.Errorf("redo: %w", err)
+	}
+	if err := cw.setLastResponseID(contextID, r.lastResponseID); err != nil {
+		return fmt.Errorf("redo: %w", err)
+	}
+	return nil
+}
+
+// setLastResponseID sets or, if responseID is nil, clears a context's last
+// response ID.
+func (cw *ContextWindow) setLastResponseID(contextID string, responseID *string) error {
+	if responseID == nil {
+		return cw.store.ClearLastResponseID(contextID)
+	}
+	return cw.store.UpdateLastResponseID(contextID, *responseID)
+}
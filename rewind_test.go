@@ -0,0 +1,156 @@
+package contextwindow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewind(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		m := &dummyModel{}
+		cw, err := NewContextWindowWithStore(s, m, "main")
+		assert.NoError(t, err)
+		assert.NoError(t, cw.SetSystemPrompt("be brief"))
+
+		turn := func(prompt, answer, responseID string) {
+			assert.NoError(t, cw.AddPrompt(prompt))
+			m.events = []Record{
+				{Source: ToolCall, Content: "lookup()", Live: true},
+				{Source: ToolOutput, Content: "found", Live: true},
+				{Source: ModelResp, Content: answer, Live: true, ResponseID: &responseID},
+			}
+			_, err := cw.CallModel(context.Background())
+			assert.NoError(t, err)
+		}
+		lastResponseID := func() *string {
+			c, err := cw.GetContext("main")
+			assert.NoError(t, err)
+			return c.LastResponseID
+		}
+		contents := func() []string {
+			recs, err := cw.LiveRecords()
+			assert.NoError(t, err)
+			var out []string
+			for _, r := range recs {
+				out = append(out, r.Content)
+			}
+			return out
+		}
+
+		turn("first", "one", "resp_1")
+		turn("second", "two", "resp_2")
+		turn("third", "three", "resp_3")
+		// dummyModel doesn't thread, so say it did
+		assert.NoError(t, cw.store.UpdateLastResponseID(mustContextID(t, cw, "main"), "resp_3"))
+		all := contents()
+		assert.Len(t, all, 13)
+
+		assert.NoError(t, cw.Rewind(2))
+		assert.Equal(t, []string{"be brief", "first", "lookup()", "found", "one"}, contents())
+		if assert.NotNil(t, lastResponseID()) {
+			assert.Equal(t, "resp_1", *lastResponseID())
+		}
+
+		// Rewound records are kept
+		recs, err := cw.store.ListRecords(mustContextID(t, cw, "main"))
+		assert.NoError(t, err)
+		assert.Len(t, recs, 13)
+
+		assert.NoError(t, cw.Redo())
+		assert.Equal(t, all, contents())
+		if assert.NotNil(t, lastResponseID()) {
+			assert.Equal(t, "resp_3", *lastResponseID())
+		}
+		assert.Error(t, cw.Redo())
+
+		// Back to before any model response
+		live, err := cw.LiveRecords()
+		assert.NoError(t, err)
+		assert.NoError(t, cw.RewindTo(live[1].ID))
+		assert.Equal(t, []string{"be brief", "first"}, contents())
+		assert.Nil(t, lastResponseID())
+
+		// Redo is gone once the context moves on
+		turn("first again", "uno", "resp_4")
+		assert.Error(t, cw.Redo())
+		assert.Len(t, contents(), 6)
+
+		assert.Error(t, cw.Rewind(0))
+		assert.Error(t, cw.Rewind(3))
+		assert.Error(t, cw.RewindTo(9999))
+	})
+}
+
+func TestRewindToAfterRewind(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		m := &dummyModel{}
+		cw, err := NewContextWindowWithStore(s, m, "main")
+		assert.NoError(t, err)
+		turn := func(prompt, responseID string) {
+			assert.NoError(t, cw.AddPrompt(prompt))
+			m.events = []Record{{Source: ModelResp, Content: prompt + " answer", Live: true, ResponseID: &responseID}}
+			_, err := cw.CallModel(context.Background())
+			assert.NoError(t, err)
+		}
+		lastResponseID := func() *string {
+			c, err := cw.GetContext("main")
+			assert.NoError(t, err)
+			return c.LastResponseID
+		}
+
+		turn("a", "resp_a")
+		assert.NoError(t, cw.Rewind(1))
+		turn("b", "resp_b")
+		turn("c", "resp_c")
+
+		live, err := cw.LiveRecords()
+		assert.NoError(t, err)
+		assert.NoError(t, cw.RewindTo(live[1].ID))
+		if assert.NotNil(t, lastResponseID()) {
+			assert.Equal(t, "resp_b", *lastResponseID())
+		}
+		assert.NoError(t, cw.RewindTo(live[0].ID))
+		assert.Nil(t, lastResponseID())
+	})
+}
+
+func TestRewindAfterSummary(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		cw, err := NewContextWindowWithStore(s, &dummyModel{}, "main")
+		assert.NoError(t, err)
+		cw.SetSummarizer(&mockSummarizer{summaryText: "turns one and two"})
+
+		for _, prompt := range []string{"one", "two", "three"} {
+			assert.NoError(t, cw.AddPrompt(prompt))
+		}
+		result, err := cw.SummarizeLiveContextWithOpts(context.Background(), SummarizeOpts{KeepTurns: 1})
+		assert.NoError(t, err)
+		assert.NoError(t, cw.AcceptSummary(result))
+
+		assert.NoError(t, cw.Rewind(1))
+		recs, err := cw.LiveRecords()
+		assert.NoError(t, err)
+		if assert.Len(t, recs, 1) {
+			assert.Equal(t, "turns one and two", recs[0].Content)
+		}
+
+		assert.NoError(t, cw.Redo())
+		recs, err = cw.LiveRecords()
+		assert.NoError(t, err)
+		if assert.Len(t, recs, 2) {
+			// Rewinding to the summary keeps it
+			assert.NoError(t, cw.RewindTo(recs[0].ID))
+		}
+		recs, err = cw.LiveRecords()
+		assert.NoError(t, err)
+		assert.Len(t, recs, 1)
+	})
+}
+
+func mustContextID(t *testing.T, cw *ContextWindow, name string) string {
+	id, err := cw.contextIDByName(name)
+	assert.NoError(t, err)
+	return id
+}
@@ -0,0 +1,350 @@
+package contextwindow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SchemaFor derives a JSON Schema for the type of v, which must be a struct
+// or a pointer to one, using the same schema rendering as [ToolBuilder].
+//
+// Fields are named by their json tags; fields tagged omitempty (or
+// omitzero) are optional, and the rest are required. Pointer fields may be
+// null. A description tag becomes the property's description:
+//
+//	type Verdict struct {
+//	    Safe   bool     `json:"safe" description:"whether the command is safe to run"`
+//	    Issues []string `json:"issues,omitempty"`
+//	}
+func SchemaFor(v any) (map[string]any, error) {
+	return schemaFor(v, false)
+}
+
+// StrictSchemaFor is like [SchemaFor], but derives a schema OpenAI's strict
+// mode accepts (see [ResponseSchema.Strict]): every object is closed to
+// other properties and requires all of its properties, and optional fields
+// may be null instead. Types strict mode can't describe, such as maps,
+// interfaces and json.RawMessage, are an error.
+func StrictSchemaFor(v any) (map[string]any, error) {
+	return schemaFor(v, true)
+}
+
+func schemaFor(v any, strict bool) (map[string]any, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("schema for %T: structured output must be a struct", v)
+	}
+
+	p, err := parameterForType(t, nil)
+	if err != nil {
+		return nil, fmt.Errorf("schema for %T: %w", v, err)
+	}
+	schema := parameterSchema(p)
+	if strict {
+		if err := strictSchema(schema, "$"); err != nil {
+			return nil, fmt.Errorf("schema for %T: %w", v, err)
+		}
+	}
+	return schema, nil
+}
+
+// strictSchema makes a schema rendered by parameterSchema follow OpenAI's
+// strict mode rules, in place.
+func strictSchema(schema map[string]any, path string) error {
+	types := schemaStrings(schema["type"])
+	switch {
+	case len(types) == 0:
+		return fmt.Errorf("%s: strict schemas can't accept any value", path)
+	case slices.Contains(types, string(ParameterTypeObject)):
+		props, ok := schema["properties"].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: strict schemas can't have maps", path)
+		}
+		wasRequired := schemaStrings(schema["required"])
+		required := make([]string, 0, len(props))
+		for name, prop := range props {
+			prop := prop.(map[string]any)
+			if err := strictSchema(prop, path+"."+name); err != nil {
+				return err
+			}
+			if !slices.Contains(wasRequired, name) {
+				if t := schemaStrings(prop["type"]); !slices.Contains(t, "null") {
+					prop["type"] = append(slices.Clone(t), "null")
+				}
+			}
+			required = append(required, name)
+		}
+		slices.Sort(required)
+		schema["required"] = required
+		schema["additionalProperties"] = false
+	case slices.Contains(types, string(ParameterTypeArray)):
+		if items, ok := schema["items"].(map[string]any); ok {
+			return strictSchema(items, path+"[]")
+		}
+	}
+	return nil
+}
+
+var (
+	timeType = reflect.TypeFor[time.Time]()
+	rawType  = reflect.TypeFor[json.RawMessage]()
+)
+
+// parameterForType describes a Go type as a Parameter. seen holds the
+// structs being described, to reject recursive types.
+func parameterForType(t reflect.Type, seen []reflect.Type) (*Parameter, error) {
+	if t.Kind() == reflect.Pointer {
+		p, err := parameterForType(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		p.Nullable = true
+		return p, nil
+	}
+
+	switch {
+	case t == timeType:
+		return &Parameter{Type: ParameterTypeString, Description: "RFC 3339 timestamp"}, nil
+	case t == rawType:
+		return &Parameter{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Parameter{Type: ParameterTypeString}, nil
+	case reflect.Bool:
+		return &Parameter{Type: ParameterTypeBoolean}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Parameter{Type: ParameterTypeInteger}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Parameter{Type: ParameterTypeNumber}, nil
+	case reflect.Interface:
+		return &Parameter{}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map keys must be strings, not %s", t.Key())
+		}
+		return &Parameter{Type: ParameterTypeObject}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Parameter{Type: ParameterTypeString, Description: "base64"}, nil
+		}
+		items, err := parameterForType(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		return &Parameter{Type: ParameterTypeArray, Items: items}, nil
+	case reflect.Struct:
+		if slices.Contains(seen, t) {
+			return nil, fmt.Errorf("recursive type %s", t)
+		}
+		p := &Parameter{Type: ParameterTypeObject, Properties: make(map[string]*Parameter)}
+		if err := addStructFields(p, t, append(seen, t)); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// addStructFields adds the JSON-visible fields of a struct to p, flattening
+// embedded structs the way encoding/json does.
+func addStructFields(p *Parameter, t reflect.Type, seen []reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := addStructFields(p, ft, seen); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop, err := parameterForType(f.Type, seen)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		prop.Name = name
+		if d := f.Tag.Get("description"); d != "" {
+			prop.Description = d
+		}
+		prop.Required = !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero")
+		p.Properties[name] = prop
+	}
+	return nil
+}
+
+// ValidateJSON checks data against a JSON Schema. It understands the subset
+// of JSON Schema that structured output uses: type, properties, required,
+// additionalProperties, items and enum.
+func ValidateJSON(schema map[string]any, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if dec.More() {
+		return fmt.Errorf("invalid JSON: trailing data")
+	}
+	return validateValue(schema, v, "$")
+}
+
+func validateValue(schema map[string]any, v any, path string) error {
+	if types := schemaStrings(schema["type"]); len(types) > 0 {
+		if !slices.ContainsFunc(types, func(t string) bool { return jsonTypeMatches(t, v) }) {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonTypeOf(v))
+		}
+	}
+
+	if enum, ok := schema["enum"]; ok {
+		if !slices.ContainsFunc(schemaValues(enum), func(e any) bool { return jsonEqual(e, v) }) {
+			return fmt.Errorf("%s: %v is not one of %v", path, v, enum)
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		props, _ := schema["properties"].(map[string]any)
+		for _, name := range schemaStrings(schema["required"]) {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		for name, val := range v {
+			sub := path + "." + name
+			if ps, ok := props[name].(map[string]any); ok {
+				if err := validateValue(ps, val, sub); err != nil {
+					return err
+				}
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					return fmt.Errorf("%s: unexpected property", sub)
+				}
+			case map[string]any:
+				if err := validateValue(extra, val, sub); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, val := range v {
+				if err := validateValue(items, val, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func jsonTypeMatches(t string, v any) bool {
+	switch t {
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		if _, err := n.Int64(); err == nil {
+			return true
+		}
+		// JSON doesn't distinguish 1.0 from 1
+		f, err := n.Float64()
+		return err == nil && f == math.Trunc(f) && !math.IsInf(f, 0)
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	default:
+		return jsonTypeOf(v) == t
+	}
+}
+
+func jsonTypeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func jsonEqual(a, b any) bool {
+	if n, ok := b.(json.Number); ok {
+		b = n.String()
+		a = fmt.Sprint(a)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// schemaStrings reads a string or list of strings from a schema, whether it
+// was built in Go ([]string) or decoded from JSON ([]any).
+func schemaStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []any:
+		var out []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// schemaValues reads a list of values from a schema.
+func schemaValues(v any) []any {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return nil
+	}
+	out := make([]any, rv.Len())
+	for i := range out {
+		out[i] = rv.Index(i).Interface()
+	}
+	return out
+}
@@ -0,0 +1,389 @@
+package contextwindow
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SearchQuery is a full-text search over record content, across contexts
+// and including records that aren't live, so tools can find what was
+// compacted or dropped.
+type SearchQuery struct {
+	// Query is an SQLite FTS5 query: words, which must all match,
+	// "quoted phrases", prefix* terms, parentheses, and AND, OR and NOT.
+	// A malformed query, or one using other FTS5 syntax such as column
+	// filters, fails with a [SearchQueryError].
+	Query string
+
+	// ContextID limits the search to one context; empty searches all.
+	ContextID string
+	// Sources limits the search to records of these types; empty searches
+	// all.
+	Sources []RecordType
+	// Live limits the search to live or dead records; nil searches both.
+	Live *bool
+	// From and To limit the search to records timestamped in [From, To);
+	// zero values mean no bound.
+	From, To time.Time
+
+	// Limit caps the number of results; zero means 20.
+	Limit int
+}
+
+// SearchResult is a record matching a [SearchQuery].
+type SearchResult struct {
+	Record Record
+	// Snippet is an excerpt of the record's content around the matches,
+	// with matches in **bold**.
+	Snippet string
+	// Rank orders results by relevance (BM25); lower is a better match.
+	Rank float64
+}
+
+const (
+	defaultSearchLimit  = 20
+	searchMatchStart    = "**"
+	searchMatchEnd      = "**"
+	searchEllipsis      = "…"
+	searchSnippetTokens = 16
+)
+
+func (q SearchQuery) limit() int {
+	if q.Limit > 0 {
+		return q.Limit
+	}
+	return defaultSearchLimit
+}
+
+// matches reports whether r passes the query's filters.
+func (q SearchQuery) matches(r Record) bool {
+	switch {
+	case q.ContextID != "" && r.ContextID != q.ContextID:
+		return false
+	case len(q.Sources) > 0 && !slices.Contains(q.Sources, r.Source):
+		return false
+	case q.Live != nil && r.Live != *q.Live:
+		return false
+	case !q.From.IsZero() && r.Timestamp.Before(q.From):
+		return false
+	case !q.To.IsZero() && !r.Timestamp.Before(q.To):
+		return false
+	}
+	return true
+}
+
+// Search runs a full-text search over records. Use
+// [ContextWindow.GetCurrentContextInfo] for the current context's ID.
+func (cw *ContextWindow) Search(q SearchQuery) ([]SearchResult, error) {
+	results, err := cw.store.Search(q)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	return results, nil
+}
+
+// searchWords splits text into lowercase words, the way FTS5's default
+// tokenizer does.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SearchQueryError reports a search query that's malformed or uses FTS5
+// syntax the stores don't support. Both stores check queries before running
+// them.
+type SearchQueryError struct {
+	Query string
+	Err   error
+}
+
+func (e *SearchQueryError) Error() string {
+	return fmt.Sprintf("bad search query %q: %v", e.Query, e.Err)
+}
+
+func (e *SearchQueryError) Unwrap() error {
+	return e.Err
+}
+
+// searchExpr is an FTS5 query parsed by parseSearchQuery, for
+// [MemoryStore]. A leaf is a phrase: words that must appear in order, the
+// last of which may be a prefix ("word*"). Other nodes combine their two
+// children with AND, OR or NOT.
+type searchExpr struct {
+	op          string
+	phrase      []string
+	left, right *searchExpr
+}
+
+// match reports whether words satisfy e, marking the words that matched.
+// Words only matched under the right side of a NOT aren't marked.
+func (e *searchExpr) match(words []string, matched []bool) bool {
+	switch e.op {
+	case "AND":
+		l := e.left.match(words, matched)
+		r := e.right.match(words, matched)
+		return l && r
+	case "OR":
+		l := e.left.match(words, matched)
+		r := e.right.match(words, matched)
+		return l || r
+	case "NOT":
+		return e.left.match(words, matched) && !e.right.match(words, make([]bool, len(words)))
+	}
+
+	found := false
+	for i := 0; i+len(e.phrase) <= len(words); i++ {
+		ok := true
+		for j, term := range e.phrase {
+			if !termMatches(term, words[i+j]) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			found = true
+			for j := range e.phrase {
+				matched[i+j] = true
+			}
+		}
+	}
+	return found
+}
+
+// termMatches reports whether word matches a phrase term.
+func termMatches(term, word string) bool {
+	if prefix, ok := strings.CutSuffix(term, "*"); ok {
+		return strings.HasPrefix(word, prefix)
+	}
+	return term == word
+}
+
+// parseSearchQuery parses the FTS5 query syntax MemoryStore supports:
+// words, "quoted phrases", prefix* terms, parentheses, and the AND, OR and
+// NOT operators, with FTS5's precedence: adjacent phrases bind tightest,
+// then NOT, AND and OR. Column filters and the other FTS5 extensions are
+// rejected.
+func parseSearchQuery(query string) (*searchExpr, error) {
+	p := &searchParser{query: query}
+	if err := p.lex(); err != nil {
+		return nil, &SearchQueryError{Query: query, Err: err}
+	}
+	if len(p.tokens) == 0 {
+		return nil, &SearchQueryError{Query: query, Err: fmt.Errorf("empty query")}
+	}
+	e, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %s", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, &SearchQueryError{Query: query, Err: err}
+	}
+	return e, nil
+}
+
+type searchToken struct {
+	text   string
+	phrase []string // nil for operators and parentheses
+}
+
+type searchParser struct {
+	query  string
+	tokens []searchToken
+	pos    int
+}
+
+// lex splits the query into operators, parentheses and phrases.
+func (p *searchParser) lex() error {
+	q := p.query
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			p.tokens = append(p.tokens, searchToken{text: string(c)})
+			i++
+		case c == '"':
+			end := strings.IndexByte(q[i+1:], '"')
+			if end < 0 {
+				return fmt.Errorf("unterminated string")
+			}
+			text := q[i+1 : i+1+end]
+			i += end + 2
+			prefix := i < len(q) && q[i] == '*'
+			if prefix {
+				i++
+			}
+			if err := p.addPhrase(text, searchWords(text), prefix); err != nil {
+				return err
+			}
+		default:
+			end := strings.IndexAny(q[i:], " \t\n\r()\"")
+			if end < 0 {
+				end = len(q) - i
+			}
+			text := q[i : i+end]
+			i += end
+			if text == "AND" || text == "OR" || text == "NOT" {
+				p.tokens = append(p.tokens, searchToken{text: text})
+				continue
+			}
+			word, prefix := strings.CutSuffix(text, "*")
+			for _, r := range word {
+				if r < 0x80 && r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					return fmt.Errorf("unsupported syntax in %q", text)
+				}
+			}
+			if err := p.addPhrase(text, searchWords(word), prefix); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *searchParser) addPhrase(text string, words []string, prefix bool) error {
+	if len(words) == 0 {
+		return fmt.Errorf("no words in %q", text)
+	}
+	if prefix {
+		words[len(words)-1] += "*"
+	}
+	p.tokens = append(p.tokens, searchToken{text: text, phrase: words})
+	return nil
+}
+
+// next returns the next token's operator or parenthesis, or "" for a
+// phrase or the end of the query.
+func (p *searchParser) next() string {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].phrase != nil {
+		return ""
+	}
+	return p.tokens[p.pos].text
+}
+
+func (p *searchParser) parseOr() (*searchExpr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.next() == "OR" {
+		p.pos++
+		var right *searchExpr
+		if right, err = p.parseAnd(); err == nil {
+			left = &searchExpr{op: "OR", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+// parseAnd parses NOT expressions joined by AND.
+func (p *searchParser) parseAnd() (*searchExpr, error) {
+	left, err := p.parseNot()
+	for err == nil && p.next() == "AND" {
+		p.pos++
+		var right *searchExpr
+		if right, err = p.parseNot(); err == nil {
+			left = &searchExpr{op: "AND", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *searchParser) parseNot() (*searchExpr, error) {
+	left, err := p.parsePrimary()
+	for err == nil && p.next() == "NOT" {
+		p.pos++
+		var right *searchExpr
+		if right, err = p.parsePrimary(); err == nil {
+			left = &searchExpr{op: "NOT", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *searchParser) parsePrimary() (*searchExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of query")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	if tok.phrase != nil {
+		// Adjacent phrases are ANDed, more tightly than any operator
+		e := &searchExpr{phrase: tok.phrase}
+		for p.pos < len(p.tokens) && p.tokens[p.pos].phrase != nil {
+			e = &searchExpr{op: "AND", left: e, right: &searchExpr{phrase: p.tokens[p.pos].phrase}}
+			p.pos++
+		}
+		return e, nil
+	}
+	if tok.text != "(" {
+		return nil, fmt.Errorf("unexpected %s", tok.text)
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.next() != ")" {
+		return nil, fmt.Errorf("missing )")
+	}
+	p.pos++
+	return e, nil
+}
+
+// memorySearch scores content against a query: the number of words that
+// matched, or 0 if the content doesn't satisfy the query. It returns a
+// snippet like FTS5's.
+func memorySearch(content string, expr *searchExpr) (int, string) {
+	fields := strings.Fields(content)
+	var words []string
+	var fieldOf []int
+	for i, f := range fields {
+		for _, w := range searchWords(f) {
+			words = append(words, w)
+			fieldOf = append(fieldOf, i)
+		}
+	}
+	wordMatched := make([]bool, len(words))
+	if !expr.match(words, wordMatched) {
+		return 0, ""
+	}
+
+	matched := make([]bool, len(fields))
+	for i, m := range wordMatched {
+		if m {
+			matched[fieldOf[i]] = true
+		}
+	}
+	hits, first := 0, -1
+	for i, m := range matched {
+		if m {
+			hits++
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+
+	start := max(0, first-searchSnippetTokens/4)
+	end := min(len(fields), start+searchSnippetTokens)
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString(searchEllipsis)
+	}
+	for i := start; i < end; i++ {
+		if i > start {
+			b.WriteByte(' ')
+		}
+		if matched[i] {
+			b.WriteString(searchMatchStart + fields[i] + searchMatchEnd)
+		} else {
+			b.WriteString(fields[i])
+		}
+	}
+	if end < len(fields) {
+		b.WriteString(searchEllipsis)
+	}
+	return hits, b.String()
+}
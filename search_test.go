@@ -0,0 +1,117 @@
+package contextwindow
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		cw, err := NewContextWindowWithStore(s, &dummyModel{}, "ops")
+		assert.NoError(t, err)
+
+		start := time.Now().UTC()
+		assert.NoError(t, cw.AddPrompt("why did the deploy fail?"))
+		assert.NoError(t, cw.AddToolOutputWithID("call_1", "logs", "deploy error: disk full on worker-3"))
+		assert.NoError(t, cw.AddPrompt("deploy error again, and again a deploy error"))
+		assert.NoError(t, cw.SetRecordLiveStateByRange(0, 1, false))
+		assert.NoError(t, cw.SwitchContext("other"))
+		assert.NoError(t, cw.AddPrompt("unrelated deploy question"))
+
+		ops, err := cw.GetContext("ops")
+		assert.NoError(t, err)
+
+		results, err := cw.Search(SearchQuery{Query: "deploy error"})
+		assert.NoError(t, err)
+		if assert.Len(t, results, 2) {
+			assert.Equal(t, "deploy error again, and again a deploy error", results[0].Record.Content)
+			assert.Less(t, results[0].Rank, results[1].Rank)
+			assert.Contains(t, results[1].Snippet, "**deploy**")
+			assert.Equal(t, "logs", results[1].Record.ToolName)
+		}
+
+		results, err = cw.Search(SearchQuery{Query: "deploy", ContextID: ops.ID})
+		assert.NoError(t, err)
+		assert.Len(t, results, 3)
+
+		notLive := false
+		results, err = cw.Search(SearchQuery{Query: "deploy", Live: &notLive})
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+
+		results, err = cw.Search(SearchQuery{Query: "deploy", Sources: []RecordType{ToolOutput}})
+		assert.NoError(t, err)
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, "call_1", results[0].Record.ToolCallID)
+		}
+
+		results, err = cw.Search(SearchQuery{Query: "work*"})
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+
+		results, err = cw.Search(SearchQuery{Query: "deploy", Limit: 1})
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+
+		results, err = cw.Search(SearchQuery{Query: "deploy", From: start.Add(-time.Hour), To: start.Add(-time.Minute)})
+		assert.NoError(t, err)
+		assert.Empty(t, results)
+		results, err = cw.Search(SearchQuery{Query: "deploy", From: start.Add(-time.Minute)})
+		assert.NoError(t, err)
+		assert.Len(t, results, 4)
+
+		for query, want := range map[string]int{
+			"deploy NOT error":              2,
+			"disk OR unrelated":             2,
+			`"deploy error" NOT disk`:       1,
+			`"error deploy"`:                0,
+			"(fail OR question) AND deploy": 2,
+			"deploy AND (disk OR worker)":   1,
+		} {
+			results, err = cw.Search(SearchQuery{Query: query})
+			assert.NoError(t, err, query)
+			assert.Len(t, results, want, query)
+		}
+
+		for _, query := range []string{" ", "deploy OR", `"unterminated`, "(deploy", "NOT deploy", "(fail OR question) deploy", "tool:deploy"} {
+			_, err = cw.Search(SearchQuery{Query: query})
+			var qerr *SearchQueryError
+			assert.True(t, errors.As(err, &qerr), query)
+		}
+
+		// Deleted records leave the index
+		assert.NoError(t, cw.DeleteContext("other"))
+		results, err = cw.Search(SearchQuery{Query: "unrelated"})
+		assert.NoError(t, err)
+		assert.Empty(t, results)
+	})
+}
+
+func TestSearchIndexesExistingRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := NewContextDB(path)
+	assert.NoError(t, err)
+	cw, err := NewContextWindow(db, &dummyModel{}, "old")
+	assert.NoError(t, err)
+	assert.NoError(t, cw.AddPrompt("indexed before the index existed"))
+
+	// As if the database predated the search index
+	_, err = db.Exec(`
+DROP TRIGGER records_fts_insert;
+DROP TRIGGER records_fts_delete;
+DROP TRIGGER records_fts_update;
+DROP TABLE records_fts;`)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	db, err = NewContextDB(path)
+	assert.NoError(t, err)
+	defer db.Close()
+	results, err := SearchRecords(db, SearchQuery{Query: "predated OR existed"})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+}
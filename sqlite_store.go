@@ -0,0 +1,180 @@
+package contextwindow
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SQLiteStore is the SQLite implementation of [Store], built on the
+// database functions in this package. Open the database with NewContextDB.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore wraps a database opened with NewContextDB.
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return &SQLiteStore{db: db}
+}
+
+// DB returns the underlying database.
+func (s *SQLiteStore) DB() *sql.DB {
+	return s.db
+}
+
+func (s *SQLiteStore) CreateContext(name string, useServerSideThreading bool) (Context, error) {
+	return CreateContextWithThreading(s.db, name, useServerSideThreading)
+}
+
+func (s *SQLiteStore) GetContext(contextID string) (Context, error) {
+	return GetContext(s.db, contextID)
+}
+
+func (s *SQLiteStore) GetContextByName(name string) (Context, error) {
+	return GetContextByName(s.db, name)
+}
+
+func (s *SQLiteStore) ListContexts() ([]Context, error) {
+	return ListContexts(s.db)
+}
+
+func (s *SQLiteStore) DeleteContext(contextID string) error {
+	return DeleteContext(s.db, contextID)
+}
+
+func (s *SQLiteStore) CloneContext(sourceName, destName string) error {
+	return CloneContext(s.db, sourceName, destName)
+}
+
+func (s *SQLiteStore) SetServerSideThreading(contextID string, enabled bool) error {
+	return SetContextServerSideThreading(s.db, contextID, enabled)
+}
+
+func (s *SQLiteStore) UpdateLastResponseID(contextID, responseID string) error {
+	return UpdateContextLastResponseID(s.db, contextID, responseID)
+}
+
+func (s *SQLiteStore) ClearLastResponseID(contextID string) error {
+	return ClearContextLastResponseID(s.db, contextID)
+}
+
+func (s *SQLiteStore) SetContextTokenizer(contextID, tokenizer string) error {
+	return SetContextTokenizer(s.db, contextID, tokenizer)
+}
+
+func (s *SQLiteStore) ForkContext(sourceName string, recordID int64, destName string) error {
+	return ForkContext(s.db, sourceName, recordID, destName)
+}
+
+func (s *SQLiteStore) ListChildContexts(contextID string) ([]Context, error) {
+	return ListChildContexts(s.db, contextID)
+}
+
+func (s *SQLiteStore) SetContextGeneration(contextID string, p GenerationParams) error {
+	return SetContextGeneration(s.db, contextID, p)
+}
+
+func (s *SQLiteStore) InsertRecord(rec Record) (Record, error) {
+	return insertRecordRow(s.db, rec)
+}
+
+func (s *SQLiteStore) ListRecords(contextID string) ([]Record, error) {
+	return ListRecordsInContext(s.db, contextID)
+}
+
+func (s *SQLiteStore) ListLiveRecords(contextID string) ([]Record, error) {
+	return ListLiveRecords(s.db, contextID)
+}
+
+func (s *SQLiteStore) Search(q SearchQuery) ([]SearchResult, error) {
+	return SearchRecords(s.db, q)
+}
+
+func (s *SQLiteStore) SetRecordsLive(ids []int64, live bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("set records live: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		_, err = tx.Exec(`UPDATE records SET live = ? WHERE id = ?`, live, id)
+		if err != nil {
+			return fmt.Errorf("set record %d live state: %w", id, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) SetRecordTokens(tokens map[int64]int) error {
+	return SetRecordTokens(s.db, tokens)
+}
+
+func (s *SQLiteStore) ReplaceRecords(kill []int64, add []Record) ([]Record, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, id := range kill {
+		if err := markRecordNotAlive(tx, id); err != nil {
+			return nil, fmt.Errorf("mark record %d not alive: %w", id, err)
+		}
+	}
+
+	var added []Record
+	for _, rec := range add {
+		r, err := insertRecordRow(tx, rec)
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, r)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return added, nil
+}
+
+func (s *SQLiteStore) ContextStats(contextID string) (ContextStats, error) {
+	return GetContextStats(s.db, contextID)
+}
+
+func (s *SQLiteStore) Spend(contextID string, from, to time.Time) (Spend, error) {
+	return GetSpend(s.db, contextID, from, to)
+}
+
+func (s *SQLiteStore) InsertModelCall(call ModelCall) (ModelCall, error) {
+	return InsertModelCall(s.db, call)
+}
+
+func (s *SQLiteStore) ListModelCalls(contextID string, from, to time.Time) ([]ModelCall, error) {
+	return ListModelCalls(s.db, contextID, from, to)
+}
+
+func (s *SQLiteStore) AddContextTool(contextID, toolName string) (ContextTool, error) {
+	return AddContextTool(s.db, contextID, toolName)
+}
+
+func (s *SQLiteStore) ListContextTools(contextID string) ([]ContextTool, error) {
+	return ListContextTools(s.db, contextID)
+}
+
+func (s *SQLiteStore) RemoveContextTool(contextID, toolName string) error {
+	return RemoveContextTool(s.db, contextID, toolName)
+}
+
+func (s *SQLiteStore) HasContextTool(contextID, toolName string) (bool, error) {
+	return HasContextTool(s.db, contextID, toolName)
+}
+
+// Close closes the underlying database.
+func (s *SQLiteStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,15 +23,37 @@ const (
 )
 
 // Record is one row in context history.
+//
+// ToolCall and ToolOutput records produced by a model carry the provider's
+// tool call ID and the tool name, linking each output to its call; ToolCall
+// records also carry the raw JSON arguments, and ToolOutput records whether
+// the tool failed. Content keeps a readable "name(args)" rendering of the
+// call.
+//
+// The final ModelResp record of a model call carries the provider-reported
+// Usage for the whole call, tool rounds included, along with the Model that
+// made it and the call's Cost in US dollars.
+//
+// Prompt records may carry Attachments: images and documents sent to the
+// model along with Content.
 type Record struct {
-	ID         int64      `json:"id"`
-	Timestamp  time.Time  `json:"timestamp"`
-	Source     RecordType `json:"source"`
-	Content    string     `json:"content"`
-	Live       bool       `json:"live"`
-	EstTokens  int        `json:"est_tokens"`
-	ContextID  string     `json:"context_id"`
-	ResponseID *string    `json:"response_id,omitempty"`
+	ID         int64           `json:"id"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Source     RecordType      `json:"source"`
+	Content    string          `json:"content"`
+	Live       bool            `json:"live"`
+	EstTokens  int             `json:"est_tokens"`
+	ContextID  string          `json:"context_id"`
+	ResponseID *string         `json:"response_id,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"`
+	ToolName   string          `json:"tool_name,omitempty"`
+	ToolArgs   json.RawMessage `json:"tool_args,omitempty"`
+	ToolError  bool            `json:"tool_error,omitempty"`
+	Usage      *ModelUsage     `json:"usage,omitempty"`
+	Model      string          `json:"model,omitempty"`
+	Cost       float64         `json:"cost,omitempty"`
+
+	Attachments []Attachment `json:"attachments,omitempty"`
 }
 
 // Context represents a named context window with metadata.
@@ -40,6 +63,15 @@ type Context struct {
 	StartTime              time.Time `json:"start_time"`
 	UseServerSideThreading bool      `json:"use_server_side_threading"`
 	LastResponseID         *string   `json:"last_response_id,omitempty"`
+	// Tokenizer names the Tokenizer the context's records were estimated
+	// with.
+	Tokenizer string `json:"tokenizer,omitempty"`
+	// Generation holds the context's default generation settings.
+	Generation GenerationParams `json:"generation,omitzero"`
+	// ParentID and ForkRecordID record where a forked context came from:
+	// the context it was forked from, and the last record it copied.
+	ParentID     string `json:"parent_id,omitempty"`
+	ForkRecordID int64  `json:"fork_record_id,omitempty"`
 }
 
 // ContextTool represents a tool available in a specific context.
@@ -50,8 +82,13 @@ type ContextTool struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// ContextExportVersion is the version of the [ContextExport] format. Imports
+// reject exports from newer versions.
+const ContextExportVersion = 1
+
 // ContextExport represents a complete context with all its records.
 type ContextExport struct {
+	Version int           `json:"version"`
 	Context Context       `json:"context"`
 	Records []Record      `json:"records"`
 	Tools   []ContextTool `json:"tools"`
@@ -87,6 +124,43 @@ CREATE TABLE IF NOT EXISTS context_tools (
     FOREIGN KEY (context_id) REFERENCES contexts(id) ON DELETE CASCADE,
     UNIQUE(context_id, tool_name)
 );
+
+CREATE TABLE IF NOT EXISTS blobs (
+    hash       TEXT PRIMARY KEY,
+    media_type TEXT NOT NULL,
+    data       BLOB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS record_attachments (
+    record_id INTEGER NOT NULL,
+    position  INTEGER NOT NULL,
+    blob_hash TEXT NOT NULL,
+    name      TEXT NOT NULL,
+    PRIMARY KEY (record_id, position),
+    FOREIGN KEY (record_id) REFERENCES records(id) ON DELETE CASCADE,
+    FOREIGN KEY (blob_hash) REFERENCES blobs(hash)
+);
+
+CREATE TABLE IF NOT EXISTS model_calls (
+    id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+    context_id         TEXT NOT NULL,
+    model              TEXT NOT NULL,
+    started_at         DATETIME NOT NULL,
+    finished_at        DATETIME NOT NULL,
+    latency_ns         INTEGER NOT NULL,
+    requests           INTEGER NOT NULL,
+    tokens_used        INTEGER NOT NULL,
+    input_tokens       INTEGER NOT NULL,
+    output_tokens      INTEGER NOT NULL,
+    cache_read_tokens  INTEGER NOT NULL,
+    cache_write_tokens INTEGER NOT NULL,
+    reasoning_tokens   INTEGER NOT NULL,
+    cost_usd           REAL NOT NULL,
+    stop_reason        TEXT NOT NULL,
+    record_ids         TEXT NOT NULL,
+    error              TEXT NULL,
+    FOREIGN KEY (context_id) REFERENCES contexts(id) ON DELETE CASCADE
+);
 `
 
 	_, err := db.Exec(baseTables)
@@ -105,22 +179,113 @@ CREATE TABLE IF NOT EXISTS context_tools (
 		return fmt.Errorf("add last_response_id column: %w", err)
 	}
 
+	err = addColumnIfNotExists(db, "contexts", "tokenizer", "TEXT NULL")
+	if err != nil {
+		return fmt.Errorf("add tokenizer column: %w", err)
+	}
+
+	err = addColumnIfNotExists(db, "contexts", "generation", "TEXT NULL")
+	if err != nil {
+		return fmt.Errorf("add generation column: %w", err)
+	}
+
+	err = addColumnIfNotExists(db, "contexts", "parent_id", "TEXT NULL")
+	if err != nil {
+		return fmt.Errorf("add parent_id column: %w", err)
+	}
+
+	err = addColumnIfNotExists(db, "contexts", "fork_record_id", "INTEGER NULL")
+	if err != nil {
+		return fmt.Errorf("add fork_record_id column: %w", err)
+	}
+
 	err = addColumnIfNotExists(db, "records", "response_id", "TEXT NULL")
 	if err != nil {
 		return fmt.Errorf("add response_id column: %w", err)
 	}
 
+	err = addColumnIfNotExists(db, "records", "tool_call_id", "TEXT NULL")
+	if err != nil {
+		return fmt.Errorf("add tool_call_id column: %w", err)
+	}
+
+	err = addColumnIfNotExists(db, "records", "tool_name", "TEXT NULL")
+	if err != nil {
+		return fmt.Errorf("add tool_name column: %w", err)
+	}
+
+	err = addColumnIfNotExists(db, "records", "tool_args", "TEXT NULL")
+	if err != nil {
+		return fmt.Errorf("add tool_args column: %w", err)
+	}
+
+	err = addColumnIfNotExists(db, "records", "tool_error", "BOOLEAN NOT NULL DEFAULT 0")
+	if err != nil {
+		return fmt.Errorf("add tool_error column: %w", err)
+	}
+
+	for _, col := range usageColumns {
+		err = addColumnIfNotExists(db, "records", col, "INTEGER NULL")
+		if err != nil {
+			return fmt.Errorf("add %s column: %w", col, err)
+		}
+	}
+
+	err = addColumnIfNotExists(db, "records", "model", "TEXT NULL")
+	if err != nil {
+		return fmt.Errorf("add model column: %w", err)
+	}
+
+	err = addColumnIfNotExists(db, "records", "cost_usd", "REAL NULL")
+	if err != nil {
+		return fmt.Errorf("add cost_usd column: %w", err)
+	}
+
 	// Create indexes
 	const indexes = `
 CREATE INDEX IF NOT EXISTS idx_context_live ON records(context_id, live);
 CREATE INDEX IF NOT EXISTS idx_context_ts ON records(context_id, ts);
 CREATE INDEX IF NOT EXISTS idx_context_tools_context ON context_tools(context_id);
+CREATE INDEX IF NOT EXISTS idx_model_calls_context ON model_calls(context_id, started_at);
+CREATE INDEX IF NOT EXISTS idx_model_calls_started ON model_calls(started_at);
+CREATE INDEX IF NOT EXISTS idx_contexts_parent ON contexts(parent_id);
 `
 	_, err = db.Exec(indexes)
 	if err != nil {
 		return fmt.Errorf("create indexes: %w", err)
 	}
 
+	// Full-text index over record content, kept up to date by triggers.
+	// Databases from before it existed are indexed when it's created.
+	const searchIndex = `
+CREATE VIRTUAL TABLE IF NOT EXISTS records_fts USING fts5(content, content='records', content_rowid='id');
+CREATE TRIGGER IF NOT EXISTS records_fts_insert AFTER INSERT ON records BEGIN
+    INSERT INTO records_fts(rowid, content) VALUES (new.id, new.content);
+END;
+CREATE TRIGGER IF NOT EXISTS records_fts_delete AFTER DELETE ON records BEGIN
+    INSERT INTO records_fts(records_fts, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+CREATE TRIGGER IF NOT EXISTS records_fts_update AFTER UPDATE OF content ON records BEGIN
+    INSERT INTO records_fts(records_fts, rowid, content) VALUES ('delete', old.id, old.content);
+    INSERT INTO records_fts(rowid, content) VALUES (new.id, new.content);
+END;
+`
+	var indexed int
+	err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'records_fts'`).Scan(&indexed)
+	if err != nil {
+		return fmt.Errorf("check search index: %w", err)
+	}
+	_, err = db.Exec(searchIndex)
+	if err != nil {
+		return fmt.Errorf("create search index: %w", err)
+	}
+	if indexed == 0 {
+		_, err = db.Exec(`INSERT INTO records_fts(records_fts) VALUES ('rebuild')`)
+		if err != nil {
+			return fmt.Errorf("build search index: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -172,12 +337,33 @@ func CreateContextWithThreading(db *sql.DB, name string, useServerSideThreading
 	}, nil
 }
 
+// contextColumns are the contexts columns scanContext reads.
+const contextColumns = `id, name, start_time,
+		 COALESCE(use_server_side_threading, 0) as use_server_side_threading,
+		 last_response_id, COALESCE(tokenizer, ''), COALESCE(generation, ''),
+		 COALESCE(parent_id, ''), COALESCE(fork_record_id, 0)`
+
+// scanContext scans a row of contextColumns.
+func scanContext(row interface{ Scan(dest ...any) error }) (Context, error) {
+	var c Context
+	var generation string
+	err := row.Scan(&c.ID, &c.Name, &c.StartTime, &c.UseServerSideThreading, &c.LastResponseID, &c.Tokenizer, &generation,
+		&c.ParentID, &c.ForkRecordID)
+	if err != nil {
+		return Context{}, err
+	}
+	if generation != "" {
+		if err := json.Unmarshal([]byte(generation), &c.Generation); err != nil {
+			return Context{}, fmt.Errorf("decode generation settings: %w", err)
+		}
+	}
+	return c, nil
+}
+
 // ListContexts returns all contexts ordered by start time.
 func ListContexts(db *sql.DB) ([]Context, error) {
 	rows, err := db.Query(
-		`SELECT id, name, start_time, 
-		 COALESCE(use_server_side_threading, 0) as use_server_side_threading,
-		 last_response_id 
+		`SELECT ` + contextColumns + `
 		 FROM contexts ORDER BY start_time DESC`,
 	)
 	if err != nil {
@@ -187,8 +373,8 @@ func ListContexts(db *sql.DB) ([]Context, error) {
 
 	var contexts []Context
 	for rows.Next() {
-		var c Context
-		if err := rows.Scan(&c.ID, &c.Name, &c.StartTime, &c.UseServerSideThreading, &c.LastResponseID); err != nil {
+		c, err := scanContext(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan context: %w", err)
 		}
 		contexts = append(contexts, c)
@@ -201,14 +387,11 @@ func ListContexts(db *sql.DB) ([]Context, error) {
 
 // GetContext retrieves a context by ID.
 func GetContext(db *sql.DB, contextID string) (Context, error) {
-	var c Context
-	err := db.QueryRow(
-		`SELECT id, name, start_time,
-		 COALESCE(use_server_side_threading, 0) as use_server_side_threading,
-		 last_response_id
+	c, err := scanContext(db.QueryRow(
+		`SELECT `+contextColumns+`
 		 FROM contexts WHERE id = ?`,
 		contextID,
-	).Scan(&c.ID, &c.Name, &c.StartTime, &c.UseServerSideThreading, &c.LastResponseID)
+	))
 	if err != nil {
 		return Context{}, fmt.Errorf("get context %s: %w", contextID, err)
 	}
@@ -217,14 +400,11 @@ func GetContext(db *sql.DB, contextID string) (Context, error) {
 
 // GetContextByName retrieves a context by name.
 func GetContextByName(db *sql.DB, name string) (Context, error) {
-	var c Context
-	err := db.QueryRow(
-		`SELECT id, name, start_time,
-		 COALESCE(use_server_side_threading, 0) as use_server_side_threading,
-		 last_response_id
+	c, err := scanContext(db.QueryRow(
+		`SELECT `+contextColumns+`
 		 FROM contexts WHERE name = ?`,
 		name,
-	).Scan(&c.ID, &c.Name, &c.StartTime, &c.UseServerSideThreading, &c.LastResponseID)
+	))
 	if err != nil {
 		return Context{}, fmt.Errorf("get context '%s': %w", name, err)
 	}
@@ -239,11 +419,30 @@ func DeleteContext(db *sql.DB, contextID string) error {
 	}
 	defer tx.Rollback()
 
+	_, err = tx.Exec(
+		`DELETE FROM record_attachments
+		 WHERE record_id IN (SELECT id FROM records WHERE context_id = ?)`,
+		contextID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete context attachments: %w", err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM blobs WHERE hash NOT IN (SELECT blob_hash FROM record_attachments)`)
+	if err != nil {
+		return fmt.Errorf("delete unused blobs: %w", err)
+	}
+
 	_, err = tx.Exec(`DELETE FROM records WHERE context_id = ?`, contextID)
 	if err != nil {
 		return fmt.Errorf("delete context records: %w", err)
 	}
 
+	_, err = tx.Exec(`DELETE FROM model_calls WHERE context_id = ?`, contextID)
+	if err != nil {
+		return fmt.Errorf("delete context model calls: %w", err)
+	}
+
 	_, err = tx.Exec(`DELETE FROM contexts WHERE id = ?`, contextID)
 	if err != nil {
 		return fmt.Errorf("delete context: %w", err)
@@ -279,6 +478,7 @@ func ExportContext(db *sql.DB, contextID string) (ContextExport, error) {
 	}
 
 	return ContextExport{
+		Version: ContextExportVersion,
 		Context: context,
 		Records: records,
 		Tools:   tools,
@@ -312,6 +512,22 @@ func ExportContextJSONByName(db *sql.DB, name string) ([]byte, error) {
 	return json.MarshalIndent(export, "", "  ")
 }
 
+// ImportContext recreates an exported context, under name if it isn't
+// empty. See [ContextWindow.ImportContext].
+func ImportContext(db *sql.DB, export ContextExport, name string) (Context, error) {
+	return importContext(NewSQLiteStore(db), export, name)
+}
+
+// ImportContextJSON recreates a context from JSON produced by
+// [ExportContextJSON], under name if it isn't empty.
+func ImportContextJSON(db *sql.DB, data []byte, name string) (Context, error) {
+	var export ContextExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return Context{}, fmt.Errorf("unmarshal context export: %w", err)
+	}
+	return ImportContext(db, export, name)
+}
+
 // InsertRecord inserts a new record in the specified context.
 func InsertRecord(
 	db *sql.DB,
@@ -332,30 +548,294 @@ func InsertRecordWithResponseID(
 	live bool,
 	responseID *string,
 ) (Record, error) {
-	now := time.Now().UTC()
-	t := tokenCount(content)
-	res, err := db.Exec(
-		`INSERT INTO records (context_id, ts, source, content, live, est_tokens, response_id) 
-		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		contextID, now, int(source), content, live, t, responseID,
+	return insertRecordRow(db, Record{
+		ContextID:  contextID,
+		Source:     source,
+		Content:    content,
+		Live:       live,
+		ResponseID: responseID,
+	})
+}
+
+// InsertToolRecord inserts a ToolCall or ToolOutput record linked to a tool
+// call ID. args is only meaningful for ToolCall records.
+func InsertToolRecord(
+	db *sql.DB,
+	contextID string,
+	source RecordType,
+	content string,
+	toolCallID string,
+	toolName string,
+	args json.RawMessage,
+) (Record, error) {
+	return insertRecordRow(db, Record{
+		ContextID:  contextID,
+		Source:     source,
+		Content:    content,
+		Live:       true,
+		ToolCallID: toolCallID,
+		ToolName:   toolName,
+		ToolArgs:   args,
+	})
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// insertRecordRow inserts r, stamping its ID and token estimate, and its
+// timestamp unless one is already set.
+func insertRecordRow(q execer, r Record) (Record, error) {
+	if r.Timestamp.IsZero() {
+		r.Timestamp = time.Now().UTC()
+	}
+	if r.EstTokens == 0 {
+		r.EstTokens = recordTokens(cl100kTokenizer, r)
+	}
+
+	var usage [5]sql.NullInt64
+	var cost sql.NullFloat64
+	if r.Usage != nil {
+		cost = sql.NullFloat64{Float64: r.Cost, Valid: true}
+		for i, n := range []int{
+			r.Usage.InputTokens,
+			r.Usage.OutputTokens,
+			r.Usage.CacheReadTokens,
+			r.Usage.CacheWriteTokens,
+			r.Usage.ReasoningTokens,
+		} {
+			usage[i] = sql.NullInt64{Int64: int64(n), Valid: true}
+		}
+	}
+
+	res, err := q.Exec(
+		`INSERT INTO records (context_id, ts, source, content, live, est_tokens,
+		 response_id, tool_call_id, tool_name, tool_args, tool_error,
+		 input_tokens, output_tokens, cache_read_tokens, cache_write_tokens, reasoning_tokens,
+		 model, cost_usd)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ContextID,
+		r.Timestamp,
+		int(r.Source),
+		r.Content,
+		r.Live,
+		r.EstTokens,
+		r.ResponseID,
+		nullString(r.ToolCallID),
+		nullString(r.ToolName),
+		nullString(string(r.ToolArgs)),
+		r.ToolError,
+		usage[0], usage[1], usage[2], usage[3], usage[4],
+		nullString(r.Model),
+		cost,
 	)
 	if err != nil {
 		return Record{}, fmt.Errorf("insert record: %w", err)
 	}
-	id, err := res.LastInsertId()
+	r.ID, err = res.LastInsertId()
 	if err != nil {
 		return Record{}, fmt.Errorf("get last insert id: %w", err)
 	}
-	return Record{
-		ID:         id,
-		Timestamp:  now,
-		Source:     source,
-		Content:    content,
-		Live:       live,
-		EstTokens:  t,
-		ContextID:  contextID,
-		ResponseID: responseID,
-	}, nil
+
+	r.Attachments, err = insertAttachments(q, r.ID, r.Attachments)
+	if err != nil {
+		return Record{}, err
+	}
+	return r, nil
+}
+
+// insertAttachments stores a record's attachments, their data in the
+// content-addressed blobs table, and returns them with their hashes set.
+func insertAttachments(q execer, recordID int64, attachments []Attachment) ([]Attachment, error) {
+	if len(attachments) == 0 {
+		return attachments, nil
+	}
+
+	stored := make([]Attachment, len(attachments))
+	for i, a := range attachments {
+		a.Hash = blobHash(a.Data)
+		_, err := q.Exec(
+			`INSERT OR IGNORE INTO blobs (hash, media_type, data) VALUES (?, ?, ?)`,
+			a.Hash, a.MediaType, a.Data,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("insert blob: %w", err)
+		}
+
+		_, err = q.Exec(
+			`INSERT INTO record_attachments (record_id, position, blob_hash, name)
+			 VALUES (?, ?, ?, ?)`,
+			recordID, i, a.Hash, a.Name,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("insert attachment: %w", err)
+		}
+		stored[i] = a
+	}
+	return stored, nil
+}
+
+// listAttachmentsWhere loads the attachments of the records matching a
+// records WHERE clause, keyed by record ID.
+func listAttachmentsWhere(db *sql.DB, whereClause string, args ...interface{}) (map[int64][]Attachment, error) {
+	query := fmt.Sprintf(
+		`SELECT ra.record_id, ra.name, b.hash, b.media_type, b.data
+		 FROM record_attachments ra JOIN blobs b ON b.hash = ra.blob_hash
+		 WHERE ra.record_id IN (SELECT id FROM records WHERE %s)
+		 ORDER BY ra.record_id, ra.position`,
+		whereClause,
+	)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query attachments: %w", err)
+	}
+	defer rows.Close()
+
+	attachments := make(map[int64][]Attachment)
+	for rows.Next() {
+		var recordID int64
+		var a Attachment
+		if err := rows.Scan(&recordID, &a.Name, &a.Hash, &a.MediaType, &a.Data); err != nil {
+			return nil, fmt.Errorf("scan attachment: %w", err)
+		}
+		attachments[recordID] = append(attachments[recordID], a)
+	}
+	return attachments, rows.Err()
+}
+
+// GetSpend sums the model call ledger entries started between from
+// (inclusive) and to (exclusive), in one context or, if contextID is empty,
+// in all of them. A zero to means no upper bound.
+func GetSpend(db *sql.DB, contextID string, from, to time.Time) (Spend, error) {
+	query := `
+		SELECT
+			COUNT(*),
+			COALESCE(SUM(requests), 0),
+			COALESCE(SUM(cost_usd), 0),
+			COALESCE(SUM(input_tokens), 0),
+			COALESCE(SUM(output_tokens), 0),
+			COALESCE(SUM(cache_read_tokens), 0),
+			COALESCE(SUM(cache_write_tokens), 0),
+			COALESCE(SUM(reasoning_tokens), 0)
+		FROM model_calls
+		WHERE started_at >= ?`
+	args := []any{from.UTC()}
+	if !to.IsZero() {
+		query += ` AND started_at < ?`
+		args = append(args, to.UTC())
+	}
+	if contextID != "" {
+		query += ` AND context_id = ?`
+		args = append(args, contextID)
+	}
+
+	var spend Spend
+	err := db.QueryRow(query, args...).Scan(
+		&spend.Calls,
+		&spend.Requests,
+		&spend.Cost,
+		&spend.Usage.InputTokens,
+		&spend.Usage.OutputTokens,
+		&spend.Usage.CacheReadTokens,
+		&spend.Usage.CacheWriteTokens,
+		&spend.Usage.ReasoningTokens,
+	)
+	if err != nil {
+		return Spend{}, fmt.Errorf("get spend: %w", err)
+	}
+	return spend, nil
+}
+
+// SearchRecords runs a full-text search over record content. See
+// [SearchQuery]. The query is checked before it's run, so that it fails
+// with a [SearchQueryError] if it's malformed, as it does with MemoryStore.
+func SearchRecords(db *sql.DB, q SearchQuery) ([]SearchResult, error) {
+	if _, err := parseSearchQuery(q.Query); err != nil {
+		return nil, fmt.Errorf("search records: %w", err)
+	}
+
+	query := `
+		SELECT records_fts.rowid,
+			snippet(records_fts, 0, ?, ?, ?, ?),
+			bm25(records_fts)
+		FROM records_fts JOIN records ON records.id = records_fts.rowid
+		WHERE records_fts MATCH ?`
+	args := []any{searchMatchStart, searchMatchEnd, searchEllipsis, searchSnippetTokens, q.Query}
+	if q.ContextID != "" {
+		query += ` AND records.context_id = ?`
+		args = append(args, q.ContextID)
+	}
+	if len(q.Sources) > 0 {
+		query += ` AND records.source IN (?` + strings.Repeat(`, ?`, len(q.Sources)-1) + `)`
+		for _, src := range q.Sources {
+			args = append(args, int(src))
+		}
+	}
+	if q.Live != nil {
+		query += ` AND records.live = ?`
+		args = append(args, *q.Live)
+	}
+	if !q.From.IsZero() {
+		query += ` AND records.ts >= ?`
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		query += ` AND records.ts < ?`
+		args = append(args, q.To.UTC())
+	}
+	query += ` ORDER BY bm25(records_fts) LIMIT ?`
+	args = append(args, q.limit())
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search records: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	var ids []any
+	for rows.Next() {
+		var res SearchResult
+		if err := rows.Scan(&res.Record.ID, &res.Snippet, &res.Rank); err != nil {
+			return nil, fmt.Errorf("scan search result: %w", err)
+		}
+		results = append(results, res)
+		ids = append(ids, res.Record.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("search records: %w", err)
+	}
+	rows.Close()
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	recs, err := listRecordsWhere(db, `id IN (?`+strings.Repeat(`, ?`, len(ids)-1)+`)`, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("search records: %w", err)
+	}
+	byID := make(map[int64]Record, len(recs))
+	for _, r := range recs {
+		byID[r.ID] = r
+	}
+	for i := range results {
+		results[i].Record = byID[results[i].Record.ID]
+	}
+	return results, nil
+}
+
+// usageColumns are the records columns holding a ModelUsage, in field order.
+var usageColumns = []string{
+	"input_tokens",
+	"output_tokens",
+	"cache_read_tokens",
+	"cache_write_tokens",
+	"reasoning_tokens",
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
 }
 
 // ListLiveRecords returns all live records in a context in timestamp order.
@@ -370,8 +850,11 @@ func ListRecordsInContext(db *sql.DB, contextID string) ([]Record, error) {
 
 func listRecordsWhere(db *sql.DB, whereClause string, args ...interface{}) ([]Record, error) {
 	query := fmt.Sprintf(
-		`SELECT id, context_id, ts, source, content, live, est_tokens, response_id 
-		 FROM records WHERE %s ORDER BY ts ASC`,
+		`SELECT id, context_id, ts, source, content, live, est_tokens, response_id,
+		 tool_call_id, tool_name, tool_args, tool_error,
+		 input_tokens, output_tokens, cache_read_tokens, cache_write_tokens, reasoning_tokens,
+		 model, cost_usd
+		 FROM records WHERE %s ORDER BY ts ASC, id ASC`,
 		whereClause,
 	)
 	rows, err := db.Query(query, args...)
@@ -384,6 +867,10 @@ func listRecordsWhere(db *sql.DB, whereClause string, args ...interface{}) ([]Re
 	for rows.Next() {
 		var r Record
 		var src int
+		var toolCallID, toolName, toolArgs sql.NullString
+		var input, output, cacheRead, cacheWrite, reasoning sql.NullInt64
+		var model sql.NullString
+		var cost sql.NullFloat64
 		if err := rows.Scan(
 			&r.ID,
 			&r.ContextID,
@@ -393,15 +880,51 @@ func listRecordsWhere(db *sql.DB, whereClause string, args ...interface{}) ([]Re
 			&r.Live,
 			&r.EstTokens,
 			&r.ResponseID,
+			&toolCallID,
+			&toolName,
+			&toolArgs,
+			&r.ToolError,
+			&input,
+			&output,
+			&cacheRead,
+			&cacheWrite,
+			&reasoning,
+			&model,
+			&cost,
 		); err != nil {
 			return nil, fmt.Errorf("scan record: %w", err)
 		}
 		r.Source = RecordType(src)
+		r.ToolCallID = toolCallID.String
+		r.ToolName = toolName.String
+		if toolArgs.Valid {
+			r.ToolArgs = json.RawMessage(toolArgs.String)
+		}
+		if input.Valid {
+			r.Usage = &ModelUsage{
+				InputTokens:      int(input.Int64),
+				OutputTokens:     int(output.Int64),
+				CacheReadTokens:  int(cacheRead.Int64),
+				CacheWriteTokens: int(cacheWrite.Int64),
+				ReasoningTokens:  int(reasoning.Int64),
+			}
+		}
+		r.Model = model.String
+		r.Cost = cost.Float64
 		recs = append(recs, r)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("records rows: %w", err)
 	}
+	rows.Close()
+
+	attachments, err := listAttachmentsWhere(db, whereClause, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range recs {
+		recs[i].Attachments = attachments[recs[i].ID]
+	}
 	return recs, nil
 }
 
@@ -434,30 +957,178 @@ func insertRecordTxWithResponseID(
 	live bool,
 	responseID *string,
 ) (Record, error) {
-	now := time.Now().UTC()
-	t := tokenCount(content)
-	res, err := tx.Exec(
-		`INSERT INTO records (context_id, ts, source, content, live, est_tokens, response_id) 
-		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		contextID, now, int(source), content, live, t, responseID,
+	return insertRecordRow(tx, Record{
+		ContextID:  contextID,
+		Source:     source,
+		Content:    content,
+		Live:       live,
+		ResponseID: responseID,
+	})
+}
+
+// GetContextStats retrieves efficient statistics for a context by ID.
+// All metrics are computed using database aggregations with existing indexes.
+func GetContextStats(db *sql.DB, contextID string) (ContextStats, error) {
+	stats := ContextStats{}
+
+	// Get record counts and live token sum in a single query
+	row := db.QueryRow(`
+		SELECT 
+			COUNT(*) as total_records,
+			COUNT(CASE WHEN live = 1 THEN 1 END) as live_records,
+			COALESCE(SUM(CASE WHEN live = 1 THEN est_tokens ELSE 0 END), 0) as live_tokens,
+			MAX(ts) as last_activity
+		FROM records 
+		WHERE context_id = ?`,
+		contextID,
+	)
+
+	var lastActivityStr sql.NullString
+	err := row.Scan(
+		&stats.TotalRecords,
+		&stats.LiveRecords,
+		&stats.LiveTokens,
+		&lastActivityStr,
 	)
 	if err != nil {
-		return Record{}, fmt.Errorf("insert record tx: %w", err)
+		return ContextStats{}, fmt.Errorf("get context stats: %w", err)
 	}
-	id, err := res.LastInsertId()
+
+	// Usage comes from the model call ledger, which copies of the context
+	// don't share
+	spend, err := GetSpend(db, contextID, time.Time{}, time.Time{})
 	if err != nil {
-		return Record{}, fmt.Errorf("get last insert id tx: %w", err)
+		return ContextStats{}, fmt.Errorf("get context stats: %w", err)
 	}
-	return Record{
-		ID:         id,
-		Timestamp:  now,
-		Source:     source,
-		Content:    content,
-		Live:       live,
-		EstTokens:  t,
-		ContextID:  contextID,
-		ResponseID: responseID,
-	}, nil
+	stats.Usage = spend.Usage
+	stats.Cost = spend.Cost
+
+	if lastActivityStr.Valid && lastActivityStr.String != "" {
+		// Try multiple timestamp formats that SQLite might use
+		formats := []string{
+			"2006-01-02 15:04:05.999999999 -0700 MST", // SQLite format with timezone
+			"2006-01-02 15:04:05.999999999-07:00",
+			"2006-01-02 15:04:05.999999999",
+			"2006-01-02 15:04:05",
+			time.RFC3339,
+			time.RFC3339Nano,
+			"2006-01-02T15:04:05Z",
+			"2006-01-02T15:04:05.999999999Z",
+		}
+		for _, format := range formats {
+			if t, err := time.Parse(format, lastActivityStr.String); err == nil {
+				stats.LastActivity = &t
+				break
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// InsertModelCall adds an entry to the model call ledger, assigning its ID.
+func InsertModelCall(db *sql.DB, call ModelCall) (ModelCall, error) {
+	recordIDs, err := json.Marshal(call.RecordIDs)
+	if err != nil {
+		return ModelCall{}, fmt.Errorf("marshal record IDs: %w", err)
+	}
+
+	res, err := db.Exec(
+		`INSERT INTO model_calls (context_id, model, started_at, finished_at, latency_ns,
+		 requests, tokens_used,
+		 input_tokens, output_tokens, cache_read_tokens, cache_write_tokens, reasoning_tokens,
+		 cost_usd, stop_reason, record_ids, error)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		call.ContextID,
+		call.Model,
+		call.StartedAt.UTC(),
+		call.FinishedAt.UTC(),
+		int64(call.Latency),
+		call.Requests,
+		call.TokensUsed,
+		call.Usage.InputTokens,
+		call.Usage.OutputTokens,
+		call.Usage.CacheReadTokens,
+		call.Usage.CacheWriteTokens,
+		call.Usage.ReasoningTokens,
+		call.Cost,
+		call.StopReason,
+		string(recordIDs),
+		nullString(call.Error),
+	)
+	if err != nil {
+		return ModelCall{}, fmt.Errorf("insert model call: %w", err)
+	}
+	call.ID, err = res.LastInsertId()
+	if err != nil {
+		return ModelCall{}, fmt.Errorf("get last insert id: %w", err)
+	}
+	return call, nil
+}
+
+// ListModelCalls returns ledger entries started between from (inclusive)
+// and to (exclusive), oldest first, in one context or, if contextID is
+// empty, in all of them. A zero to means no upper bound.
+func ListModelCalls(db *sql.DB, contextID string, from, to time.Time) ([]ModelCall, error) {
+	query := `
+		SELECT id, context_id, model, started_at, finished_at, latency_ns,
+			requests, tokens_used,
+			input_tokens, output_tokens, cache_read_tokens, cache_write_tokens, reasoning_tokens,
+			cost_usd, stop_reason, record_ids, error
+		FROM model_calls
+		WHERE started_at >= ?`
+	args := []any{from.UTC()}
+	if !to.IsZero() {
+		query += ` AND started_at < ?`
+		args = append(args, to.UTC())
+	}
+	if contextID != "" {
+		query += ` AND context_id = ?`
+		args = append(args, contextID)
+	}
+	query += ` ORDER BY started_at ASC, id ASC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query model calls: %w", err)
+	}
+	defer rows.Close()
+
+	var calls []ModelCall
+	for rows.Next() {
+		var call ModelCall
+		var latency int64
+		var recordIDs string
+		var callErr sql.NullString
+		if err := rows.Scan(
+			&call.ID,
+			&call.ContextID,
+			&call.Model,
+			&call.StartedAt,
+			&call.FinishedAt,
+			&latency,
+			&call.Requests,
+			&call.TokensUsed,
+			&call.Usage.InputTokens,
+			&call.Usage.OutputTokens,
+			&call.Usage.CacheReadTokens,
+			&call.Usage.CacheWriteTokens,
+			&call.Usage.ReasoningTokens,
+			&call.Cost,
+			&call.StopReason,
+			&recordIDs,
+			&callErr,
+		); err != nil {
+			return nil, fmt.Errorf("scan model call: %w", err)
+		}
+		call.Latency = time.Duration(latency)
+		call.Error = callErr.String
+		if err := json.Unmarshal([]byte(recordIDs), &call.RecordIDs); err != nil {
+			return nil, fmt.Errorf("unmarshal record IDs: %w", err)
+		}
+		calls = append(calls, call)
+	}
+	return calls, rows.Err()
 }
 
 // getContextIDByName is a helper to get the internal UUID by context name.
@@ -581,6 +1252,65 @@ func UpdateContextLastResponseID(db *sql.DB, contextID, responseID string) error
 	return nil
 }
 
+// ClearContextLastResponseID unsets the last response ID for a context.
+func ClearContextLastResponseID(db *sql.DB, contextID string) error {
+	_, err := db.Exec(
+		`UPDATE contexts SET last_response_id = NULL WHERE id = ?`,
+		contextID,
+	)
+	if err != nil {
+		return fmt.Errorf("clear context last response ID: %w", err)
+	}
+	return nil
+}
+
+// SetContextTokenizer records the name of the tokenizer a context's records
+// were estimated with.
+func SetContextTokenizer(db *sql.DB, contextID, tokenizer string) error {
+	_, err := db.Exec(
+		`UPDATE contexts SET tokenizer = ? WHERE id = ?`,
+		tokenizer, contextID,
+	)
+	if err != nil {
+		return fmt.Errorf("set context tokenizer: %w", err)
+	}
+	return nil
+}
+
+// SetContextGeneration sets a context's default generation settings.
+func SetContextGeneration(db *sql.DB, contextID string, p GenerationParams) error {
+	js, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("set context generation: %w", err)
+	}
+	_, err = db.Exec(
+		`UPDATE contexts SET generation = ? WHERE id = ?`,
+		string(js), contextID,
+	)
+	if err != nil {
+		return fmt.Errorf("set context generation: %w", err)
+	}
+	return nil
+}
+
+// SetRecordTokens updates the token estimates of records, by ID, in a
+// single transaction.
+func SetRecordTokens(db *sql.DB, tokens map[int64]int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for id, n := range tokens {
+		_, err = tx.Exec(`UPDATE records SET est_tokens = ? WHERE id = ?`, n, id)
+		if err != nil {
+			return fmt.Errorf("set record tokens: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
 // SetContextServerSideThreading enables or disables server-side threading for a context.
 func SetContextServerSideThreading(db *sql.DB, contextID string, useServerSideThreading bool) error {
 	_, err := db.Exec(
@@ -635,41 +1365,183 @@ func addColumnIfNotExists(db *sql.DB, tableName, columnName, columnDef string) e
 
 // CloneContext creates a copy of the specified source context with a new name.
 func CloneContext(db *sql.DB, sourceName, destName string) error {
+	source, err := copySource(db, sourceName, destName)
+	if err != nil {
+		return fmt.Errorf("clone from %s to %s: %w", sourceName, destName, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("clone from %s to %s: begin transaction: %w", sourceName, destName, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := copyContext(tx, source, destName, 0); err != nil {
+		return fmt.Errorf("clone from %s to %s: %w", sourceName, destName, err)
+	}
+	return tx.Commit()
+}
+
+// ForkContext creates a context named destName with a copy of the source
+// context's records up to and including recordID, in timestamp order,
+// recording the source as its parent. The fork continues server-side
+// threading from the last response it copied.
+func ForkContext(db *sql.DB, sourceName string, recordID int64, destName string) error {
+	var recordContext string
+	err := db.QueryRow(`SELECT context_id FROM records WHERE id = ?`, recordID).Scan(&recordContext)
+	if err != nil {
+		return fmt.Errorf("fork %s at %d: record not found: %w", sourceName, recordID, err)
+	}
+	source, err := copySource(db, sourceName, destName)
+	if err != nil {
+		return fmt.Errorf("fork %s at %d: %w", sourceName, recordID, err)
+	}
+	if recordContext != source.ID {
+		return fmt.Errorf("fork %s at %d: record is in another context", sourceName, recordID)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("fork %s at %d: begin transaction: %w", sourceName, recordID, err)
+	}
+	defer tx.Rollback()
+
+	dest, err := copyContext(tx, source, destName, recordID)
+	if err != nil {
+		return fmt.Errorf("fork %s at %d: %w", sourceName, recordID, err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE contexts SET parent_id = ?, fork_record_id = ?,
+			last_response_id = (
+				SELECT response_id FROM records
+				WHERE context_id = ? AND source = ? AND response_id IS NOT NULL AND live = 1
+				ORDER BY ts DESC, id DESC LIMIT 1)
+		WHERE id = ?`,
+		source.ID, recordID, dest.ID, ModelResp, dest.ID)
+	if err != nil {
+		return fmt.Errorf("fork %s at %d: record parent: %w", sourceName, recordID, err)
+	}
+	return tx.Commit()
+}
+
+// ListChildContexts returns the contexts forked from a context, oldest
+// first.
+func ListChildContexts(db *sql.DB, contextID string) ([]Context, error) {
+	rows, err := db.Query(
+		`SELECT `+contextColumns+`
+		 FROM contexts WHERE parent_id = ? ORDER BY start_time`,
+		contextID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query child contexts: %w", err)
+	}
+	defer rows.Close()
+
+	var contexts []Context
+	for rows.Next() {
+		c, err := scanContext(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan context: %w", err)
+		}
+		contexts = append(contexts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("child contexts rows: %w", err)
+	}
+	return contexts, nil
+}
+
+// copySource looks up the source context of a copy, checking that the
+// destination name is free.
+func copySource(db *sql.DB, sourceName, destName string) (Context, error) {
 	if sourceName == "" || destName == "" {
-		return fmt.Errorf("source and destination context names cannot be empty")
+		return Context{}, fmt.Errorf("source and destination context names cannot be empty")
 	}
 
-	// Get source context
-	sourceContext, err := GetContextByName(db, sourceName)
+	source, err := GetContextByName(db, sourceName)
 	if err != nil {
-		return fmt.Errorf("clone from %s to %s: source context not found: %w", sourceName, destName, err)
+		return Context{}, fmt.Errorf("source context not found: %w", err)
 	}
 
-	// Check if destination already exists
 	_, err = GetContextByName(db, destName)
 	if err == nil {
-		return fmt.Errorf("clone from %s to %s: destination context already exists", sourceName, destName)
+		return Context{}, fmt.Errorf("destination context already exists")
 	}
 	if !errors.Is(err, sql.ErrNoRows) {
-		return fmt.Errorf("clone from %s to %s: %w", sourceName, destName, err)
+		return Context{}, err
 	}
+	return source, nil
+}
 
-	// Create destination context with same threading settings
-	destContext, err := CreateContextWithThreading(db, destName, sourceContext.UseServerSideThreading)
+// copyContext copies a context, its records (up to and including upTo, in
+// timestamp order, if upTo isn't zero) and its enabled tools to a new
+// context named destName, which it returns.
+func copyContext(tx *sql.Tx, source Context, destName string, upTo int64) (Context, error) {
+	dest := Context{
+		ID:                     uuid.New().String(),
+		Name:                   destName,
+		StartTime:              time.Now().UTC(),
+		UseServerSideThreading: source.UseServerSideThreading,
+		Tokenizer:              source.Tokenizer,
+		Generation:             source.Generation,
+	}
+	_, err := tx.Exec(`
+		INSERT INTO contexts (id, name, start_time, use_server_side_threading, tokenizer, generation)
+		SELECT ?, ?, ?, use_server_side_threading, tokenizer, generation
+		FROM contexts WHERE id = ?`,
+		dest.ID, dest.Name, dest.StartTime, source.ID)
 	if err != nil {
-		return fmt.Errorf("clone from %s to %s: create destination context: %w", sourceName, destName, err)
+		return Context{}, fmt.Errorf("create destination context: %w", err)
 	}
 
-	// Copy all records from source to destination
-	_, err = db.Exec(`
-		INSERT INTO records (context_id, source, content, live, est_tokens, ts, response_id)
-		SELECT ?, source, content, live, est_tokens, ts, response_id
+	// The records to copy, in the order ListRecordsInContext returns them:
+	// a summary has a higher ID than the records after it, so the cut is by
+	// position, not ID.
+	const copied = `
 		FROM records
-		WHERE context_id = ?`,
-		destContext.ID, sourceContext.ID)
+		WHERE context_id = ? AND (? = 0
+			OR ts < (SELECT ts FROM records WHERE id = ?)
+			OR (ts = (SELECT ts FROM records WHERE id = ?) AND id <= ?))`
+	copiedArgs := []any{source.ID, upTo, upTo, upTo, upTo}
+
+	_, err = tx.Exec(`
+		INSERT INTO records (context_id, source, content, live, est_tokens, ts, response_id,
+			tool_call_id, tool_name, tool_args, tool_error,
+			input_tokens, output_tokens, cache_read_tokens, cache_write_tokens, reasoning_tokens,
+			model, cost_usd)
+		SELECT ?, source, content, live, est_tokens, ts, response_id,
+			tool_call_id, tool_name, tool_args, tool_error,
+			input_tokens, output_tokens, cache_read_tokens, cache_write_tokens, reasoning_tokens,
+			model, cost_usd`+copied+`
+		ORDER BY ts, id`,
+		append([]any{dest.ID}, copiedArgs...)...)
 	if err != nil {
-		return fmt.Errorf("clone from %s to %s: copy records: %w", sourceName, destName, err)
+		return Context{}, fmt.Errorf("copy records: %w", err)
 	}
 
-	return nil
+	// The copies were inserted in timestamp order, so the nth copied record
+	// and the nth record of the new context are the same record.
+	_, err = tx.Exec(`
+		INSERT INTO record_attachments (record_id, position, blob_hash, name)
+		SELECT d.id, ra.position, ra.blob_hash, ra.name
+		FROM record_attachments ra
+		JOIN (SELECT id, ROW_NUMBER() OVER (ORDER BY ts, id) AS n`+copied+`) s ON s.id = ra.record_id
+		JOIN (SELECT id, ROW_NUMBER() OVER (ORDER BY id) AS n
+			FROM records WHERE context_id = ?) d ON d.n = s.n`,
+		append(copiedArgs, dest.ID)...)
+	if err != nil {
+		return Context{}, fmt.Errorf("copy attachments: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO context_tools (context_id, tool_name, created_at)
+		SELECT ?, tool_name, created_at FROM context_tools WHERE context_id = ?
+		ORDER BY created_at, id`,
+		dest.ID, source.ID)
+	if err != nil {
+		return Context{}, fmt.Errorf("copy tools: %w", err)
+	}
+
+	return dest, nil
 }
@@ -0,0 +1,209 @@
+package contextwindow
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Store is the persistence layer behind a ContextWindow: contexts, their
+// records, the tools enabled in them, and server-side threading state.
+//
+// [SQLiteStore] is the default implementation; [MemoryStore] keeps
+// everything in maps, for tests and embedding. GetContext and
+// GetContextByName must return an error wrapping sql.ErrNoRows for unknown
+// contexts.
+type Store interface {
+	// CreateContext creates a named context, or returns the existing one
+	// with its threading mode updated.
+	CreateContext(name string, useServerSideThreading bool) (Context, error)
+	GetContext(contextID string) (Context, error)
+	GetContextByName(name string) (Context, error)
+	// ListContexts returns all contexts, most recently started first.
+	ListContexts() ([]Context, error)
+	// DeleteContext removes a context, its records and its model calls.
+	DeleteContext(contextID string) error
+	// CloneContext copies a context, all its records and its enabled tools
+	// under a new name.
+	CloneContext(sourceName, destName string) error
+	// ForkContext copies a context's records up to and including recordID,
+	// in timestamp order, and its enabled tools, to a new context, recording
+	// the source as its parent. The new context threads from the last live
+	// response it copied.
+	ForkContext(sourceName string, recordID int64, destName string) error
+	// ListChildContexts returns the contexts forked from a context, oldest
+	// first.
+	ListChildContexts(contextID string) ([]Context, error)
+	SetServerSideThreading(contextID string, enabled bool) error
+	UpdateLastResponseID(contextID, responseID string) error
+	// ClearLastResponseID unsets a context's last response ID, so the next
+	// call starts a new server-side thread.
+	ClearLastResponseID(contextID string) error
+	// SetContextTokenizer records the name of the tokenizer a context's
+	// records were estimated with.
+	SetContextTokenizer(contextID, tokenizer string) error
+	// SetContextGeneration sets a context's default generation settings.
+	SetContextGeneration(contextID string, p GenerationParams) error
+
+	// InsertRecord stores rec, assigning its ID, and a token estimate and
+	// timestamp if it has none.
+	InsertRecord(rec Record) (Record, error)
+	// ListRecords returns all records in a context in timestamp order.
+	ListRecords(contextID string) ([]Record, error)
+	// ListLiveRecords returns live records in a context in timestamp order.
+	ListLiveRecords(contextID string) ([]Record, error)
+	// SetRecordsLive atomically updates the live flag on records.
+	SetRecordsLive(ids []int64, live bool) error
+	// SetRecordTokens updates the token estimates of records, by ID.
+	SetRecordTokens(tokens map[int64]int) error
+	// ReplaceRecords atomically marks kill not live and inserts add.
+	ReplaceRecords(kill []int64, add []Record) ([]Record, error)
+	// Search runs a full-text search over records, best matches first.
+	Search(q SearchQuery) ([]SearchResult, error)
+	// ContextStats summarizes the records in a context, and the usage of its
+	// model call ledger entries.
+	ContextStats(contextID string) (ContextStats, error)
+	// Spend sums the model call ledger entries in a context (or all
+	// contexts, if contextID is empty) started between from and to; a zero
+	// to means no upper bound.
+	Spend(contextID string, from, to time.Time) (Spend, error)
+
+	// InsertModelCall adds an entry to the model call ledger, assigning
+	// its ID.
+	InsertModelCall(call ModelCall) (ModelCall, error)
+	// ListModelCalls returns ledger entries in a context (or all contexts,
+	// if contextID is empty) started between from and to, oldest first; a
+	// zero to means no upper bound.
+	ListModelCalls(contextID string, from, to time.Time) ([]ModelCall, error)
+
+	AddContextTool(contextID, toolName string) (ContextTool, error)
+	ListContextTools(contextID string) ([]ContextTool, error)
+	RemoveContextTool(contextID, toolName string) error
+	HasContextTool(contextID, toolName string) (bool, error)
+
+	Close() error
+}
+
+// exportContext extracts a complete context from a store by name.
+func exportContext(store Store, name string) (ContextExport, error) {
+	context, err := store.GetContextByName(name)
+	if err != nil {
+		return ContextExport{}, err
+	}
+
+	records, err := store.ListRecords(context.ID)
+	if err != nil {
+		return ContextExport{}, fmt.Errorf("list records: %w", err)
+	}
+
+	tools, err := store.ListContextTools(context.ID)
+	if err != nil {
+		return ContextExport{}, fmt.Errorf("list context tools: %w", err)
+	}
+
+	return ContextExport{
+		Version: ContextExportVersion,
+		Context: context,
+		Records: records,
+		Tools:   tools,
+	}, nil
+}
+
+// validate checks that an export can be imported.
+func (e ContextExport) validate() error {
+	switch {
+	case e.Version == 0:
+		return fmt.Errorf("missing export version")
+	case e.Version > ContextExportVersion:
+		return fmt.Errorf("export version %d is newer than supported version %d", e.Version, ContextExportVersion)
+	}
+	for i, r := range e.Records {
+		if r.Source < Prompt || r.Source > SystemPrompt {
+			return fmt.Errorf("record %d: unknown source %d", i, r.Source)
+		}
+		if len(r.ToolArgs) > 0 && !json.Valid(r.ToolArgs) {
+			return fmt.Errorf("record %d: invalid tool arguments", i)
+		}
+		for j, a := range r.Attachments {
+			if a.Hash != "" && a.Hash != blobHash(a.Data) {
+				return fmt.Errorf("record %d: attachment %d doesn't match its hash", i, j)
+			}
+		}
+	}
+	for i, t := range e.Tools {
+		if t.ToolName == "" {
+			return fmt.Errorf("tool %d: empty name", i)
+		}
+	}
+	return nil
+}
+
+// importContext recreates an exported context in a store, under name if it
+// isn't empty. Records keep their timestamps, liveness and response IDs but
+// get new IDs; fork ancestry, which refers to contexts elsewhere, is
+// dropped. If the import fails partway, the new context is deleted.
+func importContext(store Store, export ContextExport, name string) (Context, error) {
+	if err := export.validate(); err != nil {
+		return Context{}, err
+	}
+	if name == "" {
+		name = export.Context.Name
+	}
+	if name == "" {
+		return Context{}, fmt.Errorf("context name cannot be empty")
+	}
+	if _, err := store.GetContextByName(name); err == nil {
+		return Context{}, fmt.Errorf("context '%s' already exists", name)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return Context{}, err
+	}
+
+	c, err := store.CreateContext(name, export.Context.UseServerSideThreading)
+	if err != nil {
+		return Context{}, err
+	}
+	if err := importInto(store, c.ID, export); err != nil {
+		if derr := store.DeleteContext(c.ID); derr != nil {
+			return Context{}, errors.Join(err, derr)
+		}
+		return Context{}, err
+	}
+	return store.GetContext(c.ID)
+}
+
+// importInto fills the new context contextID from export.
+func importInto(store Store, contextID string, export ContextExport) error {
+	src := export.Context
+	if src.LastResponseID != nil {
+		if err := store.UpdateLastResponseID(contextID, *src.LastResponseID); err != nil {
+			return err
+		}
+	}
+	if src.Tokenizer != "" {
+		if err := store.SetContextTokenizer(contextID, src.Tokenizer); err != nil {
+			return err
+		}
+	}
+	if !reflect.DeepEqual(src.Generation, GenerationParams{}) {
+		if err := store.SetContextGeneration(contextID, src.Generation); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range export.Records {
+		r.ID = 0
+		r.ContextID = contextID
+		if _, err := store.InsertRecord(r); err != nil {
+			return err
+		}
+	}
+	for _, t := range export.Tools {
+		if _, err := store.AddContextTool(contextID, t.ToolName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
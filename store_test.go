@@ -0,0 +1,27 @@
+package contextwindow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// forEachStore runs f as a subtest against each Store implementation.
+func forEachStore(t *testing.T, f func(t *testing.T, s Store)) {
+	for _, tc := range []struct {
+		name     string
+		newStore func(t *testing.T) Store
+	}{
+		{"sqlite", func(t *testing.T) Store {
+			db, err := NewContextDB(":memory:")
+			assert.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			return NewSQLiteStore(db)
+		}},
+		{"memory", func(t *testing.T) Store { return NewMemoryStore() }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f(t, tc.newStore(t))
+		})
+	}
+}
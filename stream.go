@@ -0,0 +1,130 @@
+package contextwindow
+
+import (
+	"context"
+	"fmt"
+)
+
+// StreamEventType distinguishes the kinds of events delivered by
+// [ContextWindow.CallModelStream].
+type StreamEventType int
+
+const (
+	// StreamTextDelta carries a fragment of model output text.
+	StreamTextDelta StreamEventType = iota
+	// StreamToolCallStart is sent just before a tool is executed.
+	StreamToolCallStart
+	// StreamToolResult is sent when a tool call completes.
+	StreamToolResult
+	// StreamUsage reports the tokens used by the whole call.
+	StreamUsage
+	// StreamDone is the final event of a successful call; Text holds the
+	// complete final response.
+	StreamDone
+	// StreamError is the final event of a failed call.
+	StreamError
+)
+
+// StreamEvent is one event in a streamed model call.
+type StreamEvent struct {
+	Type       StreamEventType
+	Text       string
+	ToolName   string
+	ToolArgs   string
+	Err        error
+	TokensUsed int
+}
+
+// StreamingCapable is an optional interface for models that can deliver
+// partial output while a call is in progress. Implementations send events
+// on stream as they happen and return the same results as
+// [CallOptsCapable.CallWithThreadingAndOpts]; they must not close stream.
+type StreamingCapable interface {
+	CallStream(
+		ctx context.Context,
+		useServerSideThreading bool,
+		lastResponseID *string,
+		inputs []Record,
+		opts CallModelOpts,
+		stream chan<- StreamEvent,
+	) (events []Record, responseID *string, tokensUsed int, err error)
+}
+
+// CallModelStream drives an LLM like [ContextWindow.CallModelWithOpts], but
+// returns immediately with a channel of events describing the call as it
+// proceeds. Records are persisted exactly as CallModelWithOpts does before
+// the final StreamDone (or StreamError) event is sent; the channel is closed
+// after that.
+//
+// Models that don't implement [StreamingCapable] are called normally, and
+// their reply is delivered as a single StreamTextDelta.
+//
+// The caller must drain the channel or cancel ctx.
+func (cw *ContextWindow) CallModelStream(
+	ctx context.Context,
+	opts CallModelOpts,
+) (<-chan StreamEvent, error) {
+	if _, err := cw.contextIDByName(cw.currentContext); err != nil {
+		return nil, fmt.Errorf("call model stream: %w", err)
+	}
+
+	stream := make(chan StreamEvent, 16)
+	go cw.runStream(ctx, opts, stream)
+	return stream, nil
+}
+
+func (cw *ContextWindow) runStream(
+	ctx context.Context,
+	opts CallModelOpts,
+	stream chan StreamEvent,
+) {
+	defer close(stream)
+
+	msg, err := cw.callModel(ctx, opts, stream)
+	if err != nil {
+		sendStreamEvent(ctx, stream, StreamEvent{
+			Type: StreamError,
+			Err:  err,
+		})
+		return
+	}
+
+	sendStreamEvent(ctx, stream, StreamEvent{
+		Type: StreamDone,
+		Text: msg,
+	})
+}
+
+// sendStreamEvent delivers ev on stream, if there is one. It gives up if ctx
+// is cancelled so that abandoned streams don't leak goroutines.
+func sendStreamEvent(ctx context.Context, stream chan<- StreamEvent, ev StreamEvent) {
+	if stream == nil {
+		return
+	}
+	select {
+	case stream <- ev:
+	case <-ctx.Done():
+	}
+}
+
+func streamToolCall(ctx context.Context, stream chan<- StreamEvent, name, args string) {
+	sendStreamEvent(ctx, stream, StreamEvent{
+		Type:     StreamToolCallStart,
+		ToolName: name,
+		ToolArgs: args,
+	})
+}
+
+func streamToolResult(
+	ctx context.Context,
+	stream chan<- StreamEvent,
+	name, result string,
+	err error,
+) {
+	sendStreamEvent(ctx, stream, StreamEvent{
+		Type:     StreamToolResult,
+		ToolName: name,
+		Text:     result,
+		Err:      err,
+	})
+}
@@ -0,0 +1,354 @@
+package contextwindow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go/v2/shared"
+	"github.com/stretchr/testify/assert"
+)
+
+type streamingModel struct {
+	deltas []string
+}
+
+func (m *streamingModel) Call(ctx context.Context, inputs []Record) ([]Record, int, error) {
+	return nil, 0, nil
+}
+
+func (m *streamingModel) CallStream(
+	ctx context.Context,
+	useServerSideThreading bool,
+	lastResponseID *string,
+	inputs []Record,
+	opts CallModelOpts,
+	stream chan<- StreamEvent,
+) ([]Record, *string, int, error) {
+	streamToolCall(ctx, stream, "ls", "{}")
+	streamToolResult(ctx, stream, "ls", "go.mod", nil)
+
+	var full string
+	for _, d := range m.deltas {
+		sendStreamEvent(ctx, stream, StreamEvent{
+			Type: StreamTextDelta,
+			Text: d,
+		})
+		full += d
+	}
+
+	events := []Record{
+		{Source: ToolCall, Content: "ls({})", Live: true},
+		{Source: ToolOutput, Content: "go.mod", Live: true},
+		{Source: ModelResp, Content: full, Live: true},
+	}
+	return events, nil, 42, nil
+}
+
+func drainStream(t *testing.T, stream <-chan StreamEvent) []StreamEvent {
+	t.Helper()
+	var evs []StreamEvent
+	for ev := range stream {
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func TestCallModelStream(t *testing.T) {
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	model := &streamingModel{deltas: []string{"hello", " ", "world"}}
+	cw, err := NewContextWindow(db, model, "stream")
+	assert.NoError(t, err)
+	assert.NoError(t, cw.AddPrompt("say hello"))
+
+	stream, err := cw.CallModelStream(context.Background(), CallModelOpts{})
+	assert.NoError(t, err)
+	evs := drainStream(t, stream)
+
+	var types []StreamEventType
+	var text string
+	for _, ev := range evs {
+		types = append(types, ev.Type)
+		if ev.Type == StreamTextDelta {
+			text += ev.Text
+		}
+	}
+	assert.Equal(t, []StreamEventType{
+		StreamToolCallStart,
+		StreamToolResult,
+		StreamTextDelta,
+		StreamTextDelta,
+		StreamTextDelta,
+		StreamUsage,
+		StreamDone,
+	}, types)
+	assert.Equal(t, "hello world", text)
+	assert.Equal(t, "ls", evs[0].ToolName)
+	assert.Equal(t, 42, evs[5].TokensUsed)
+	assert.Equal(t, "hello world", evs[6].Text)
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 4)
+	assert.Equal(t, ToolCall, recs[1].Source)
+	assert.Equal(t, "hello world", recs[3].Content)
+	assert.Equal(t, 42, cw.TotalTokens())
+}
+
+func TestCallModelStreamNonStreamingModel(t *testing.T) {
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	model := &MockModel{events: []Record{
+		{Source: ModelResp, Content: "whole reply", Live: true},
+	}}
+	cw, err := NewContextWindow(db, model, "stream")
+	assert.NoError(t, err)
+
+	stream, err := cw.CallModelStream(context.Background(), CallModelOpts{})
+	assert.NoError(t, err)
+	evs := drainStream(t, stream)
+
+	assert.Len(t, evs, 3)
+	assert.Equal(t, StreamTextDelta, evs[0].Type)
+	assert.Equal(t, "whole reply", evs[0].Text)
+	assert.Equal(t, StreamUsage, evs[1].Type)
+	assert.Equal(t, StreamDone, evs[2].Type)
+}
+
+func TestCallModelStreamError(t *testing.T) {
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err := NewContextWindow(db, &MockModel{}, "stream")
+	assert.NoError(t, err)
+	assert.NoError(t, cw.SetServerSideThreading(true))
+
+	stream, err := cw.CallModelStream(context.Background(), CallModelOpts{})
+	assert.NoError(t, err)
+	evs := drainStream(t, stream)
+
+	assert.Len(t, evs, 1)
+	assert.Equal(t, StreamError, evs[0].Type)
+	assert.Error(t, evs[0].Err)
+}
+
+// sseReply formats events as a server-sent event stream, naming each event
+// after its "type" field, if it has one.
+func sseReply(events ...string) string {
+	var b strings.Builder
+	for _, ev := range events {
+		var typed struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal([]byte(ev), &typed)
+		if typed.Type != "" {
+			fmt.Fprintf(&b, "event: %s\n", typed.Type)
+		}
+		fmt.Fprintf(&b, "data: %s\n\n", ev)
+	}
+	return b.String()
+}
+
+// fakeSSEServer returns the URL of a test server that answers the nth
+// request with the event stream reply(n).
+func fakeSSEServer(t *testing.T, reply func(n int) string) string {
+	var n int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, reply(n))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func claudeTextStream(n int) string {
+	return sseReply(
+		`{"type": "message_start", "message": {"id": "msg_1", "type": "message", "role": "assistant", "model": "claude-sonnet-4-5", "content": [], "stop_reason": null, "usage": {"input_tokens": 5, "output_tokens": 1}}}`,
+		`{"type": "content_block_start", "index": 0, "content_block": {"type": "text", "text": ""}}`,
+		`{"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "hello"}}`,
+		`{"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": " world"}}`,
+		`{"type": "content_block_stop", "index": 0}`,
+		`{"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 5}}`,
+		`{"type": "message_stop"}`,
+	)
+}
+
+func chatTextStream(n int) string {
+	return sseReply(
+		`{"id": "chatcmpl-1", "object": "chat.completion.chunk", "created": 1, "model": "gpt-4o", "choices": [{"index": 0, "delta": {"role": "assistant", "content": "hello"}, "finish_reason": null}]}`,
+		`{"id": "chatcmpl-1", "object": "chat.completion.chunk", "created": 1, "model": "gpt-4o", "choices": [{"index": 0, "delta": {"content": " world"}, "finish_reason": "stop"}]}`,
+		`{"id": "chatcmpl-1", "object": "chat.completion.chunk", "created": 1, "model": "gpt-4o", "choices": [], "usage": {"prompt_tokens": 5, "completion_tokens": 5, "total_tokens": 10}}`,
+		`[DONE]`,
+	)
+}
+
+func responsesTextStream(n int) string {
+	return sseReply(
+		`{"type": "response.output_text.delta", "sequence_number": 1, "item_id": "msg_1", "output_index": 0, "content_index": 0, "delta": "hello"}`,
+		`{"type": "response.output_text.delta", "sequence_number": 2, "item_id": "msg_1", "output_index": 0, "content_index": 0, "delta": " world"}`,
+		`{"type": "response.completed", "sequence_number": 3, "response": {"id": "resp_2", "object": "response", "created_at": 1, "status": "completed", "model": "gpt-4o", "output": [{"type": "message", "id": "msg_1", "role": "assistant", "status": "completed", "content": [{"type": "output_text", "text": "hello world", "annotations": []}]}], "usage": {"input_tokens": 5, "output_tokens": 5, "total_tokens": 10}}}`,
+	)
+}
+
+// streamDeltas returns the text deltas among evs.
+func streamDeltas(evs []StreamEvent) []string {
+	var deltas []string
+	for _, ev := range evs {
+		if ev.Type == StreamTextDelta {
+			deltas = append(deltas, ev.Text)
+		}
+	}
+	return deltas
+}
+
+func TestModelsStream(t *testing.T) {
+	opts := func(url string) []ClientOption {
+		return []ClientOption{WithBaseURL(url), WithAPIKey("test")}
+	}
+	claude, err := NewClaudeModel(ModelClaudeSonnet45, opts(fakeSSEServer(t, claudeTextStream))...)
+	assert.NoError(t, err)
+	chat, err := NewOpenAIModel(shared.ChatModelGPT4o, opts(fakeSSEServer(t, chatTextStream))...)
+	assert.NoError(t, err)
+	resp, err := NewOpenAIResponsesModel(ResponsesModel4o, opts(fakeSSEServer(t, responsesTextStream))...)
+	assert.NoError(t, err)
+
+	for name, m := range map[string]Model{"claude": claude, "chat": chat, "responses": resp} {
+		t.Run(name, func(t *testing.T) {
+			db, err := NewContextDB(":memory:")
+			assert.NoError(t, err)
+			defer db.Close()
+			cw, err := NewContextWindow(db, m, "stream")
+			assert.NoError(t, err)
+			assert.NoError(t, cw.AddPrompt("say hello"))
+
+			stream, err := cw.CallModelStream(context.Background(), CallModelOpts{})
+			assert.NoError(t, err)
+			evs := drainStream(t, stream)
+
+			assert.Equal(t, []string{"hello", " world"}, streamDeltas(evs))
+			last := evs[len(evs)-1]
+			assert.Equal(t, StreamDone, last.Type, last.Err)
+			assert.Equal(t, "hello world", last.Text)
+
+			recs, err := cw.LiveRecords()
+			assert.NoError(t, err)
+			assert.Equal(t, "hello world", recs[len(recs)-1].Content)
+			assert.Equal(t, 10, cw.TotalTokens())
+		})
+	}
+}
+
+func TestOpenAIModelStreamToolRound(t *testing.T) {
+	url := fakeSSEServer(t, func(n int) string {
+		if n > 1 {
+			return chatTextStream(n)
+		}
+		return sseReply(
+			`{"id": "chatcmpl-0", "object": "chat.completion.chunk", "created": 1, "model": "gpt-4o", "choices": [{"index": 0, "delta": {"role": "assistant", "tool_calls": [{"index": 0, "id": "call_1", "type": "function", "function": {"name": "echo", "arguments": "{}"}}]}, "finish_reason": "tool_calls"}]}`,
+			`[DONE]`,
+		)
+	})
+	m, err := NewOpenAIModel(shared.ChatModelGPT4o, WithBaseURL(url), WithAPIKey("test"))
+	assert.NoError(t, err)
+
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+	cw, err := NewContextWindow(db, m, "stream")
+	assert.NoError(t, err)
+	assert.NoError(t, cw.RegisterTool("echo", NewTool("echo", "echoes"), ToolRunnerFunc(
+		func(ctx context.Context, args json.RawMessage) (string, error) {
+			return "echoed", nil
+		})))
+	assert.NoError(t, cw.AddPrompt("echo something"))
+
+	stream, err := cw.CallModelStream(context.Background(), CallModelOpts{})
+	assert.NoError(t, err)
+	evs := drainStream(t, stream)
+
+	if assert.Len(t, evs, 6) {
+		assert.Equal(t, StreamToolCallStart, evs[0].Type)
+		assert.Equal(t, "echo", evs[0].ToolName)
+		assert.Equal(t, StreamToolResult, evs[1].Type)
+		assert.Equal(t, "echoed", evs[1].Text)
+		assert.Equal(t, StreamDone, evs[5].Type)
+	}
+	assert.Equal(t, []string{"hello", " world"}, streamDeltas(evs))
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	if assert.Len(t, recs, 4) {
+		assert.Equal(t, ToolCall, recs[1].Source)
+		assert.Equal(t, "call_1", recs[2].ToolCallID)
+		assert.Equal(t, "hello world", recs[3].Content)
+	}
+}
+
+func TestResponsesStreamFallsBackToClientSideThreading(t *testing.T) {
+	var prevIDs []any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		prevIDs = append(prevIDs, body["previous_response_id"])
+		if body["previous_response_id"] != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error": {"message": "previous response not found", "type": "invalid_request_error"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, responsesTextStream(len(prevIDs)))
+	}))
+	defer srv.Close()
+	m, err := NewOpenAIResponsesModel(ResponsesModel4o, WithBaseURL(srv.URL), WithAPIKey("test"))
+	assert.NoError(t, err)
+
+	prev := "resp_1"
+	stream := make(chan StreamEvent, 16)
+	events, responseID, _, err := m.CallStream(context.Background(), true, &prev,
+		[]Record{{Source: Prompt, Content: "say hello"}}, CallModelOpts{}, stream)
+	close(stream)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []any{"resp_1", nil}, prevIDs)
+	assert.Equal(t, []string{"hello", " world"}, streamDeltas(drainStream(t, stream)))
+	assert.Equal(t, "hello world", events[len(events)-1].Content)
+	if assert.NotNil(t, responseID) {
+		assert.Equal(t, "resp_2", *responseID)
+	}
+}
+
+func TestResponsesStreamInterruptedDoesNotFallBack(t *testing.T) {
+	var requests int
+	url := fakeSSEServer(t, func(n int) string {
+		requests = n
+		return sseReply(
+			`{"type": "response.output_text.delta", "sequence_number": 1, "item_id": "msg_1", "output_index": 0, "content_index": 0, "delta": "hello"}`,
+			`{"type": "response.failed", "sequence_number": 2, "response": {"id": "resp_2", "object": "response", "created_at": 1, "status": "failed", "model": "gpt-4o", "output": []}}`,
+		)
+	})
+	m, err := NewOpenAIResponsesModel(ResponsesModel4o, WithBaseURL(url), WithAPIKey("test"))
+	assert.NoError(t, err)
+
+	prev := "resp_1"
+	stream := make(chan StreamEvent, 16)
+	_, _, _, err = m.CallStream(context.Background(), true, &prev,
+		[]Record{{Source: Prompt, Content: "say hello"}}, CallModelOpts{}, stream)
+	close(stream)
+	assert.Error(t, err)
+
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, []string{"hello"}, streamDeltas(drainStream(t, stream)))
+}
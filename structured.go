@@ -0,0 +1,246 @@
+package contextwindow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ResponseSchema asks a model to answer with JSON matching a JSON Schema.
+type ResponseSchema struct {
+	// Name identifies the schema to the provider. It must match
+	// ^[a-zA-Z0-9_-]{1,64}$.
+	Name        string
+	Description string
+	Schema      map[string]any
+	// Strict asks OpenAI models to guarantee the schema is followed, which
+	// requires every property to be required and additionalProperties to be
+	// false. Claude ignores it.
+	Strict bool
+}
+
+// StructuredOutputCapable is an optional interface for models that can be
+// made to answer in a schema (see [CallModelOpts.ResponseSchema]). Models
+// that don't implement it are told the schema in a prompt.
+type StructuredOutputCapable interface {
+	SupportsResponseSchema() bool
+}
+
+// StructuredOpts contains options for [ContextWindow.CallModelStructured].
+type StructuredOpts struct {
+	CallModelOpts
+
+	// Name and Description describe the answer to the model. Name defaults
+	// to "response".
+	Name        string
+	Description string
+
+	// Schema is the JSON Schema the answer must match. If nil, it's derived
+	// from the output value with [SchemaFor], or [StrictSchemaFor] if
+	// Strict is set.
+	Schema map[string]any
+	// Strict is sent as [ResponseSchema.Strict].
+	Strict bool
+
+	// MaxAttempts is how many times the model is asked for an answer that
+	// matches the schema. Zero means 3.
+	MaxAttempts int
+}
+
+// StructuredOutputError is returned by [ContextWindow.CallModelStructured]
+// when the model never produced a valid answer.
+type StructuredOutputError struct {
+	// Response is the model's last answer.
+	Response string
+	Attempts int
+	Err      error
+}
+
+func (e *StructuredOutputError) Error() string {
+	return fmt.Sprintf("no valid structured output after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *StructuredOutputError) Unwrap() error {
+	return e.Err
+}
+
+// CallModelStructured calls the model for a JSON answer matching a schema and
+// unmarshals it into out, which must be a non-nil pointer.
+//
+// Models that implement [StructuredOutputCapable] are sent the schema with
+// their provider's structured-output feature; others are given it in a
+// prompt. An answer that isn't valid JSON or doesn't match the schema is
+// sent back to the model with the validation error, up to MaxAttempts
+// times. The schema prompt, the corrections and the rejected answers are
+// then taken out of the live context, so it reads as the caller's prompt
+// followed by the accepted answer. The schema prompt and the corrections are
+// taken out even if the call fails.
+func (cw *ContextWindow) CallModelStructured(ctx context.Context, out any, opts StructuredOpts) (err error) {
+	if rv := reflect.ValueOf(out); rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("call model structured: out must be a non-nil pointer, not %T", out)
+	}
+
+	schema := opts.Schema
+	switch {
+	case schema != nil:
+	case opts.Strict:
+		schema, err = StrictSchemaFor(out)
+	default:
+		schema, err = SchemaFor(out)
+	}
+	if err != nil {
+		return fmt.Errorf("call model structured: %w", err)
+	}
+	name := opts.Name
+	if name == "" {
+		name = "response"
+	}
+	attempts := opts.MaxAttempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+
+	contextID, err := cw.contextIDByName(cw.currentContext)
+	if err != nil {
+		return fmt.Errorf("call model structured: %w", err)
+	}
+
+	callOpts := opts.CallModelOpts
+	var scratch []int64
+	defer func() {
+		if len(scratch) == 0 {
+			return
+		}
+		if serr := cw.store.SetRecordsLive(scratch, false); serr != nil {
+			err = errors.Join(err, fmt.Errorf("call model structured: %w", serr))
+		}
+	}()
+	if sm, ok := cw.model.(StructuredOutputCapable); ok && sm.SupportsResponseSchema() {
+		callOpts.ResponseSchema = &ResponseSchema{
+			Name:        name,
+			Description: opts.Description,
+			Schema:      schema,
+			Strict:      opts.Strict,
+		}
+	} else {
+		text, err := schemaInstructions(schema, opts.Description)
+		if err != nil {
+			return fmt.Errorf("call model structured: %w", err)
+		}
+		rec, err := cw.insertPrompt(contextID, text)
+		if err != nil {
+			return fmt.Errorf("call model structured: %w", err)
+		}
+		scratch = append(scratch, rec.ID)
+	}
+
+	var (
+		resp   string
+		retErr error
+	)
+	for attempt := 1; ; attempt++ {
+		before, err := cw.liveRecordIDs(contextID)
+		if err != nil {
+			return fmt.Errorf("call model structured: %w", err)
+		}
+
+		resp, err = cw.CallModelWithOpts(ctx, callOpts)
+		if err != nil {
+			return fmt.Errorf("call model structured: %w", err)
+		}
+
+		data := []byte(stripCodeFence(resp))
+		err = ValidateJSON(schema, data)
+		if err == nil {
+			err = json.Unmarshal(data, out)
+		}
+		if err == nil {
+			break
+		}
+		if attempt == attempts {
+			retErr = &StructuredOutputError{Response: resp, Attempts: attempt, Err: err}
+			break
+		}
+
+		recs, lerr := cw.store.ListLiveRecords(contextID)
+		if lerr != nil {
+			return fmt.Errorf("call model structured: %w", lerr)
+		}
+		for _, r := range recs {
+			if _, ok := before[r.ID]; !ok && r.Source == ModelResp {
+				scratch = append(scratch, r.ID)
+			}
+		}
+
+		rec, perr := cw.insertPrompt(contextID, fmt.Sprintf(
+			"That answer was rejected: %v. Answer again with only a JSON value matching the schema.", err))
+		if perr != nil {
+			return fmt.Errorf("call model structured: %w", perr)
+		}
+		scratch = append(scratch, rec.ID)
+	}
+	return retErr
+}
+
+// schemaInstructions tells a model without native structured output what
+// shape to answer in.
+func schemaInstructions(schema map[string]any, description string) (string, error) {
+	js, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal schema: %w", err)
+	}
+	var b strings.Builder
+	b.WriteString("Answer with only a JSON value matching this JSON Schema, with no other text")
+	if description != "" {
+		fmt.Fprintf(&b, " (%s)", description)
+	}
+	b.WriteString(":\n\n")
+	b.Write(js)
+	return b.String(), nil
+}
+
+// stripCodeFence removes the Markdown code fence models sometimes wrap JSON
+// in.
+func stripCodeFence(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "```") {
+		return s
+	}
+	s = strings.TrimPrefix(s, "```")
+	if nl := strings.IndexByte(s, '\n'); nl >= 0 {
+		s = s[nl+1:]
+	}
+	s = strings.TrimSuffix(strings.TrimSpace(s), "```")
+	return strings.TrimSpace(s)
+}
+
+// insertPrompt logs a user prompt to a context and returns its record.
+func (cw *ContextWindow) insertPrompt(contextID, text string) (Record, error) {
+	rec, err := cw.store.InsertRecord(Record{
+		ContextID: contextID,
+		Source:    Prompt,
+		Content:   text,
+		Live:      true,
+		EstTokens: cw.countTokens(text),
+	})
+	if err != nil {
+		return Record{}, fmt.Errorf("insert prompt: %w", err)
+	}
+	return rec, nil
+}
+
+// liveRecordIDs returns the IDs of a context's live records.
+func (cw *ContextWindow) liveRecordIDs(contextID string) (map[int64]struct{}, error) {
+	recs, err := cw.store.ListLiveRecords(contextID)
+	if err != nil {
+		return nil, fmt.Errorf("list live records: %w", err)
+	}
+	ids := make(map[int64]struct{}, len(recs))
+	for _, r := range recs {
+		ids[r.ID] = struct{}{}
+	}
+	return ids, nil
+}
@@ -0,0 +1,268 @@
+package contextwindow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
+	"github.com/openai/openai-go/v2/shared"
+	"github.com/stretchr/testify/assert"
+)
+
+type verdict struct {
+	Safe   bool     `json:"safe" description:"whether the command is safe to run"`
+	Score  int      `json:"score"`
+	Issues []string `json:"issues,omitempty"`
+}
+
+func TestSchemaFor(t *testing.T) {
+	schema, err := SchemaFor(&verdict{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"safe":   map[string]any{"type": "boolean", "description": "whether the command is safe to run"},
+			"score":  map[string]any{"type": "integer"},
+			"issues": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+		"required": []string{"safe", "score"},
+	}, schema)
+
+	_, err = SchemaFor("not a struct")
+	assert.Error(t, err)
+
+	type note struct {
+		Author *string `json:"author"`
+	}
+	schema, err = SchemaFor(note{})
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateJSON(schema, []byte(`{"author": null}`)))
+	assert.NoError(t, ValidateJSON(schema, []byte(`{"author": "ann"}`)))
+	assert.Error(t, ValidateJSON(schema, []byte(`{}`)))
+
+	type node struct {
+		Children []node `json:"children"`
+	}
+	_, err = SchemaFor(node{})
+	assert.ErrorContains(t, err, "recursive")
+}
+
+func TestStrictSchemaFor(t *testing.T) {
+	type ticket struct {
+		Title  string   `json:"title"`
+		Labels []string `json:"labels,omitempty"`
+		Owner  *struct {
+			Name  string `json:"name"`
+			Email string `json:"email,omitempty"`
+		} `json:"owner,omitempty"`
+	}
+	schema, err := StrictSchemaFor(ticket{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"title":  map[string]any{"type": "string"},
+			"labels": map[string]any{"type": []string{"array", "null"}, "items": map[string]any{"type": "string"}},
+			"owner": map[string]any{
+				"type": []string{"object", "null"},
+				"properties": map[string]any{
+					"name":  map[string]any{"type": "string"},
+					"email": map[string]any{"type": []string{"string", "null"}},
+				},
+				"required":             []string{"email", "name"},
+				"additionalProperties": false,
+			},
+		},
+		"required":             []string{"labels", "owner", "title"},
+		"additionalProperties": false,
+	}, schema)
+	assert.NoError(t, ValidateJSON(schema, []byte(`{"title": "x", "labels": null, "owner": null}`)))
+
+	type loose struct {
+		Extra map[string]string `json:"extra"`
+	}
+	_, err = StrictSchemaFor(loose{})
+	assert.ErrorContains(t, err, "maps")
+	type raw struct {
+		Data json.RawMessage `json:"data"`
+	}
+	_, err = StrictSchemaFor(raw{})
+	assert.Error(t, err)
+}
+
+func TestValidateJSON(t *testing.T) {
+	schema, err := SchemaFor(verdict{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, ValidateJSON(schema, []byte(`{"safe": true, "score": 3}`)))
+	assert.NoError(t, ValidateJSON(schema, []byte(`{"safe": false, "score": 0, "issues": ["rm -rf"]}`)))
+	assert.NoError(t, ValidateJSON(schema, []byte(`{"safe": true, "score": 1.0}`)))
+
+	for _, bad := range []string{
+		`not json`,
+		`{"safe": true}`,
+		`{"safe": "yes", "score": 3}`,
+		`{"safe": true, "score": 3.5}`,
+		`{"safe": true, "score": 3, "issues": [1]}`,
+		`{"safe": true, "score": 3} {}`,
+	} {
+		assert.Error(t, ValidateJSON(schema, []byte(bad)), bad)
+	}
+
+	enum := map[string]any{"type": "string", "enum": []string{"low", "high"}}
+	assert.NoError(t, ValidateJSON(enum, []byte(`"low"`)))
+	assert.Error(t, ValidateJSON(enum, []byte(`"medium"`)))
+
+	closed := map[string]any{"type": "object", "properties": map[string]any{}, "additionalProperties": false}
+	assert.Error(t, ValidateJSON(closed, []byte(`{"extra": 1}`)))
+}
+
+func TestCallModelStructuredOpenAI(t *testing.T) {
+	replies := []string{"I think it's safe.", "```json\n{\"safe\": true, \"score\": 7, \"issues\": null}\n```"}
+	var bodies []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		content, _ := json.Marshal(replies[len(bodies)-1])
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, fmt.Sprintf(`{
+			"id": "chatcmpl-%d", "object": "chat.completion", "created": 1, "model": "gpt-4o",
+			"choices": [{"index": 0, "finish_reason": "stop",
+				"message": {"role": "assistant", "content": %s}}],
+			"usage": {"prompt_tokens": 5, "completion_tokens": 5, "total_tokens": 10}
+		}`, len(bodies), content))
+	}))
+	defer srv.Close()
+	client := openai.NewClient(option.WithAPIKey("test"), option.WithBaseURL(srv.URL), option.WithMaxRetries(0))
+	m := &OpenAIModel{client: &client, model: shared.ChatModelGPT4o}
+
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+	cw, err := NewContextWindow(db, m, "structured")
+	assert.NoError(t, err)
+	assert.NoError(t, cw.AddPrompt("is `ls` safe?"))
+
+	var v verdict
+	err = cw.CallModelStructured(context.Background(), &v, StructuredOpts{Name: "verdict", Strict: true})
+	assert.NoError(t, err)
+	assert.Equal(t, verdict{Safe: true, Score: 7}, v)
+
+	assert.Len(t, bodies, 2)
+	format := bodies[0]["response_format"].(map[string]any)
+	assert.Equal(t, "json_schema", format["type"])
+	js := format["json_schema"].(map[string]any)
+	assert.Equal(t, "verdict", js["name"])
+	assert.Equal(t, true, js["strict"])
+	assert.Equal(t, false, js["schema"].(map[string]any)["additionalProperties"])
+	assert.Equal(t, []any{"issues", "safe", "score"}, js["schema"].(map[string]any)["required"])
+	msgs := bodies[1]["messages"].([]any)
+	last := msgs[len(msgs)-1].(map[string]any)
+	assert.Contains(t, fmt.Sprint(last["content"]), "rejected")
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 2)
+	assert.Equal(t, "is `ls` safe?", recs[0].Content)
+	assert.Equal(t, ModelResp, recs[1].Source)
+	assert.Contains(t, recs[1].Content, `"score": 7`)
+}
+
+// scriptedModel answers with each of its replies in turn, recording the
+// prompts it was sent. Once out of replies, it fails with err if it's set.
+type scriptedModel struct {
+	replies []string
+	inputs  [][]Record
+	err     error
+}
+
+func (m *scriptedModel) Call(ctx context.Context, inputs []Record) ([]Record, int, error) {
+	m.inputs = append(m.inputs, inputs)
+	if m.err != nil && len(m.inputs) > len(m.replies) {
+		return nil, 0, m.err
+	}
+	reply := m.replies[min(len(m.inputs), len(m.replies))-1]
+	return []Record{{Source: ModelResp, Content: reply, Live: true}}, 1, nil
+}
+
+func TestCallModelStructuredPrompted(t *testing.T) {
+	m := &scriptedModel{replies: []string{`{"safe": true, "score": 2}`}}
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+	cw, err := NewContextWindow(db, m, "prompted")
+	assert.NoError(t, err)
+	assert.NoError(t, cw.AddPrompt("is `ls` safe?"))
+
+	var v verdict
+	assert.NoError(t, cw.CallModelStructured(context.Background(), &v, StructuredOpts{}))
+	assert.Equal(t, verdict{Safe: true, Score: 2}, v)
+
+	sent := m.inputs[0]
+	assert.Contains(t, sent[len(sent)-1].Content, `"required"`)
+
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 2)
+
+	m.replies = []string{`{"safe": "maybe"}`}
+	err = cw.CallModelStructured(context.Background(), &v, StructuredOpts{MaxAttempts: 2})
+	var serr *StructuredOutputError
+	assert.True(t, errors.As(err, &serr))
+	assert.Equal(t, 2, serr.Attempts)
+	assert.Equal(t, `{"safe": "maybe"}`, serr.Response)
+
+	assert.Error(t, cw.CallModelStructured(context.Background(), v, StructuredOpts{}))
+}
+
+func TestCallModelStructuredModelError(t *testing.T) {
+	m := &scriptedModel{replies: []string{`not json`}, err: errors.New("overloaded")}
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+	cw, err := NewContextWindow(db, m, "failing")
+	assert.NoError(t, err)
+	assert.NoError(t, cw.AddPrompt("is `ls` safe?"))
+
+	var v verdict
+	err = cw.CallModelStructured(context.Background(), &v, StructuredOpts{})
+	assert.ErrorContains(t, err, "overloaded")
+	assert.Len(t, m.inputs, 2)
+
+	// The schema prompt and the correction are gone; the rejected answer
+	// is too
+	recs, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	if assert.Len(t, recs, 1) {
+		assert.Equal(t, "is `ls` safe?", recs[0].Content)
+	}
+}
+
+func TestClaudeResponseTool(t *testing.T) {
+	schema, err := SchemaFor(verdict{})
+	assert.NoError(t, err)
+	rs := &ResponseSchema{Name: "verdict", Schema: schema}
+
+	tool := claudeResponseTool(rs)
+	js, err := json.Marshal(tool)
+	assert.NoError(t, err)
+	assert.Contains(t, string(js), `"name":"verdict"`)
+	assert.Contains(t, string(js), `"required":["safe","score"]`)
+
+	content := []anthropic.ContentBlockUnion{
+		{Type: "text", Text: "here you go"},
+		{Type: "tool_use", Name: "verdict", Input: json.RawMessage(`{"safe":true,"score":1}`)},
+	}
+	assert.JSONEq(t, `{"safe":true,"score":1}`, string(claudeResponseInput(content, rs)))
+	assert.Nil(t, claudeResponseInput(content, nil))
+	assert.Nil(t, claudeResponseInput(content[:1], rs))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	_ "embed"
 	"fmt"
+	"time"
 )
 
 //go:embed default_summarize.md
@@ -28,6 +29,18 @@ func (cw *ContextWindow) SetSummarizerPrompt(prompt string) {
 	cw.summarizerPrompt = prompt
 }
 
+// SummarizeOpts limits summarization to a prefix of the live context, so
+// the most recent exchanges stay verbatim. If both limits are set, whichever
+// keeps more records wins. System prompts are never summarized.
+type SummarizeOpts struct {
+	// KeepTurns is the number of trailing turns to keep. A turn starts at a
+	// Prompt record and runs up to the next one.
+	KeepTurns int
+
+	// KeepTokens keeps as many trailing records as fit in this many tokens.
+	KeepTokens int
+}
+
 func (cw *ContextWindow) SummarizeLiveContext(ctx context.Context) (*SummaryResult, error) {
 	return cw.SummarizeLiveContextInContext(ctx, cw.currentContext)
 }
@@ -35,25 +48,96 @@ func (cw *ContextWindow) SummarizeLiveContext(ctx context.Context) (*SummaryResu
 func (cw *ContextWindow) SummarizeLiveContextInContext(
 	ctx context.Context,
 	contextName string,
+) (*SummaryResult, error) {
+	return cw.SummarizeLiveContextInContextWithOpts(ctx, contextName, SummarizeOpts{})
+}
+
+// SummarizeLiveContextWithOpts summarizes the older part of the current
+// context, as described by opts.
+func (cw *ContextWindow) SummarizeLiveContextWithOpts(
+	ctx context.Context,
+	opts SummarizeOpts,
+) (*SummaryResult, error) {
+	return cw.SummarizeLiveContextInContextWithOpts(ctx, cw.currentContext, opts)
+}
+
+func (cw *ContextWindow) SummarizeLiveContextInContextWithOpts(
+	ctx context.Context,
+	contextName string,
+	opts SummarizeOpts,
 ) (*SummaryResult, error) {
 	if cw.summarizer == nil {
 		return nil, fmt.Errorf("no summarizer configured")
 	}
 
-	contextID, err := getContextIDByName(cw.db, contextName)
+	contextID, err := cw.contextIDByName(contextName)
 	if err != nil {
 		return nil, fmt.Errorf("summarize context: %w", err)
 	}
 
-	liveRecords, err := ListLiveRecords(cw.db, contextID)
+	liveRecords, err := cw.store.ListLiveRecords(contextID)
 	if err != nil {
 		return nil, fmt.Errorf("get live records: %w", err)
 	}
 
-	if len(liveRecords) == 0 {
+	toSummarize := opts.prefix(liveRecords)
+	if len(toSummarize) == 0 {
 		return nil, fmt.Errorf("no live records to summarize")
 	}
 
+	return cw.summarizeRecords(ctx, contextID, toSummarize)
+}
+
+// prefix returns the records to summarize: everything before the kept tail,
+// minus system prompts. The tail never starts inside a tool round.
+func (o SummarizeOpts) prefix(recs []Record) []Record {
+	cut := len(recs)
+
+	if o.KeepTurns > 0 {
+		turns := 0
+		for i := len(recs) - 1; i >= 0; i-- {
+			if recs[i].Source == Prompt {
+				turns++
+				if turns == o.KeepTurns {
+					cut = min(cut, i)
+					break
+				}
+			}
+		}
+		if turns < o.KeepTurns {
+			cut = 0
+		}
+	}
+
+	if o.KeepTokens > 0 {
+		// Keep or summarize tool rounds whole, so that no tool call is
+		// left without its output.
+		units := recordUnits(recs)
+		kept := 0
+		i := len(recs)
+		for u := len(units) - 1; u >= 0 && kept+unitTokens(units[u]) <= o.KeepTokens; u-- {
+			kept += unitTokens(units[u])
+			i -= len(units[u])
+		}
+		cut = min(cut, i)
+	}
+
+	var out []Record
+	for _, r := range recs[:cut] {
+		if r.Source != SystemPrompt {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// summarizeRecords asks the summarizer to summarize recs, which become the
+// Replaced records of the result.
+func (cw *ContextWindow) summarizeRecords(
+	ctx context.Context,
+	contextID string,
+	liveRecords []Record,
+) (*SummaryResult, error) {
 	origCount := 0
 	for _, r := range liveRecords {
 		origCount += r.EstTokens
@@ -73,9 +157,21 @@ func (cw *ContextWindow) SummarizeLiveContextInContext(
 		},
 	}, liveRecords...)
 
-	events, _, err := cw.summarizer.Call(ctx, summaryInput)
+	obs := &modelCallObserver{}
+	started := time.Now()
+	events, tokensUsed, err := cw.summarizer.Call(withModelCallObserver(ctx, obs), summaryInput)
 	if err != nil {
-		return nil, fmt.Errorf("summarizer call failed: %w", err)
+		return nil, cw.failModelCall(contextID, obs, started, fmt.Errorf("summarizer call failed: %w", err))
+	}
+
+	for i, event := range events {
+		if event.Usage != nil {
+			events[i].Cost = cw.prices.Cost(event.Model, *event.Usage)
+		}
+	}
+	call := cw.ledgerEntry(contextID, obs, started, tokensUsed, events, nil)
+	if err := cw.recordModelCall(call); err != nil {
+		return nil, err
 	}
 
 	if len(events) == 0 {
@@ -88,7 +184,7 @@ func (cw *ContextWindow) SummarizeLiveContextInContext(
 		Summary:      summary,
 		Replaced:     liveRecords,
 		OrigCount:    origCount,
-		SummaryCount: tokenCount(summary),
+		SummaryCount: cw.countTokens(summary),
 	}, nil
 }
 
@@ -98,39 +194,49 @@ func (cw *ContextWindow) AcceptSummary(
 	return cw.AcceptSummaryInContext(result, cw.currentContext)
 }
 
+// AcceptSummaryInContext replaces the summarized records with the summary,
+// which takes the place of the oldest of them so that it stays ahead of any
+// records that were kept.
 func (cw *ContextWindow) AcceptSummaryInContext(
 	result *SummaryResult,
 	contextName string,
 ) error {
-	contextID, err := getContextIDByName(cw.db, contextName)
+	contextID, err := cw.contextIDByName(contextName)
 	if err != nil {
 		return fmt.Errorf("accept summary: %w", err)
 	}
 
-	tx, err := cw.db.Begin()
-	if err != nil {
-		return fmt.Errorf("begin transaction: %w", err)
+	at := time.Now().UTC()
+	if len(result.Replaced) > 0 {
+		at = result.Replaced[0].Timestamp
 	}
-	defer tx.Rollback()
+	return cw.acceptSummaryAt(result, contextID, at)
+}
 
+// acceptSummaryAt replaces result's records with the summary, timestamped
+// at, which determines where the summary sits in the record order.
+func (cw *ContextWindow) acceptSummaryAt(
+	result *SummaryResult,
+	contextID string,
+	at time.Time,
+) error {
+	var kill []int64
 	for _, record := range result.Replaced {
-		if err := markRecordNotAlive(tx, record.ID); err != nil {
-			return fmt.Errorf("mark record %d not alive: %w", record.ID, err)
-		}
+		kill = append(kill, record.ID)
 	}
 
-	_, err = insertRecordTx(
-		tx,
-		contextID,
-		ModelResp,
-		result.Summary,
-		true,
-	)
+	_, err := cw.store.ReplaceRecords(kill, []Record{{
+		ContextID: contextID,
+		Timestamp: at,
+		Source:    ModelResp,
+		Content:   result.Summary,
+		Live:      true,
+		EstTokens: cw.countTokens(result.Summary),
+	}})
 	if err != nil {
 		return fmt.Errorf("insert summary: %w", err)
 	}
-
-	return tx.Commit()
+	return nil
 }
 
 func (cw *ContextWindow) RejectSummary(
@@ -254,3 +254,95 @@ func (m *mockSummarizerWithInputCapture) Call(
 		},
 	}, m.tokensUsed, nil
 }
+
+func TestSummarizeKeepTurns(t *testing.T) {
+	cw := setupTestDB(t)
+	defer cw.Close()
+
+	summarizer := &mockSummarizerWithInputCapture{summaryText: "summary"}
+	cw.SetSummarizer(summarizer)
+
+	assert.NoError(t, cw.SetSystemPrompt("system"))
+	assert.NoError(t, cw.AddPrompt("first"))
+	assert.NoError(t, cw.AddToolCall("tool", "{}"))
+	assert.NoError(t, cw.AddToolOutput("output"))
+	assert.NoError(t, cw.AddPrompt("second"))
+	assert.NoError(t, cw.AddPrompt("third"))
+
+	result, err := cw.SummarizeLiveContextWithOpts(context.Background(), SummarizeOpts{KeepTurns: 2})
+	assert.NoError(t, err)
+	assert.Len(t, result.Replaced, 3)
+	assert.Len(t, summarizer.lastInputs, 4)
+	for _, r := range result.Replaced {
+		assert.NotEqual(t, SystemPrompt, r.Source)
+	}
+
+	assert.NoError(t, cw.AcceptSummary(result))
+
+	live, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	var contents []string
+	for _, r := range live {
+		contents = append(contents, r.Content)
+	}
+	assert.Equal(t, []string{"system", "summary", "second", "third"}, contents)
+}
+
+func TestSummarizeKeepTokens(t *testing.T) {
+	cw := setupTestDB(t)
+	defer cw.Close()
+
+	cw.SetSummarizer(&mockSummarizer{summaryText: "summary"})
+
+	assert.NoError(t, cw.AddPrompt("one two three four"))
+	assert.NoError(t, cw.AddToolCall("tool", "{}"))
+	assert.NoError(t, cw.AddToolOutput("five six"))
+	assert.NoError(t, cw.AddPrompt("seven eight"))
+
+	live, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	budget := live[2].EstTokens + live[3].EstTokens
+
+	// The tool output fits, but its call doesn't, so both are summarized.
+	result, err := cw.SummarizeLiveContextWithOpts(context.Background(), SummarizeOpts{KeepTokens: budget})
+	assert.NoError(t, err)
+	assert.Len(t, result.Replaced, 3)
+	assert.Equal(t, ToolOutput, result.Replaced[2].Source)
+}
+
+func TestSummarizeKeepTokensInsideToolRound(t *testing.T) {
+	cw := setupTestDB(t)
+	defer cw.Close()
+
+	cw.SetSummarizer(&mockSummarizer{summaryText: "summary"})
+
+	assert.NoError(t, cw.AddPrompt("one two three four"))
+	assert.NoError(t, cw.AddToolCallWithID("call_1", "first", "{}"))
+	assert.NoError(t, cw.AddToolOutputWithID("call_1", "first", "five"))
+	assert.NoError(t, cw.AddToolCallWithID("call_2", "second", "{}"))
+	assert.NoError(t, cw.AddToolOutputWithID("call_2", "second", "six"))
+	assert.NoError(t, cw.AddPrompt("seven eight"))
+
+	live, err := cw.LiveRecords()
+	assert.NoError(t, err)
+	budget := live[3].EstTokens + live[4].EstTokens + live[5].EstTokens
+
+	// The second call and its output fit, but they're in the same round as
+	// the first, which doesn't, so the whole round is summarized.
+	result, err := cw.SummarizeLiveContextWithOpts(context.Background(), SummarizeOpts{KeepTokens: budget})
+	assert.NoError(t, err)
+	assert.Len(t, result.Replaced, 5)
+}
+
+func TestSummarizeNothingOlderThanKept(t *testing.T) {
+	cw := setupTestDB(t)
+	defer cw.Close()
+
+	cw.SetSummarizer(&mockSummarizer{summaryText: "summary"})
+	assert.NoError(t, cw.SetSystemPrompt("system"))
+	assert.NoError(t, cw.AddPrompt("only"))
+
+	_, err := cw.SummarizeLiveContextWithOpts(context.Background(), SummarizeOpts{KeepTurns: 1})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no live records to summarize")
+}
@@ -0,0 +1,174 @@
+package contextwindow
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/peterheb/gotoken"
+	_ "github.com/peterheb/gotoken/cl100kbase"
+)
+
+// Tokenizer estimates how many tokens a model will see in a piece of text.
+// Record.EstTokens, LiveTokens and the token budgets of summarization and
+// compaction all come from it.
+type Tokenizer interface {
+	// Name identifies the tokenizer. Records are re-estimated when a context
+	// is opened with a tokenizer of a different name than it was estimated
+	// with.
+	Name() string
+	CountTokens(s string) int
+}
+
+// TokenizerCapable is an optional interface for models that know how their
+// provider tokenizes text. A ContextWindow uses the model's tokenizer unless
+// one is set with [ContextWindow.SetTokenizer]; models that don't implement
+// this get [CL100KTokenizer].
+type TokenizerCapable interface {
+	Tokenizer() Tokenizer
+}
+
+// encodingTokenizer counts tokens with a gotoken encoding, falling back to
+// counting words if the encoding isn't registered.
+type encodingTokenizer struct {
+	encoding string
+
+	once sync.Once
+	name string
+	tok  gotoken.Tokenizer
+}
+
+// NewEncodingTokenizer returns a Tokenizer for a tiktoken encoding
+// registered with [github.com/peterheb/gotoken], such as "cl100k_base".
+// Import the encoding's package to register it.
+func NewEncodingTokenizer(encoding string) Tokenizer {
+	return &encodingTokenizer{encoding: encoding}
+}
+
+func (e *encodingTokenizer) load() {
+	e.once.Do(func() {
+		tok, err := gotoken.GetTokenizer(e.encoding)
+		if err != nil {
+			e.name = "words"
+			return
+		}
+		e.name, e.tok = e.encoding, tok
+	})
+}
+
+func (e *encodingTokenizer) Name() string {
+	e.load()
+	return e.name
+}
+
+func (e *encodingTokenizer) CountTokens(s string) int {
+	e.load()
+	if e.tok == nil {
+		return len(strings.Fields(s))
+	}
+	return e.tok.Count(s)
+}
+
+var (
+	cl100kTokenizer = NewEncodingTokenizer("cl100k_base")
+	claudeTokenizer = &scaledTokenizer{
+		name:   "claude-approx",
+		base:   cl100kTokenizer,
+		factor: claudeTokenRatio,
+	}
+)
+
+// CL100KTokenizer returns the cl100k_base tokenizer used by GPT-4 and
+// GPT-3.5 models. It's the default, and what the OpenAI adapters use: newer
+// models use o200k_base, which gotoken doesn't ship, and which cl100k_base
+// approximates closely for English text.
+func CL100KTokenizer() Tokenizer {
+	return cl100kTokenizer
+}
+
+// claudeTokenRatio is roughly how many Claude tokens there are per
+// cl100k_base token in English prose and code.
+const claudeTokenRatio = 1.15
+
+// ClaudeTokenizer returns an approximation of the Claude tokenizer, which
+// Anthropic doesn't publish: cl100k_base counts, scaled up to match what the
+// API reports. Use the provider-reported Usage for exact numbers.
+func ClaudeTokenizer() Tokenizer {
+	return claudeTokenizer
+}
+
+// scaledTokenizer scales another tokenizer's counts.
+type scaledTokenizer struct {
+	name   string
+	base   Tokenizer
+	factor float64
+}
+
+func (s *scaledTokenizer) Name() string {
+	return fmt.Sprintf("%s/%s", s.name, s.base.Name())
+}
+
+func (s *scaledTokenizer) CountTokens(text string) int {
+	return int(math.Ceil(float64(s.base.CountTokens(text)) * s.factor))
+}
+
+// tokenCount estimates tokens with the default tokenizer.
+func tokenCount(s string) int {
+	return cl100kTokenizer.CountTokens(s)
+}
+
+// SetTokenizer sets the tokenizer used to estimate record sizes, overriding
+// the model's, and re-estimates the current context's records with it.
+func (cw *ContextWindow) SetTokenizer(t Tokenizer) error {
+	cw.tokenizer = t
+	contextID, err := cw.contextIDByName(cw.currentContext)
+	if err != nil {
+		return fmt.Errorf("set tokenizer: %w", err)
+	}
+	return cw.syncTokenizer(contextID)
+}
+
+// Tokenizer returns the tokenizer used to estimate record sizes.
+func (cw *ContextWindow) Tokenizer() Tokenizer {
+	return cw.tokenizer
+}
+
+// countTokens estimates the tokens in s with the window's tokenizer.
+func (cw *ContextWindow) countTokens(s string) int {
+	return cw.tokenizer.CountTokens(s)
+}
+
+// syncTokenizer re-estimates a context's records if they were estimated
+// with a different tokenizer than the window's.
+func (cw *ContextWindow) syncTokenizer(contextID string) error {
+	c, err := cw.store.GetContext(contextID)
+	if err != nil {
+		return fmt.Errorf("get context: %w", err)
+	}
+	name := cw.tokenizer.Name()
+	if c.Tokenizer == name {
+		return nil
+	}
+
+	recs, err := cw.store.ListRecords(contextID)
+	if err != nil {
+		return fmt.Errorf("list records: %w", err)
+	}
+	tokens := make(map[int64]int)
+	for _, r := range recs {
+		if n := recordTokens(cw.tokenizer, r); n != r.EstTokens {
+			tokens[r.ID] = n
+		}
+	}
+	if len(tokens) > 0 {
+		if err := cw.store.SetRecordTokens(tokens); err != nil {
+			return fmt.Errorf("re-estimate records: %w", err)
+		}
+	}
+
+	if err := cw.store.SetContextTokenizer(contextID, name); err != nil {
+		return fmt.Errorf("set context tokenizer: %w", err)
+	}
+	return nil
+}
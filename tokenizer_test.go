@@ -0,0 +1,80 @@
+package contextwindow
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// charTokenizer counts one token per character.
+type charTokenizer struct{}
+
+func (charTokenizer) Name() string             { return "chars" }
+func (charTokenizer) CountTokens(s string) int { return len(s) }
+
+func TestModelTokenizers(t *testing.T) {
+	assert.Same(t, CL100KTokenizer(), (&OpenAIModel{model: "gpt-4o"}).Tokenizer())
+	assert.Same(t, CL100KTokenizer(), (&OpenAIResponsesModel{model: "gpt-5"}).Tokenizer())
+
+	text := strings.Repeat("the quick brown fox jumps over the lazy dog. ", 20)
+	base := CL100KTokenizer().CountTokens(text)
+	assert.Greater(t, ClaudeTokenizer().CountTokens(text), base)
+	assert.Equal(t, ClaudeTokenizer(), (&ClaudeModel{}).Tokenizer())
+}
+
+func TestTokenizerReestimatesRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cw.db")
+	db, err := NewContextDB(path)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cw, err := NewContextWindow(db, &mockModel{}, "tokens")
+	assert.NoError(t, err)
+	assert.Equal(t, "cl100k_base", cw.Tokenizer().Name())
+
+	assert.NoError(t, cw.AddPrompt("hello there, model"))
+	live, err := cw.LiveTokens()
+	assert.NoError(t, err)
+	assert.Equal(t, CL100KTokenizer().CountTokens("hello there, model"), live)
+
+	assert.NoError(t, cw.SetTokenizer(charTokenizer{}))
+	live, err = cw.LiveTokens()
+	assert.NoError(t, err)
+	assert.Equal(t, len("hello there, model"), live)
+
+	assert.NoError(t, cw.AddPrompt("again"))
+	live, err = cw.LiveTokens()
+	assert.NoError(t, err)
+	assert.Equal(t, len("hello there, model")+len("again"), live)
+
+	info, err := cw.GetCurrentContextInfo()
+	assert.NoError(t, err)
+	assert.Equal(t, "chars", info.Tokenizer)
+
+	// Opening the context with the default tokenizer re-estimates it again.
+	cw, err = NewContextWindow(db, &mockModel{}, "tokens")
+	assert.NoError(t, err)
+	live, err = cw.LiveTokens()
+	assert.NoError(t, err)
+	assert.Equal(t,
+		CL100KTokenizer().CountTokens("hello there, model")+CL100KTokenizer().CountTokens("again"),
+		live)
+}
+
+func TestTokenizerReestimatesOnSwitch(t *testing.T) {
+	store := NewMemoryStore()
+	cw, err := NewContextWindowWithStore(store, &mockModel{}, "a")
+	assert.NoError(t, err)
+	assert.NoError(t, cw.SwitchContext("b"))
+	assert.NoError(t, cw.AddPrompt("four"))
+
+	assert.NoError(t, cw.SwitchContext("a"))
+	assert.NoError(t, cw.SetTokenizer(charTokenizer{}))
+
+	assert.NoError(t, cw.SwitchContext("b"))
+	live, err := cw.LiveTokens()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, live)
+}
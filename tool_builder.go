@@ -1,6 +1,8 @@
 package contextwindow
 
 import (
+	"sort"
+
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/openai/openai-go/v2"
 )
@@ -10,6 +12,7 @@ type ParameterType string
 const (
 	ParameterTypeString  ParameterType = "string"
 	ParameterTypeNumber  ParameterType = "number"
+	ParameterTypeInteger ParameterType = "integer"
 	ParameterTypeBoolean ParameterType = "boolean"
 	ParameterTypeArray   ParameterType = "array"
 	ParameterTypeObject  ParameterType = "object"
@@ -20,8 +23,10 @@ type Parameter struct {
 	Type        ParameterType
 	Description string
 	Required    bool
-	Items       *Parameter
-	Properties  map[string]*Parameter
+	// Nullable allows null as well as a value of Type.
+	Nullable   bool
+	Items      *Parameter
+	Properties map[string]*Parameter
 }
 
 type ToolBuilder struct {
@@ -115,37 +120,7 @@ func (tb *ToolBuilder) ToOpenAI() openai.FunctionDefinitionParam {
 }
 
 func (tb *ToolBuilder) parameterToOpenAISchema(param *Parameter) map[string]any {
-	schema := map[string]any{
-		"type": string(param.Type),
-	}
-
-	if param.Description != "" {
-		schema["description"] = param.Description
-	}
-
-	switch param.Type {
-	case ParameterTypeArray:
-		if param.Items != nil {
-			schema["items"] = tb.parameterToOpenAISchema(param.Items)
-		}
-	case ParameterTypeObject:
-		if param.Properties != nil {
-			properties := make(map[string]any)
-			required := make([]string, 0)
-			for name, prop := range param.Properties {
-				properties[name] = tb.parameterToOpenAISchema(prop)
-				if prop.Required {
-					required = append(required, name)
-				}
-			}
-			schema["properties"] = properties
-			if len(required) > 0 {
-				schema["required"] = required
-			}
-		}
-	}
-
-	return schema
+	return parameterSchema(param)
 }
 
 func (tb *ToolBuilder) ToClaude() anthropic.ToolParam {
@@ -160,7 +135,7 @@ func (tb *ToolBuilder) ToClaude() anthropic.ToolParam {
 	}
 
 	return anthropic.ToolParam{
-		Name: tb.name,
+		Name:        tb.name,
 		Description: anthropic.String(tb.description),
 		InputSchema: anthropic.ToolInputSchemaParam{
 			Properties: properties,
@@ -170,8 +145,18 @@ func (tb *ToolBuilder) ToClaude() anthropic.ToolParam {
 }
 
 func (tb *ToolBuilder) parameterToClaudeSchema(param *Parameter) map[string]interface{} {
-	schema := map[string]interface{}{
-		"type": string(param.Type),
+	return parameterSchema(param)
+}
+
+// parameterSchema renders a Parameter as JSON Schema. A Parameter with no
+// Type accepts any value.
+func parameterSchema(param *Parameter) map[string]any {
+	schema := map[string]any{}
+	switch {
+	case param.Type != "" && param.Nullable:
+		schema["type"] = []string{string(param.Type), "null"}
+	case param.Type != "":
+		schema["type"] = string(param.Type)
 	}
 
 	if param.Description != "" {
@@ -181,18 +166,19 @@ func (tb *ToolBuilder) parameterToClaudeSchema(param *Parameter) map[string]inte
 	switch param.Type {
 	case ParameterTypeArray:
 		if param.Items != nil {
-			schema["items"] = tb.parameterToClaudeSchema(param.Items)
+			schema["items"] = parameterSchema(param.Items)
 		}
 	case ParameterTypeObject:
 		if param.Properties != nil {
-			properties := make(map[string]interface{})
+			properties := make(map[string]any)
 			required := make([]string, 0)
 			for name, prop := range param.Properties {
-				properties[name] = tb.parameterToClaudeSchema(prop)
+				properties[name] = parameterSchema(prop)
 				if prop.Required {
 					required = append(required, name)
 				}
 			}
+			sort.Strings(required)
 			schema["properties"] = properties
 			if len(required) > 0 {
 				schema["required"] = required
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 )
 
 // TODO(tqbf): this is all pretty gnarly and half-baked, but comes of having
@@ -34,7 +36,11 @@ type ToolExecutor interface {
 	GetRegisteredTools() []ToolDefinition
 }
 
-// RegisterTool registers a tool with this ContextWindow instance and stores the tool name as a hint in the database.
+// RegisterTool adds a tool to this ContextWindow's registry and enables it in
+// the current context. Tools are available only in the contexts they're
+// enabled in (see [ContextWindow.EnableTool]); enablement is stored in the
+// database, but runners aren't, so register every tool you use each time you
+// create a ContextWindow.
 func (cw *ContextWindow) RegisterTool(name string, definition interface{}, runner ToolRunner) error {
 	cw.registeredTools[name] = ToolDefinition{
 		Name:       name,
@@ -42,56 +48,412 @@ func (cw *ContextWindow) RegisterTool(name string, definition interface{}, runne
 	}
 	cw.toolRunners[name] = runner
 
-	// Store the tool name in the database as a hint
-	contextID, err := getContextIDByName(cw.db, cw.currentContext)
-	if err != nil {
+	if err := cw.enableTool(name); err != nil {
 		return fmt.Errorf("register tool: %w", err)
 	}
-	_, err = AddContextTool(cw.db, contextID, name)
+	return nil
+}
+
+// EnableTool makes a registered tool available in the current context.
+func (cw *ContextWindow) EnableTool(name string) error {
+	if _, ok := cw.toolRunners[name]; !ok {
+		return fmt.Errorf("enable tool: tool '%s' not registered", name)
+	}
+	if err := cw.enableTool(name); err != nil {
+		return fmt.Errorf("enable tool: %w", err)
+	}
+	return nil
+}
+
+func (cw *ContextWindow) enableTool(name string) error {
+	contextID, err := cw.contextIDByName(cw.currentContext)
 	if err != nil {
-		return fmt.Errorf("register tool: %w", err)
+		return err
+	}
+	has, err := cw.store.HasContextTool(contextID, name)
+	if err != nil || has {
+		return err
+	}
+	_, err = cw.store.AddContextTool(contextID, name)
+	return err
+}
+
+// DisableTool makes a tool unavailable in the current context. The tool
+// stays registered, and enabled in any other contexts.
+func (cw *ContextWindow) DisableTool(name string) error {
+	contextID, err := cw.contextIDByName(cw.currentContext)
+	if err != nil {
+		return fmt.Errorf("disable tool: %w", err)
+	}
+	if err := cw.store.RemoveContextTool(contextID, name); err != nil {
+		return fmt.Errorf("disable tool: %w", err)
 	}
 	return nil
 }
 
-// GetTool retrieves a registered tool runner by name.
+// GetTool retrieves a registered tool runner by name, whether or not it's
+// enabled in the current context.
 func (cw *ContextWindow) GetTool(name string) (ToolRunner, bool) {
 	runner, exists := cw.toolRunners[name]
 	return runner, exists
 }
 
-// ExecuteTool implements ToolExecutor interface.
+// ExecuteTool implements ToolExecutor interface. Only tools enabled in the
+// current context can run.
 func (cw *ContextWindow) ExecuteTool(ctx context.Context, name string, args json.RawMessage) (string, error) {
 	runner, exists := cw.toolRunners[name]
 	if !exists {
 		return "", fmt.Errorf("tool '%s' not registered", name)
 	}
+	enabled, err := cw.HasTool(name)
+	if err != nil {
+		return "", err
+	}
+	if !enabled {
+		return "", fmt.Errorf("tool '%s' not enabled in context '%s'", name, cw.currentContext)
+	}
 	return runner.Run(ctx, args)
 }
 
-// GetRegisteredTools returns all registered tool definitions.
+// GetRegisteredTools returns the definitions of the registered tools enabled
+// in the current context, in the order they were enabled.
 func (cw *ContextWindow) GetRegisteredTools() []ToolDefinition {
+	names, err := cw.ListTools()
+	if err != nil {
+		return nil
+	}
+
 	var tools []ToolDefinition
-	for _, toolDef := range cw.registeredTools {
-		tools = append(tools, toolDef)
+	for _, name := range names {
+		if toolDef, ok := cw.registeredTools[name]; ok {
+			tools = append(tools, toolDef)
+		}
 	}
 	return tools
 }
 
-// ListTools returns the names of all tools available in this context.
+// ListTools returns the names of all tools enabled in this context, including
+// any that haven't been registered with this ContextWindow.
 func (cw *ContextWindow) ListTools() ([]string, error) {
-	contextID, err := getContextIDByName(cw.db, cw.currentContext)
+	contextID, err := cw.contextIDByName(cw.currentContext)
+	if err != nil {
+		return nil, fmt.Errorf("list tools: %w", err)
+	}
+	tools, err := cw.store.ListContextTools(contextID)
 	if err != nil {
 		return nil, fmt.Errorf("list tools: %w", err)
 	}
-	return ListContextToolNames(cw.db, contextID)
+	var names []string
+	for _, t := range tools {
+		names = append(names, t.ToolName)
+	}
+	return names, nil
 }
 
 // HasTool checks if a tool name is available in this context.
 func (cw *ContextWindow) HasTool(name string) (bool, error) {
-	contextID, err := getContextIDByName(cw.db, cw.currentContext)
+	contextID, err := cw.contextIDByName(cw.currentContext)
 	if err != nil {
 		return false, fmt.Errorf("has tool: %w", err)
 	}
-	return HasContextTool(cw.db, contextID, name)
+	return cw.store.HasContextTool(contextID, name)
+}
+
+// DefaultToolConcurrency is how many tool calls from a single model turn run
+// at once, unless changed with [ContextWindow.SetToolConcurrency].
+const DefaultToolConcurrency = 4
+
+// SetToolConcurrency limits how many tool calls from a single model turn run
+// at once. 1 runs them one after another.
+func (cw *ContextWindow) SetToolConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	cw.toolConcurrency = n
+}
+
+// ToolConcurrency returns the limit set with SetToolConcurrency.
+func (cw *ContextWindow) ToolConcurrency() int {
+	return cw.toolConcurrency
+}
+
+// ToolApproval is a [ToolApprover]'s decision on one tool call. The zero
+// value allows the call as the model made it.
+type ToolApproval struct {
+	// Deny stops the call from running; Reason is sent back to the model as
+	// the tool's output.
+	Deny   bool
+	Reason string
+
+	// Args, if set, replaces the arguments the model supplied, both for
+	// the tool and in the call the model is shown afterwards.
+	Args json.RawMessage
+}
+
+// ToolApprover vets tool calls before they run, for instance by asking a
+// human whether a shell command is OK. Returning an error fails the call as
+// if the tool had.
+type ToolApprover interface {
+	ApproveToolCall(ctx context.Context, name string, args json.RawMessage) (ToolApproval, error)
+}
+
+// ToolApproverFunc allows functions to implement ToolApprover.
+type ToolApproverFunc func(ctx context.Context, name string, args json.RawMessage) (ToolApproval, error)
+
+func (f ToolApproverFunc) ApproveToolCall(ctx context.Context, name string, args json.RawMessage) (ToolApproval, error) {
+	return f(ctx, name, args)
+}
+
+// ToolDeniedError is the error middleware sees for a call a ToolApprover
+// denied.
+type ToolDeniedError struct {
+	Tool   string
+	Reason string
+}
+
+func (e *ToolDeniedError) Error() string {
+	return fmt.Sprintf("tool '%s' denied: %s", e.Tool, e.Reason)
+}
+
+// SetToolApprover installs an approver consulted before every tool call in
+// every context; pass nil to run tool calls unchecked. Approvers may be
+// called concurrently (see SetToolConcurrency).
+func (cw *ContextWindow) SetToolApprover(approver ToolApprover) {
+	cw.toolApprover = approver
+}
+
+// ToolApprover returns the approver set with SetToolApprover, if any.
+func (cw *ContextWindow) ToolApprover() ToolApprover {
+	return cw.toolApprover
+}
+
+// toolApproverSource is implemented by ToolExecutors that vet tool calls,
+// like ContextWindow.
+type toolApproverSource interface {
+	ToolApprover() ToolApprover
+}
+
+// toolConcurrencyLimiter is implemented by ToolExecutors that limit tool
+// call concurrency, like ContextWindow.
+type toolConcurrencyLimiter interface {
+	ToolConcurrency() int
+}
+
+// pendingToolCall is one tool call requested by a model.
+type pendingToolCall struct {
+	ID   string
+	Name string
+	Args string
+}
+
+// toolCallResult is the outcome of a pendingToolCall. Args are the
+// arguments the tool ran with, which a ToolApprover may have rewritten. Err
+// is the tool's error, already folded into Output for the model.
+type toolCallResult struct {
+	Args   string
+	Output string
+	Err    error
+}
+
+// runToolCalls executes calls through executor, concurrently up to the
+// executor's limit, firing middleware and stream events for each. Each call
+// gets at most timeout, if it's set. Results are in the same order as calls.
+func runToolCalls(
+	ctx context.Context,
+	executor ToolExecutor,
+	middleware []Middleware,
+	stream chan<- StreamEvent,
+	calls []pendingToolCall,
+	timeout time.Duration,
+) []toolCallResult {
+	limit := DefaultToolConcurrency
+	if l, ok := executor.(toolConcurrencyLimiter); ok {
+		limit = max(l.ToolConcurrency(), 1)
+	}
+
+	results := make([]toolCallResult, len(calls))
+	if limit == 1 || len(calls) == 1 {
+		for i, call := range calls {
+			results[i] = runToolCall(ctx, executor, middleware, stream, call, timeout)
+		}
+		return results
+	}
+
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = runToolCall(ctx, executor, middleware, stream, call, timeout)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func runToolCall(
+	ctx context.Context,
+	executor ToolExecutor,
+	middleware []Middleware,
+	stream chan<- StreamEvent,
+	call pendingToolCall,
+	timeout time.Duration,
+) toolCallResult {
+	var approver ToolApprover
+	if s, ok := executor.(toolApproverSource); ok {
+		approver = s.ToolApprover()
+	}
+
+	args := call.Args
+	var (
+		approval ToolApproval
+		err      error
+	)
+	if approver != nil {
+		approval, err = approver.ApproveToolCall(ctx, call.Name, json.RawMessage(args))
+		if err == nil && approval.Args != nil {
+			args = string(approval.Args)
+		}
+	}
+
+	for _, m := range middleware {
+		m.OnToolCall(ctx, call.Name, args)
+	}
+	streamToolCall(ctx, stream, call.Name, args)
+
+	var out string
+	switch {
+	case err != nil:
+		out = fmt.Sprintf("error: %s", err)
+	case approval.Deny:
+		out = approval.Reason
+		err = &ToolDeniedError{Tool: call.Name, Reason: approval.Reason}
+	default:
+		toolCtx := ctx
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			toolCtx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		out, err = executor.ExecuteTool(toolCtx, call.Name, json.RawMessage(args))
+		if err != nil {
+			out = fmt.Sprintf("error: %s", err)
+		}
+	}
+
+	for _, m := range middleware {
+		m.OnToolResult(ctx, call.Name, out, err)
+	}
+	streamToolResult(ctx, stream, call.Name, out, err)
+
+	return toolCallResult{Args: args, Output: out, Err: err}
+}
+
+// ToolLoopLimit identifies which CallModelOpts limit stopped a tool loop.
+type ToolLoopLimit string
+
+const (
+	LimitToolRounds  ToolLoopLimit = "max tool rounds"
+	LimitTokenBudget ToolLoopLimit = "token budget"
+	LimitTimeBudget  ToolLoopLimit = "time budget"
+)
+
+// ToolLoopError is returned when a model call stops calling tools because
+// it hit a limit set in CallModelOpts. Events holds the tool calls and
+// outputs from the rounds that completed; the context window persists them
+// before returning the error.
+type ToolLoopError struct {
+	Limit      ToolLoopLimit
+	Rounds     int
+	Events     []Record
+	TokensUsed int
+}
+
+func (e *ToolLoopError) Error() string {
+	return fmt.Sprintf("tool loop stopped after %d rounds: %s exceeded", e.Rounds, e.Limit)
+}
+
+// toolLoop enforces the tool loop limits in CallModelOpts for one call.
+type toolLoop struct {
+	opts   CallModelOpts
+	start  time.Time
+	rounds int
+}
+
+func newToolLoop(opts CallModelOpts) *toolLoop {
+	return &toolLoop{opts: opts, start: time.Now()}
+}
+
+// next is called before each tool round. It returns a *ToolLoopError if the
+// round would go over a limit.
+func (l *toolLoop) next(events []Record, tokensUsed int) error {
+	var limit ToolLoopLimit
+	switch {
+	case l.opts.MaxToolRounds > 0 && l.rounds >= l.opts.MaxToolRounds:
+		limit = LimitToolRounds
+	case l.opts.TokenBudget > 0 && tokensUsed >= l.opts.TokenBudget:
+		limit = LimitTokenBudget
+	case l.opts.TimeBudget > 0 && time.Since(l.start) >= l.opts.TimeBudget:
+		limit = LimitTimeBudget
+	default:
+		l.rounds++
+		return nil
+	}
+
+	return &ToolLoopError{
+		Limit:      limit,
+		Rounds:     l.rounds,
+		Events:     events,
+		TokensUsed: tokensUsed,
+	}
+}
+
+// toolCallRecords builds the ToolCall and ToolOutput records for one
+// executed tool call, for persistence by the context window. failed marks
+// the output as a tool error.
+func toolCallRecords(tok Tokenizer, id, name, args, out string, failed bool) []Record {
+	call := fmt.Sprintf("%s(%s)", name, args)
+	return []Record{
+		{
+			Source:     ToolCall,
+			Content:    call,
+			Live:       true,
+			EstTokens:  tok.CountTokens(call),
+			ToolCallID: id,
+			ToolName:   name,
+			ToolArgs:   rawToolArgs(args),
+		},
+		{
+			Source:     ToolOutput,
+			Content:    out,
+			Live:       true,
+			EstTokens:  tok.CountTokens(out),
+			ToolCallID: id,
+			ToolName:   name,
+			ToolError:  failed,
+		},
+	}
+}
+
+// rawToolArgs returns args as raw JSON, or nil if it isn't valid JSON.
+func rawToolArgs(args string) json.RawMessage {
+	if !json.Valid([]byte(args)) {
+		return nil
+	}
+	return json.RawMessage(args)
+}
+
+// toolArgsOrEmpty returns the raw arguments of a ToolCall record, or an
+// empty JSON object if none were stored.
+func toolArgsOrEmpty(rec Record) json.RawMessage {
+	if len(rec.ToolArgs) == 0 {
+		return json.RawMessage("{}")
+	}
+	return rec.ToolArgs
 }
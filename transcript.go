@@ -0,0 +1,235 @@
+package contextwindow
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go/v2"
+)
+
+// TranscriptFormat is the output format of a transcript.
+type TranscriptFormat int
+
+const (
+	// TranscriptMarkdown renders a readable Markdown document, for bug
+	// reports and pasting into issues.
+	TranscriptMarkdown TranscriptFormat = iota
+	// TranscriptHTML renders a standalone HTML page, with images inline.
+	TranscriptHTML
+	// TranscriptOpenAIJSONL renders a line of OpenAI chat fine-tuning data:
+	// {"messages": [...]}, with system, user, assistant and tool messages.
+	TranscriptOpenAIJSONL
+	// TranscriptAnthropicJSONL renders a line of Anthropic Messages data:
+	// {"system": [...], "messages": [...]}, with tool_use and tool_result
+	// blocks.
+	TranscriptAnthropicJSONL
+)
+
+// TranscriptOpts controls how a transcript is rendered.
+type TranscriptOpts struct {
+	Format TranscriptFormat
+
+	// All renders the full history, including records that aren't live
+	// (compacted, rewound or dropped); by default only live records are.
+	All bool
+
+	// StrikeDead strikes through records that aren't live, in Markdown and
+	// HTML transcripts of the full history.
+	StrikeDead bool
+}
+
+// RenderTranscript renders an exported context as a transcript. JSONL
+// transcripts are one line per context, so transcripts of several contexts
+// can be concatenated into a dataset; they're converted the way the model
+// adapters send records, attachments included.
+func RenderTranscript(export ContextExport, opts TranscriptOpts) ([]byte, error) {
+	var recs []Record
+	for _, r := range export.Records {
+		if r.Live || opts.All {
+			recs = append(recs, r)
+		}
+	}
+
+	switch opts.Format {
+	case TranscriptMarkdown:
+		return markdownTranscript(export.Context, recs, opts), nil
+	case TranscriptHTML:
+		return htmlTranscript(export.Context, recs, opts)
+	case TranscriptOpenAIJSONL:
+		return jsonLine(struct {
+			Messages []openai.ChatCompletionMessageParamUnion `json:"messages"`
+		}{openAIMessages(recs)})
+	case TranscriptAnthropicJSONL:
+		system, messages := claudeMessages(recs)
+		return jsonLine(struct {
+			System   []anthropic.TextBlockParam `json:"system,omitempty"`
+			Messages []anthropic.MessageParam   `json:"messages"`
+		}{system, messages})
+	}
+	return nil, fmt.Errorf("unknown transcript format %d", opts.Format)
+}
+
+// ExportTranscript renders a context as a transcript by name.
+func ExportTranscript(db *sql.DB, name string, opts TranscriptOpts) ([]byte, error) {
+	export, err := ExportContextByName(db, name)
+	if err != nil {
+		return nil, err
+	}
+	return RenderTranscript(export, opts)
+}
+
+func jsonLine(v any) ([]byte, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal transcript: %w", err)
+	}
+	return append(js, '\n'), nil
+}
+
+// transcriptEntry is a record as Markdown and HTML transcripts show it.
+type transcriptEntry struct {
+	Label       string
+	Text        string
+	Code        bool   // Text is preformatted
+	Lang        string // of code, for Markdown fences
+	Attachments []Attachment
+	Dead        bool // struck through
+	Timestamp   time.Time
+}
+
+func transcriptEntries(recs []Record, opts TranscriptOpts) []transcriptEntry {
+	entries := make([]transcriptEntry, 0, len(recs))
+	for _, r := range recs {
+		e := transcriptEntry{
+			Text:        r.Content,
+			Attachments: r.Attachments,
+			Dead:        !r.Live && opts.StrikeDead,
+			Timestamp:   r.Timestamp,
+		}
+		switch r.Source {
+		case SystemPrompt:
+			e.Label = "System"
+		case Prompt:
+			e.Label = "User"
+		case ModelResp:
+			e.Label = "Assistant"
+			if r.Model != "" {
+				e.Label += " (" + r.Model + ")"
+			}
+		case ToolCall:
+			e.Label, e.Code = "Tool call", true
+			if r.ToolName != "" {
+				e.Label += ": " + r.ToolName
+			}
+			var args bytes.Buffer
+			if len(r.ToolArgs) > 0 && json.Indent(&args, r.ToolArgs, "", "  ") == nil {
+				e.Text, e.Lang = args.String(), "json"
+			}
+		case ToolOutput:
+			e.Label, e.Code = "Tool output", true
+			if r.ToolName != "" {
+				e.Label += ": " + r.ToolName
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func markdownTranscript(c Context, recs []Record, opts TranscriptOpts) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n_Started %s_\n", c.Name, c.StartTime.UTC().Format(time.RFC3339))
+
+	for _, e := range transcriptEntries(recs, opts) {
+		if e.Dead {
+			fmt.Fprintf(&b, "\n### ~~%s~~ (not live)\n\n", e.Label)
+		} else {
+			fmt.Fprintf(&b, "\n### %s\n\n", e.Label)
+		}
+
+		switch {
+		case e.Code:
+			fence := markdownFence(e.Text)
+			fmt.Fprintf(&b, "%s%s\n%s\n%s\n", fence, e.Lang, e.Text, fence)
+		case e.Dead:
+			for _, line := range strings.Split(e.Text, "\n") {
+				if strings.TrimSpace(line) != "" {
+					line = "~~" + line + "~~"
+				}
+				b.WriteString(line + "\n")
+			}
+		default:
+			b.WriteString(e.Text + "\n")
+		}
+
+		for _, a := range e.Attachments {
+			fmt.Fprintf(&b, "\n_Attachment: %s (%s, %d bytes)_\n", a.filename(), a.MediaType, len(a.Data))
+		}
+	}
+	return []byte(b.String())
+}
+
+// markdownFence returns a code fence longer than any run of backticks in
+// text.
+func markdownFence(text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+var htmlTranscriptTemplate = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"dataURL":  func(a Attachment) template.URL { return template.URL(a.dataURL()) },
+	"filename": func(a Attachment) string { return a.filename() },
+	"rfc3339":  func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Context.Name}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; line-height: 1.5; }
+section { border-left: 3px solid #ccc; margin: 1em 0; padding: 0 1em; }
+h2 { font-size: 1em; margin: 0; color: #555; }
+.text { white-space: pre-wrap; }
+pre { background: #f6f6f6; padding: .5em; overflow-x: auto; }
+img { max-width: 100%; }
+.dead { text-decoration: line-through; opacity: .6; }
+</style>
+</head>
+<body>
+<h1>{{.Context.Name}}</h1>
+<p>Started {{rfc3339 .Context.StartTime}}</p>
+{{range .Entries}}<section{{if .Dead}} class="dead"{{end}} title="{{rfc3339 .Timestamp}}">
+<h2>{{.Label}}{{if .Dead}} (not live){{end}}</h2>
+{{if .Code}}<pre>{{.Text}}</pre>{{else}}<div class="text">{{.Text}}</div>{{end}}
+{{range .Attachments}}{{if .IsImage}}<p><img src="{{dataURL .}}" alt="{{filename .}}"></p>
+{{else}}<p>Attachment: {{filename .}} ({{.MediaType}}, {{len .Data}} bytes)</p>
+{{end}}{{end}}</section>
+{{end}}</body>
+</html>
+`))
+
+func htmlTranscript(c Context, recs []Record, opts TranscriptOpts) ([]byte, error) {
+	var b bytes.Buffer
+	err := htmlTranscriptTemplate.Execute(&b, struct {
+		Context Context
+		Entries []transcriptEntry
+	}{c, transcriptEntries(recs, opts)})
+	if err != nil {
+		return nil, fmt.Errorf("render transcript: %w", err)
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,108 @@
+package contextwindow
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportTranscript(t *testing.T) {
+	db, err := NewContextDB(":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	m := &dummyModel{events: []Record{
+		{Source: ToolCall, Content: `ls({"dir":"."})`, Live: true, ToolCallID: "call_1", ToolName: "ls", ToolArgs: json.RawMessage(`{"dir":"."}`)},
+		{Source: ToolOutput, Content: "a.txt\n```b.md```", Live: true, ToolCallID: "call_1", ToolName: "ls"},
+		{Source: ModelResp, Content: "two files", Live: true},
+	}}
+	cw, err := NewContextWindow(db, m, "bug")
+	assert.NoError(t, err)
+	assert.NoError(t, cw.SetSystemPrompt("be brief"))
+	assert.NoError(t, cw.AddPrompt("wrong question"))
+	assert.NoError(t, cw.SetRecordLiveStateByRange(1, 1, false))
+	assert.NoError(t, cw.AddPromptWithAttachments("list <files>", NewAttachment("dot.png", "image/png", []byte("\x89PNG\r\n\x1a\n"))))
+	_, err = cw.CallModel(context.Background())
+	assert.NoError(t, err)
+
+	t.Run("markdown", func(t *testing.T) {
+		md, err := cw.ExportTranscript("bug", TranscriptOpts{})
+		assert.NoError(t, err)
+		s := string(md)
+		assert.True(t, strings.HasPrefix(s, "# bug\n"))
+		assert.NotContains(t, s, "wrong question")
+		assert.Contains(t, s, "### System\n\nbe brief\n")
+		assert.Contains(t, s, "_Attachment: dot.png (image/png, 8 bytes)_")
+		assert.Contains(t, s, "### Tool call: ls\n\n```json\n{\n  \"dir\": \".\"\n}\n```\n")
+		assert.Contains(t, s, "### Tool output: ls\n\n````\na.txt\n```b.md```\n````\n")
+		assert.Contains(t, s, "### Assistant\n\ntwo files\n")
+
+		md, err = cw.ExportTranscript("bug", TranscriptOpts{All: true, StrikeDead: true})
+		assert.NoError(t, err)
+		assert.Contains(t, string(md), "### ~~User~~ (not live)\n\n~~wrong question~~\n")
+
+		md, err = cw.ExportTranscript("bug", TranscriptOpts{All: true})
+		assert.NoError(t, err)
+		assert.Contains(t, string(md), "### User\n\nwrong question\n")
+	})
+
+	t.Run("html", func(t *testing.T) {
+		page, err := ExportTranscript(db, "bug", TranscriptOpts{Format: TranscriptHTML, All: true, StrikeDead: true})
+		assert.NoError(t, err)
+		s := string(page)
+		assert.True(t, strings.HasPrefix(s, "<!DOCTYPE html>"))
+		assert.Contains(t, s, "list &lt;files&gt;")
+		assert.Contains(t, s, `<img src="data:image/png;base64,`)
+		assert.Contains(t, s, `<section class="dead"`)
+		assert.Equal(t, 1, strings.Count(s, `class="dead"`))
+	})
+
+	t.Run("openai jsonl", func(t *testing.T) {
+		line, err := cw.ExportTranscript("bug", TranscriptOpts{Format: TranscriptOpenAIJSONL})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(string(line), "\n"))
+
+		var got struct {
+			Messages []map[string]any `json:"messages"`
+		}
+		assert.NoError(t, json.Unmarshal(line, &got))
+		var roles []any
+		for _, msg := range got.Messages {
+			roles = append(roles, msg["role"])
+		}
+		assert.Equal(t, []any{"system", "user", "assistant", "tool", "assistant"}, roles)
+		assert.Equal(t, "call_1", got.Messages[3]["tool_call_id"])
+		calls := got.Messages[2]["tool_calls"].([]any)
+		assert.Equal(t, map[string]any{"name": "ls", "arguments": `{"dir":"."}`}, calls[0].(map[string]any)["function"])
+	})
+
+	t.Run("anthropic jsonl", func(t *testing.T) {
+		line, err := cw.ExportTranscript("bug", TranscriptOpts{Format: TranscriptAnthropicJSONL})
+		assert.NoError(t, err)
+
+		var got struct {
+			System   []map[string]any `json:"system"`
+			Messages []struct {
+				Role    string           `json:"role"`
+				Content []map[string]any `json:"content"`
+			} `json:"messages"`
+		}
+		assert.NoError(t, json.Unmarshal(line, &got))
+		assert.Equal(t, "be brief", got.System[0]["text"])
+		var roles, blocks []string
+		for _, msg := range got.Messages {
+			roles = append(roles, msg.Role)
+			for _, b := range msg.Content {
+				blocks = append(blocks, b["type"].(string))
+			}
+		}
+		assert.Equal(t, []string{"user", "assistant", "user", "assistant"}, roles)
+		assert.Equal(t, []string{"image", "text", "tool_use", "tool_result", "text"}, blocks)
+	})
+
+	_, err = cw.ExportTranscript("bug", TranscriptOpts{Format: 99})
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+package contextwindow
+
+import (
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/responses"
+)
+
+// ModelUsage breaks down the tokens a provider reported, for one request or
+// summed over several.
+//
+// InputTokens counts every input token, cached or not; CacheReadTokens and
+// CacheWriteTokens say how many of them were read from or written to the
+// prompt cache. OutputTokens likewise includes ReasoningTokens.
+type ModelUsage struct {
+	InputTokens      int `json:"input_tokens"`
+	OutputTokens     int `json:"output_tokens"`
+	CacheReadTokens  int `json:"cache_read_tokens,omitempty"`
+	CacheWriteTokens int `json:"cache_write_tokens,omitempty"`
+	ReasoningTokens  int `json:"reasoning_tokens,omitempty"`
+}
+
+// Total returns input plus output tokens.
+func (u ModelUsage) Total() int {
+	return u.InputTokens + u.OutputTokens
+}
+
+// Add returns the sum of u and v.
+func (u ModelUsage) Add(v ModelUsage) ModelUsage {
+	return ModelUsage{
+		InputTokens:      u.InputTokens + v.InputTokens,
+		OutputTokens:     u.OutputTokens + v.OutputTokens,
+		CacheReadTokens:  u.CacheReadTokens + v.CacheReadTokens,
+		CacheWriteTokens: u.CacheWriteTokens + v.CacheWriteTokens,
+		ReasoningTokens:  u.ReasoningTokens + v.ReasoningTokens,
+	}
+}
+
+// claudeUsage converts Anthropic usage, whose input_tokens excludes cache
+// reads and writes.
+func claudeUsage(u anthropic.Usage) ModelUsage {
+	return ModelUsage{
+		InputTokens:      int(u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens),
+		OutputTokens:     int(u.OutputTokens),
+		CacheReadTokens:  int(u.CacheReadInputTokens),
+		CacheWriteTokens: int(u.CacheCreationInputTokens),
+	}
+}
+
+func openAIChatUsage(u openai.CompletionUsage) ModelUsage {
+	return ModelUsage{
+		InputTokens:     int(u.PromptTokens),
+		OutputTokens:    int(u.CompletionTokens),
+		CacheReadTokens: int(u.PromptTokensDetails.CachedTokens),
+		ReasoningTokens: int(u.CompletionTokensDetails.ReasoningTokens),
+	}
+}
+
+func responsesUsage(u responses.ResponseUsage) ModelUsage {
+	return ModelUsage{
+		InputTokens:     int(u.InputTokens),
+		OutputTokens:    int(u.OutputTokens),
+		CacheReadTokens: int(u.InputTokensDetails.CachedTokens),
+		ReasoningTokens: int(u.OutputTokensDetails.ReasoningTokens),
+	}
+}